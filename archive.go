@@ -0,0 +1,174 @@
+package speed
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/performancecopilot/speed/v4/archive"
+	"github.com/pkg/errors"
+)
+
+// ArchiveRecorder records the metrics in a PCPRegistry to a PCP archive, so they can be
+// replayed later with pmrep, pmdumplog or Grafana on hosts that do not run pmlogger.
+//
+// Metrics are named and identified the same way as by a PMDA with the same name and domain,
+// so "requests" is recorded as "name.requests". Every call to Record appends the values
+// of all metrics in the registry, along with the descriptions and instance domains of metrics
+// added since the last call.
+//
+// An ArchiveRecorder is a Collector, so it can be added to a Sampler to record at a fixed interval.
+type ArchiveRecorder struct {
+	mutex sync.Mutex
+
+	pmda *PMDA
+	w    *archive.Writer
+
+	// the metrics and instance domains described in the archive so far
+	descs  map[uint32]bool
+	indoms map[uint32]bool
+}
+
+// NewArchiveRecorder creates a new archive with the passed base name, made of the files
+// base.meta, base.0 and base.index, recording the metrics in the passed registry
+// under the passed name and domain number
+func NewArchiveRecorder(base, name string, domain int, registry *PCPRegistry) (*ArchiveRecorder, error) {
+	p, err := NewPMDA(name, domain, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := archive.Create(base, archive.Label{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveRecorder{
+		pmda:   p,
+		w:      w,
+		descs:  make(map[uint32]bool),
+		indoms: make(map[uint32]bool),
+	}, nil
+}
+
+// Registry returns the registry recorded by the ArchiveRecorder
+func (r *ArchiveRecorder) Registry() Registry { return r.pmda.r }
+
+// Collect records the current values of all metrics in the registry
+func (r *ArchiveRecorder) Collect() error { return r.Record(time.Now()) }
+
+// Record records the values of all metrics in the registry at the passed time,
+// which cannot be before the time of the last record
+func (r *ArchiveRecorder) Record(t time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := r.pmda.names()
+
+	metrics := make([]PCPMetric, 0, len(names))
+	for _, m := range names {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return r.pmda.pmid(metrics[i]) < r.pmda.pmid(metrics[j]) })
+
+	result := archive.Result{Time: t, Sets: make([]archive.ValueSet, 0, len(metrics))}
+
+	for _, m := range metrics {
+		if err := r.describe(m, t); err != nil {
+			return err
+		}
+
+		s := archive.ValueSet{PMID: r.pmda.pmid(m)}
+		for _, v := range r.pmda.values(m) {
+			s.Values = append(s.Values, archive.Value{Inst: v.inst, Val: v.val})
+		}
+
+		result.Sets = append(result.Sets, s)
+	}
+
+	if err := r.w.PutResult(result); err != nil {
+		return errors.Wrap(err, "failed to record metrics")
+	}
+
+	return r.w.Flush()
+}
+
+// describe writes the description and help texts of a metric and its instance domain
+// the first time they are recorded
+func (r *ArchiveRecorder) describe(m PCPMetric, t time.Time) error {
+	pmid := r.pmda.pmid(m)
+
+	if !r.descs[pmid] {
+		var unit uint32
+		if m.Unit() != nil {
+			unit = m.Unit().PMAPI()
+		}
+
+		desc := archive.Desc{
+			PMID:      pmid,
+			Type:      int32(m.Type()),
+			Indom:     r.pmda.indom(m.Indom()),
+			Semantics: int32(m.Semantics()),
+			Units:     unit,
+		}
+
+		if err := r.w.PutDesc(desc, r.pmda.name+"."+r.pmda.r.metricName(m)); err != nil {
+			return err
+		}
+
+		if err := r.putTexts(archive.TextPMID, pmid, m.ShortDescription(), m.LongDescription()); err != nil {
+			return err
+		}
+
+		r.descs[pmid] = true
+	}
+
+	indom := m.Indom()
+	if indom == nil {
+		return nil
+	}
+
+	id := r.pmda.indom(indom)
+	if r.indoms[id] {
+		return nil
+	}
+
+	in := archive.Indom{Time: t, Indom: id}
+	for _, i := range sortedInstances(indom) {
+		in.Instances = append(in.Instances, instanceID(i))
+		in.Names = append(in.Names, i.name)
+	}
+
+	if err := r.w.PutIndom(in); err != nil {
+		return err
+	}
+
+	if err := r.putTexts(archive.TextIndom, id, indom.shortDescription, indom.longDescription); err != nil {
+		return err
+	}
+
+	r.indoms[id] = true
+	return nil
+}
+
+func (r *ArchiveRecorder) putTexts(typ int32, ident uint32, short, long string) error {
+	if short != "" {
+		if err := r.w.PutText(archive.Text{Type: typ | archive.TextOneline, Ident: ident, Text: short}); err != nil {
+			return err
+		}
+	}
+
+	if long != "" {
+		return r.w.PutText(archive.Text{Type: typ | archive.TextHelp, Ident: ident, Text: long})
+	}
+
+	return nil
+}
+
+// Close finishes the archive, after which nothing more can be recorded
+func (r *ArchiveRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.w.Close()
+}
@@ -0,0 +1,148 @@
+// Package archive writes and reads PCP archives in the version 3 format written by pmlogger,
+// so metrics can be recorded on hosts without pmlogger and replayed later with pmrep, pmdumplog
+// or Grafana.
+//
+// An archive is made of three files sharing a base name, the metadata in base.meta holding
+// descriptions, names, instance domains and help texts, the values in the data volume base.0
+// and the temporal index in base.index, mapping times to offsets in the other two files.
+// All of them start with a label and store everything in network byte order.
+//
+// The layout follows LOGARCHIVE(5)
+//
+// https://github.com/performancecopilot/pcp/blob/main/man/man5/logarchive.5
+package archive
+
+import (
+	"time"
+)
+
+// Magic is the magic number in archive labels, with the archive version in the low byte
+const Magic = 0x50052600 | Version
+
+// Version is the version of the archive format
+const Version = 3
+
+// volume numbers for the metadata and the index, data volumes are numbered from 0
+const (
+	MetaVolume  = -1
+	IndexVolume = -2
+)
+
+// metadata record types
+const (
+	descRecord  = 1
+	textRecord  = 4
+	indomRecord = 5
+)
+
+// Metric types, which are the types of values in an archive
+const (
+	Int32Type  = 0
+	Uint32Type = 1
+	Int64Type  = 2
+	Uint64Type = 3
+	FloatType  = 4
+	DoubleType = 5
+	StringType = 6
+)
+
+// IndomNull is the instance domain of metrics that have a single value
+const IndomNull = 0xffffffff
+
+// InstNull is the instance of the single value of a metric without an instance domain
+const InstNull = -1
+
+// Text types, a text is either the one line or the full help text of either a metric or an instance domain
+const (
+	TextOneline = 1
+	TextHelp    = 2
+	TextPMID    = 4
+	TextIndom   = 8
+)
+
+// value formats in results
+const (
+	valInsitu = 0
+	valDptr   = 1
+)
+
+// Label is the label at the start of every file in an archive
+type Label struct {
+	PID      int32
+	Start    time.Time
+	Volume   int32
+	Features uint16
+	Hostname string
+	Timezone string
+	Zoneinfo string
+}
+
+// Desc describes a metric
+type Desc struct {
+	PMID      uint32
+	Type      int32
+	Indom     uint32
+	Semantics int32
+	Units     uint32
+}
+
+// MetricDesc is a description of a metric along with its names
+type MetricDesc struct {
+	Desc
+	Names []string
+}
+
+// Indom holds the instances of an instance domain from a point in time
+type Indom struct {
+	Time      time.Time
+	Indom     uint32
+	Instances []int32
+	Names     []string
+}
+
+// Text is a help text of a metric or an instance domain
+type Text struct {
+	Type  int32 // TextOneline or TextHelp along with TextPMID or TextIndom
+	Ident uint32
+	Text  string
+}
+
+// Value is the value of an instance of a metric, which is one of int32, uint32,
+// int64, uint64, float32, float64 or string
+type Value struct {
+	Inst int32
+	Val  interface{}
+}
+
+// ValueSet holds the values of a metric in a result, or the error fetching them in Err,
+// which is a negative PCP error code
+type ValueSet struct {
+	PMID   uint32
+	Err    int32
+	Values []Value
+}
+
+// Result holds the values of a set of metrics at a point in time
+type Result struct {
+	Time time.Time
+	Sets []ValueSet
+}
+
+// IndexEntry is an entry in the temporal index, with the offsets of the records
+// following the time in the metadata and the data volume
+type IndexEntry struct {
+	Time    time.Time
+	Volume  int32
+	MetaOff int64
+	DataOff int64
+}
+
+// Archive holds everything read from an archive
+type Archive struct {
+	Label   Label
+	Descs   []*MetricDesc
+	Indoms  []*Indom
+	Texts   []*Text
+	Results []*Result
+	Index   []*IndexEntry
+}
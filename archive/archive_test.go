@@ -0,0 +1,308 @@
+package archive
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempArchive(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test"), func() { _ = os.RemoveAll(dir) }
+}
+
+func TestRoundTrip(t *testing.T) {
+	base, cleanup := tempArchive(t)
+	defer cleanup()
+
+	start := time.Unix(1600000000, 123456789)
+	label := Label{PID: 42, Start: start, Hostname: "host", Timezone: "UTC+0"}
+
+	w, err := Create(base, label)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descs := []*MetricDesc{
+		{Desc{1, Int32Type, IndomNull, 1, 0}, []string{"test.int32"}},
+		{Desc{2, Uint32Type, IndomNull, 3, 0}, []string{"test.uint32"}},
+		{Desc{3, Int64Type, 7, 1, 0}, []string{"test.int64", "test.alias"}},
+		{Desc{4, Uint64Type, IndomNull, 1, 0}, []string{"test.uint64"}},
+		{Desc{5, FloatType, IndomNull, 4, 0}, []string{"test.float"}},
+		{Desc{6, DoubleType, IndomNull, 4, 0}, []string{"test.double"}},
+		{Desc{7, StringType, IndomNull, 5, 0}, []string{"test.string"}},
+	}
+
+	for _, d := range descs {
+		if err := w.PutDesc(d.Desc, d.Names...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	indom := &Indom{start, 7, []int32{0, 1}, []string{"a", "bcd"}}
+	if err := w.PutIndom(*indom); err != nil {
+		t.Fatal(err)
+	}
+
+	text := &Text{TextPMID | TextOneline, 1, "an int32"}
+	if err := w.PutText(*text); err != nil {
+		t.Fatal(err)
+	}
+
+	results := []*Result{
+		{start.Add(time.Second), []ValueSet{
+			{1, 0, []Value{{InstNull, int32(-1)}}},
+			{2, 0, []Value{{InstNull, uint32(1 << 31)}}},
+			{3, 0, []Value{{0, int64(-1 << 40)}, {1, int64(1)}}},
+			{4, 0, []Value{{InstNull, uint64(1 << 63)}}},
+			{5, 0, []Value{{InstNull, float32(1.5)}}},
+			{6, 0, []Value{{InstNull, float64(-2.25)}}},
+			{7, 0, []Value{{InstNull, "hello"}}},
+		}},
+		{start.Add(2 * time.Second), []ValueSet{
+			{1, -12357, nil},
+			{3, 0, nil},
+			{7, 0, []Value{{InstNull, ""}}},
+		}},
+	}
+
+	for _, r := range results {
+		if err := w.PutResult(*r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.PutResult(Result{Time: start}); err == nil {
+		t.Error("expected writing a result out of order to fail")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Read(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	label.Volume = MetaVolume
+	if !a.Label.Start.Equal(start) {
+		t.Errorf("expected the archive to start at %v, got %v", start, a.Label.Start)
+	}
+	a.Label.Start = start
+	if !reflect.DeepEqual(a.Label, label) {
+		t.Errorf("expected label %+v, got %+v", label, a.Label)
+	}
+
+	if !reflect.DeepEqual(a.Descs, descs) {
+		t.Errorf("expected descs %v, got %v", descs, a.Descs)
+	}
+
+	if len(a.Indoms) != 1 || !reflect.DeepEqual(a.Indoms[0].Instances, indom.Instances) || !reflect.DeepEqual(a.Indoms[0].Names, indom.Names) {
+		t.Errorf("expected indom %v, got %v", indom, a.Indoms)
+	}
+
+	if len(a.Texts) != 1 || !reflect.DeepEqual(a.Texts[0], text) {
+		t.Errorf("expected text %v, got %v", text, a.Texts)
+	}
+
+	if len(a.Results) != len(results) {
+		t.Fatalf("expected %v results, got %v", len(results), len(a.Results))
+	}
+
+	for i, r := range a.Results {
+		if !r.Time.Equal(results[i].Time) {
+			t.Errorf("expected result %v at %v, got %v", i, results[i].Time, r.Time)
+		}
+
+		if !reflect.DeepEqual(r.Sets, results[i].Sets) {
+			t.Errorf("expected result %v to have %v, got %v", i, results[i].Sets, r.Sets)
+		}
+	}
+
+	// one entry before the first result and one at the end
+	if len(a.Index) != 2 {
+		t.Fatalf("expected 2 index entries, got %v", len(a.Index))
+	}
+
+	if first, last := a.Index[0], a.Index[1]; !first.Time.Equal(results[0].Time) || !last.Time.Equal(results[1].Time) ||
+		first.DataOff >= last.DataOff || first.MetaOff != last.MetaOff {
+		t.Errorf("unexpected index entries %+v and %+v", first, last)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	base, cleanup := tempArchive(t)
+	defer cleanup()
+
+	w, err := Create(base, Label{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ext := range []string{".meta", ".0", ".index"} {
+		data, err := ioutil.ReadFile(base + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := binary.BigEndian.Uint32(data)
+		if int(n) > len(data) || binary.BigEndian.Uint32(data[n-4:]) != n {
+			t.Errorf("%v: label is not framed by its length %v", ext, n)
+			continue
+		}
+
+		if m := binary.BigEndian.Uint32(data[4:]); m != Magic {
+			t.Errorf("%v: expected magic 0x%x, got 0x%x", ext, Magic, m)
+		}
+	}
+
+	if _, err := Create(base, Label{}); err == nil {
+		t.Error("expected creating an existing archive to fail")
+	}
+
+	a, err := Read(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Label.PID != int32(os.Getpid()) || a.Label.Hostname == "" || a.Label.Timezone == "" {
+		t.Errorf("expected the label to default to the current process, got %+v", a.Label)
+	}
+}
+
+func TestLabelStrings(t *testing.T) {
+	l := encodeLabel(&Label{Hostname: "host", Timezone: "UTC+0"})
+
+	// the lengths of the strings follow the magic, pid, start, volume and features
+	b := l[4+4+4+12+4+2:]
+	if hl, tl, zl := binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint16(b[4:]); hl != 5 || tl != 6 || zl != 0 {
+		t.Errorf("expected lengths including the NUL of 5, 6 and 0, got %v, %v and %v", hl, tl, zl)
+	}
+
+	if s := string(b[6 : len(b)-4]); s != "host\x00UTC+0\x00" {
+		t.Errorf("expected NUL terminated strings, got %q", s)
+	}
+
+	l = encodeLabel(&Label{Hostname: "host", Timezone: "UTC+0", Zoneinfo: ":Europe/London"})
+	if decoded, err := decodeLabel(l[4:len(l)-4], 0); err != nil || decoded.Hostname != "host" || decoded.Timezone != "UTC+0" || decoded.Zoneinfo != ":Europe/London" {
+		t.Errorf("unexpected label %+v, %v", decoded, err)
+	}
+}
+
+// TestPMDumpLog checks that pmdumplog from PCP can read archives written by the package
+func TestPMDumpLog(t *testing.T) {
+	pmdumplog, err := exec.LookPath("pmdumplog")
+	if err != nil {
+		t.Skip("pmdumplog is not installed")
+	}
+
+	base, cleanup := tempArchive(t)
+	defer cleanup()
+
+	w, err := Create(base, Label{Hostname: "speedhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.PutDesc(Desc{1, DoubleType, IndomNull, 1, 0}, "speed.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.PutResult(Result{time.Now(), []ValueSet{{1, 0, []Value{{InstNull, 1.5}}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(pmdumplog, "-a", base).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pmdumplog failed: %v\n%s", err, out)
+	}
+
+	for _, s := range []string{"speedhost", "speed.test"} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("expected %v in the output of pmdumplog\n%s", s, out)
+		}
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	base, cleanup := tempArchive(t)
+	defer cleanup()
+
+	w, err := Create(base, Label{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.PutDesc(Desc{1, DoubleType, IndomNull, 1, 0}, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.PutResult(Result{time.Now(), []ValueSet{{1, 0, []Value{{InstNull, 1.0}}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(base + ".0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0xff
+
+		if err := ioutil.WriteFile(base+".0", corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// corruption in values can go unnoticed, but nothing should panic
+		_, _ = Read(base)
+	}
+
+	if err := ioutil.WriteFile(base+".0", data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(base); err == nil {
+		t.Error("expected reading a truncated data volume to fail")
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	cases := []struct {
+		loc      *time.Location
+		expected string
+	}{
+		{time.UTC, "UTC+0"},
+		{time.FixedZone("AEST", 10*3600), "AEST-10"},
+		{time.FixedZone("IST", 5*3600+1800), "IST-5:30"},
+		{time.FixedZone("EST", -5*3600), "EST+5"},
+	}
+
+	for _, c := range cases {
+		if tz := timezone(time.Unix(0, 0).In(c.loc)); tz != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, tz)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// decoder decodes a record, the first read past the end of the record sets err
+// and every read after that returns zero values
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("truncated record")
+		return nil
+	}
+
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *decoder) uint16() uint16 {
+	if p := d.next(2); p != nil {
+		return binary.BigEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if p := d.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 { return int32(d.uint32()) }
+
+func (d *decoder) int64() int64 {
+	hi, lo := d.uint32(), d.uint32()
+	return int64(uint64(hi)<<32 | uint64(lo))
+}
+
+func (d *decoder) timestamp() time.Time {
+	sec, nsec := d.int64(), d.int32()
+	return time.Unix(sec, int64(nsec))
+}
+
+// lstring reads a NUL terminated string of the passed length including the NUL,
+// where a length of 0 is an empty string
+func (d *decoder) lstring(n int) string {
+	p := d.next(n)
+	if len(p) == 0 {
+		return ""
+	}
+
+	if p[n-1] != 0 {
+		d.err = errors.New("unterminated string")
+		return ""
+	}
+
+	return string(p[:n-1])
+}
+
+// cstring reads a NUL terminated string
+func (d *decoder) cstring() string {
+	for i, c := range d.b {
+		if c == 0 {
+			s := string(d.b[:i])
+			d.b = d.b[i+1:]
+			return s
+		}
+	}
+
+	d.err = errors.New("unterminated string")
+	return ""
+}
+
+// records splits the contents of a file into records, checking that the lengths
+// before and after every record match
+func records(data []byte) ([][]byte, error) {
+	var ans [][]byte
+
+	for off := 0; off < len(data); {
+		if len(data)-off < 8 {
+			return nil, errors.Errorf("truncated record at offset %v", off)
+		}
+
+		n := int(binary.BigEndian.Uint32(data[off:]))
+		if n < 8 || n > len(data)-off {
+			return nil, errors.Errorf("invalid record length %v at offset %v", n, off)
+		}
+
+		if t := int(binary.BigEndian.Uint32(data[off+n-4:])); t != n {
+			return nil, errors.Errorf("record at offset %v has length %v at the start and %v at the end", off, n, t)
+		}
+
+		ans = append(ans, data[off+4:off+n-4])
+		off += n
+	}
+
+	return ans, nil
+}
+
+func decodeLabel(b []byte, volume int32) (*Label, error) {
+	d := &decoder{b: b}
+
+	if m := d.uint32(); m != Magic {
+		return nil, errors.Errorf("bad label magic 0x%x, expected 0x%x", m, Magic)
+	}
+
+	l := &Label{PID: d.int32(), Start: d.timestamp(), Volume: d.int32(), Features: d.uint16()}
+	hl, tl, zl := int(d.uint16()), int(d.uint16()), int(d.uint16())
+	l.Hostname, l.Timezone, l.Zoneinfo = d.lstring(hl), d.lstring(tl), d.lstring(zl)
+
+	if d.err != nil {
+		return nil, errors.Wrap(d.err, "invalid label")
+	}
+
+	if l.Volume != volume {
+		return nil, errors.Errorf("label is for volume %v, expected %v", l.Volume, volume)
+	}
+
+	return l, nil
+}
+
+func (a *Archive) decodeMeta(b []byte) error {
+	d := &decoder{b: b}
+
+	switch t := d.int32(); t {
+	case descRecord:
+		m := &MetricDesc{Desc: Desc{d.uint32(), d.int32(), d.uint32(), d.int32(), d.uint32()}}
+
+		n := d.int32()
+		if n < 0 || int(n) > len(d.b)/4 {
+			return errors.Errorf("invalid name count %v", n)
+		}
+
+		for i := int32(0); i < n; i++ {
+			m.Names = append(m.Names, string(d.next(int(d.int32()))))
+		}
+
+		a.Descs = append(a.Descs, m)
+	case indomRecord:
+		in := &Indom{Time: d.timestamp(), Indom: d.uint32()}
+
+		n := d.int32()
+		if n < 0 || int(n) > len(d.b)/8 {
+			return errors.Errorf("invalid instance count %v", n)
+		}
+
+		offsets := make([]int32, n)
+		in.Instances = make([]int32, n)
+		for i := range in.Instances {
+			in.Instances[i] = d.int32()
+		}
+		for i := range offsets {
+			offsets[i] = d.int32()
+		}
+
+		names := d.b
+		for _, off := range offsets {
+			if off < 0 || int(off) >= len(names) {
+				return errors.Errorf("invalid instance name offset %v", off)
+			}
+
+			nd := &decoder{b: names[off:]}
+			in.Names = append(in.Names, nd.cstring())
+			if nd.err != nil {
+				return nd.err
+			}
+		}
+
+		a.Indoms = append(a.Indoms, in)
+	case textRecord:
+		a.Texts = append(a.Texts, &Text{Type: d.int32(), Ident: d.uint32(), Text: d.cstring()})
+	default:
+		return errors.Errorf("unknown metadata record type %v", t)
+	}
+
+	return d.err
+}
+
+// decodeValue decodes the value of an instance, which is either in the value list
+// or in a block in the record referred to by an offset
+func decodeValue(record []byte, valfmt int32, t int32, v uint32) (interface{}, error) {
+	if valfmt == valInsitu {
+		if t == Uint32Type {
+			return v, nil
+		}
+		return int32(v), nil
+	}
+
+	off := int64(v-pduHeaderWords) * 4
+	if v < pduHeaderWords || off+4 > int64(len(record)) {
+		return nil, errors.Errorf("invalid value block offset %v", v)
+	}
+
+	header := binary.BigEndian.Uint32(record[off:])
+	vtype, vlen := int32(header>>24), int64(header&0xffffff)
+	if vlen < 4 || off+vlen > int64(len(record)) {
+		return nil, errors.Errorf("invalid value block length %v", vlen)
+	}
+
+	data := record[off+4 : off+vlen]
+	need := map[int32]int{Int64Type: 8, Uint64Type: 8, DoubleType: 8, FloatType: 4}[vtype]
+	if len(data) < need {
+		return nil, errors.Errorf("value block of type %v is too short", vtype)
+	}
+
+	switch vtype {
+	case Int64Type:
+		return int64(binary.BigEndian.Uint64(data)), nil
+	case Uint64Type:
+		return binary.BigEndian.Uint64(data), nil
+	case DoubleType:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case FloatType:
+		return math.Float32frombits(binary.BigEndian.Uint32(data)), nil
+	case StringType:
+		d := &decoder{b: data}
+		s := d.cstring()
+		return s, d.err
+	}
+
+	return nil, errors.Errorf("unknown value type %v", vtype)
+}
+
+// decodeResult decodes a result, using the metric descriptions to tell signed
+// and unsigned values in value lists apart
+func decodeResult(b []byte, types map[uint32]int32) (*Result, error) {
+	d := &decoder{b: b}
+	r := &Result{Time: d.timestamp()}
+
+	n := d.int32()
+	if n < 0 || int(n) > len(d.b)/8 {
+		return nil, errors.Errorf("invalid metric count %v", n)
+	}
+
+	for i := int32(0); i < n; i++ {
+		s := ValueSet{PMID: d.uint32()}
+
+		numval := d.int32()
+		if numval < 0 {
+			s.Err = numval
+			r.Sets = append(r.Sets, s)
+			continue
+		}
+
+		if numval > 0 {
+			valfmt := d.int32()
+			if numval > int32(len(d.b)/8) {
+				return nil, errors.Errorf("invalid value count %v", numval)
+			}
+
+			for j := int32(0); j < numval; j++ {
+				inst, v := d.int32(), d.uint32()
+
+				val, err := decodeValue(b, valfmt, types[s.PMID], v)
+				if err != nil {
+					return nil, err
+				}
+
+				s.Values = append(s.Values, Value{inst, val})
+			}
+		}
+
+		r.Sets = append(r.Sets, s)
+	}
+
+	return r, d.err
+}
+
+func decodeIndexEntry(b []byte) *IndexEntry {
+	d := &decoder{b: b}
+	return &IndexEntry{Time: d.timestamp(), Volume: d.int32(), MetaOff: d.int64(), DataOff: d.int64()}
+}
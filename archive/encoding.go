@@ -0,0 +1,263 @@
+package archive
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// encoder builds a record in network byte order
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, 0, 0)
+	binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], v)
+}
+
+func (e *encoder) int32(v int32) { e.uint32(uint32(v)) }
+
+func (e *encoder) int64(v int64) {
+	e.uint32(uint32(uint64(v) >> 32))
+	e.uint32(uint32(v))
+}
+
+// timestamp encodes a time as 64 bit seconds, high word first, and 32 bit nanoseconds
+func (e *encoder) timestamp(t time.Time) {
+	e.int64(t.Unix())
+	e.int32(int32(t.Nanosecond()))
+}
+
+func (e *encoder) bytes(p []byte) { e.buf = append(e.buf, p...) }
+
+// pad pads the record with zeros to a whole number of words
+func (e *encoder) pad() {
+	for len(e.buf)%4 != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// record frames the encoded data with its length before and after it
+func (e *encoder) record() []byte {
+	n := uint32(len(e.buf) + 8)
+
+	r := make([]byte, 4, n)
+	binary.BigEndian.PutUint32(r, n)
+	r = append(r, e.buf...)
+	r = append(r, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(r[len(r)-4:], n)
+
+	return r
+}
+
+// encodeLabel encodes a label with NUL terminated strings, whose lengths include the NUL,
+// except for a missing zoneinfo, which has a length of 0
+func encodeLabel(l *Label) []byte {
+	zl := 0
+	if l.Zoneinfo != "" {
+		zl = len(l.Zoneinfo) + 1
+	}
+
+	e := &encoder{}
+	e.uint32(Magic)
+	e.int32(l.PID)
+	e.timestamp(l.Start)
+	e.int32(l.Volume)
+	e.uint16(l.Features)
+	e.uint16(uint16(len(l.Hostname) + 1))
+	e.uint16(uint16(len(l.Timezone) + 1))
+	e.uint16(uint16(zl))
+	e.bytes(append([]byte(l.Hostname), 0))
+	e.bytes(append([]byte(l.Timezone), 0))
+	if zl > 0 {
+		e.bytes(append([]byte(l.Zoneinfo), 0))
+	}
+	return e.record()
+}
+
+func encodeDesc(d *MetricDesc) []byte {
+	e := &encoder{}
+	e.int32(descRecord)
+	e.uint32(d.PMID)
+	e.int32(d.Type)
+	e.uint32(d.Indom)
+	e.int32(d.Semantics)
+	e.uint32(d.Units)
+	e.int32(int32(len(d.Names)))
+	for _, n := range d.Names {
+		e.int32(int32(len(n)))
+		e.bytes([]byte(n))
+	}
+	return e.record()
+}
+
+// encodeIndom encodes the instances followed by the offsets of their names
+// from the start of the names, which are NUL terminated
+func encodeIndom(in *Indom) []byte {
+	e := &encoder{}
+	e.int32(indomRecord)
+	e.timestamp(in.Time)
+	e.uint32(in.Indom)
+	e.int32(int32(len(in.Instances)))
+
+	for _, i := range in.Instances {
+		e.int32(i)
+	}
+
+	off := 0
+	for _, n := range in.Names {
+		e.int32(int32(off))
+		off += len(n) + 1
+	}
+
+	for _, n := range in.Names {
+		e.bytes(append([]byte(n), 0))
+	}
+
+	return e.record()
+}
+
+func encodeText(t *Text) []byte {
+	e := &encoder{}
+	e.int32(textRecord)
+	e.int32(t.Type)
+	e.uint32(t.Ident)
+	e.bytes(append([]byte(t.Text), 0))
+	return e.record()
+}
+
+// valueType returns the metric type of a value
+func valueType(v interface{}) (int32, error) {
+	switch v.(type) {
+	case int32:
+		return Int32Type, nil
+	case uint32:
+		return Uint32Type, nil
+	case int64:
+		return Int64Type, nil
+	case uint64:
+		return Uint64Type, nil
+	case float32:
+		return FloatType, nil
+	case float64:
+		return DoubleType, nil
+	case string:
+		return StringType, nil
+	}
+	return 0, errors.Errorf("unsupported value %v of type %T", v, v)
+}
+
+// valueBlock encodes a value that does not fit in a word as a block, a word holding the type
+// and the length of the block followed by the value, padded to a whole number of words
+func valueBlock(t int32, v interface{}) []byte {
+	e := &encoder{}
+	e.uint32(0)
+
+	switch val := v.(type) {
+	case int64:
+		e.int64(val)
+	case uint64:
+		e.int64(int64(val))
+	case float32:
+		e.uint32(math.Float32bits(val))
+	case float64:
+		e.int64(int64(math.Float64bits(val)))
+	case string:
+		e.bytes(append([]byte(val), 0))
+	}
+
+	binary.BigEndian.PutUint32(e.buf, uint32(t)<<24|uint32(len(e.buf)))
+	e.pad()
+	return e.buf
+}
+
+// pduHeaderWords is the length of the PDU header in words. Results are stored the way they are
+// sent by pmcd without the PDU header, and the offsets of value blocks in them count from the
+// start of the PDU, so they are off by the length of the header.
+const pduHeaderWords = 3
+
+// encodeResult encodes a result with the values of each metric in a value list, and values
+// that do not fit in a word in blocks after all value lists
+func encodeResult(r *Result) ([]byte, error) {
+	e := &encoder{}
+	e.timestamp(r.Time)
+	e.int32(int32(len(r.Sets)))
+
+	type patch struct {
+		at    int
+		block []byte
+	}
+	var patches []patch
+
+	for _, s := range r.Sets {
+		e.uint32(s.PMID)
+
+		if s.Err < 0 {
+			e.int32(s.Err)
+			continue
+		}
+
+		e.int32(int32(len(s.Values)))
+		if len(s.Values) == 0 {
+			continue
+		}
+
+		t, err := valueType(s.Values[0].Val)
+		if err != nil {
+			return nil, err
+		}
+
+		if t == Int32Type || t == Uint32Type {
+			e.int32(valInsitu)
+		} else {
+			e.int32(valDptr)
+		}
+
+		for _, v := range s.Values {
+			vt, err := valueType(v.Val)
+			if err != nil {
+				return nil, err
+			}
+
+			if vt != t {
+				return nil, errors.Errorf("values of %v have different types", s.PMID)
+			}
+
+			e.int32(v.Inst)
+
+			switch val := v.Val.(type) {
+			case int32:
+				e.int32(val)
+			case uint32:
+				e.uint32(val)
+			default:
+				patches = append(patches, patch{len(e.buf), valueBlock(t, val)})
+				e.uint32(0)
+			}
+		}
+	}
+
+	for _, p := range patches {
+		binary.BigEndian.PutUint32(e.buf[p.at:], uint32(len(e.buf)/4+pduHeaderWords))
+		e.bytes(p.block)
+	}
+
+	return e.record(), nil
+}
+
+func encodeIndexEntry(i *IndexEntry) []byte {
+	e := &encoder{}
+	e.timestamp(i.Time)
+	e.int32(i.Volume)
+	e.int64(i.MetaOff)
+	e.int64(i.DataOff)
+	return e.buf
+}
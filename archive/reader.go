@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// indexEntryLength is the length of an index entry, which unlike other records are not framed
+const indexEntryLength = 32
+
+// Read reads an archive with the passed base name
+func Read(base string) (*Archive, error) {
+	a := &Archive{}
+
+	meta, err := readVolume(base+".meta", MetaVolume)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Label = *meta.label
+	for i, r := range meta.records {
+		if err := a.decodeMeta(r); err != nil {
+			return nil, errors.Wrapf(err, "invalid metadata record %v", i)
+		}
+	}
+
+	types := make(map[uint32]int32, len(a.Descs))
+	for _, d := range a.Descs {
+		types[d.PMID] = d.Type
+	}
+
+	data, err := readVolume(base+".0", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, b := range data.records {
+		r, err := decodeResult(b, types)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid result %v", i)
+		}
+		a.Results = append(a.Results, r)
+	}
+
+	index, err := readVolume(base+".index", IndexVolume)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(index.rest)%indexEntryLength != 0 {
+		return nil, errors.Errorf("index has %v bytes after the label, which is not a whole number of entries", len(index.rest))
+	}
+
+	for b := index.rest; len(b) > 0; b = b[indexEntryLength:] {
+		a.Index = append(a.Index, decodeIndexEntry(b[:indexEntryLength]))
+	}
+
+	return a, nil
+}
+
+type volume struct {
+	label   *Label
+	records [][]byte
+
+	// rest is everything after the label, the index entries are not framed records
+	rest []byte
+}
+
+func readVolume(name string, vol int32) (*volume, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", name)
+	}
+
+	v := &volume{}
+
+	labels, err := records(data[:labelLength(data)])
+	if err != nil || len(labels) != 1 {
+		return nil, errors.Errorf("%v does not start with a label", name)
+	}
+
+	if v.label, err = decodeLabel(labels[0], vol); err != nil {
+		return nil, errors.Wrapf(err, "invalid label in %v", name)
+	}
+
+	v.rest = data[labelLength(data):]
+	if vol == IndexVolume {
+		return v, nil
+	}
+
+	if v.records, err = records(v.rest); err != nil {
+		return nil, errors.Wrapf(err, "invalid record in %v", name)
+	}
+
+	return v, nil
+}
+
+// labelLength returns the length of the label record at the start of a file, as stored in it
+func labelLength(data []byte) int {
+	d := &decoder{b: data}
+	n := int(d.uint32())
+	if d.err != nil || n < 0 || n > len(data) {
+		return len(data)
+	}
+	return n
+}
@@ -0,0 +1,231 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Writer writes an archive, results have to be written in time order and the metadata
+// describing the metrics and instance domains in a result has to be written before it
+type Writer struct {
+	label Label
+
+	meta, data, index *os.File
+	mw, dw, iw        *bufio.Writer
+
+	metaOff, dataOff int64
+
+	// last is the time of the last result, and indexed tells if there is an index entry
+	// for the current offsets
+	last    time.Time
+	indexed bool
+}
+
+// Create creates a new archive with the passed base name, failing if any of its files exist.
+//
+// Unset fields of the label default to the current process, host, time and timezone.
+func Create(base string, label Label) (*Writer, error) {
+	if label.PID == 0 {
+		label.PID = int32(os.Getpid())
+	}
+
+	if label.Hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the hostname")
+		}
+		label.Hostname = h
+	}
+
+	if label.Start.IsZero() {
+		label.Start = time.Now()
+	}
+
+	if label.Timezone == "" {
+		label.Timezone = timezone(label.Start)
+	}
+
+	w := &Writer{label: label}
+
+	var err error
+	if w.meta, err = create(base + ".meta"); err != nil {
+		return nil, err
+	}
+
+	if w.data, err = create(base + ".0"); err != nil {
+		w.remove()
+		return nil, err
+	}
+
+	if w.index, err = create(base + ".index"); err != nil {
+		w.remove()
+		return nil, err
+	}
+
+	w.mw, w.dw, w.iw = bufio.NewWriter(w.meta), bufio.NewWriter(w.data), bufio.NewWriter(w.index)
+
+	for _, v := range []struct {
+		w      *bufio.Writer
+		volume int32
+		off    *int64
+	}{{w.mw, MetaVolume, &w.metaOff}, {w.dw, 0, &w.dataOff}, {w.iw, IndexVolume, nil}} {
+		l := label
+		l.Volume = v.volume
+
+		n, err := v.w.Write(encodeLabel(&l))
+		if err != nil {
+			w.remove()
+			return nil, errors.Wrap(err, "failed to write label")
+		}
+
+		if v.off != nil {
+			*v.off = int64(n)
+		}
+	}
+
+	return w, nil
+}
+
+func create(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	return f, errors.Wrapf(err, "failed to create %v", name)
+}
+
+// timezone returns the zone of a time in the POSIX TZ form pmlogger writes, like UTC+0 or AEST-10,
+// where the offset is the one to add to local time to get to UTC
+func timezone(t time.Time) string {
+	name, off := t.Zone()
+
+	sign := "+"
+	if off > 0 {
+		sign = "-"
+	} else {
+		off = -off
+	}
+
+	tz := fmt.Sprintf("%v%v%v", name, sign, off/3600)
+	if m := off % 3600 / 60; m != 0 {
+		tz += fmt.Sprintf(":%02d", m)
+	}
+
+	return tz
+}
+
+// Label returns the label of the archive
+func (w *Writer) Label() Label { return w.label }
+
+func (w *Writer) writeMeta(b []byte) error {
+	n, err := w.mw.Write(b)
+	w.metaOff += int64(n)
+	w.indexed = false
+	return errors.Wrap(err, "failed to write metadata")
+}
+
+// PutDesc writes the description of a metric along with its names
+func (w *Writer) PutDesc(d Desc, names ...string) error {
+	return w.writeMeta(encodeDesc(&MetricDesc{d, names}))
+}
+
+// PutIndom writes the instances of an instance domain, which replace any instances
+// written for it before from the time of the indom
+func (w *Writer) PutIndom(in Indom) error {
+	if len(in.Instances) != len(in.Names) {
+		return errors.Errorf("indom %v has %v instances and %v names", in.Indom, len(in.Instances), len(in.Names))
+	}
+
+	return w.writeMeta(encodeIndom(&in))
+}
+
+// PutText writes a help text
+func (w *Writer) PutText(t Text) error {
+	return w.writeMeta(encodeText(&t))
+}
+
+// PutResult appends a result to the data volume
+func (w *Writer) PutResult(r Result) error {
+	if r.Time.Before(w.last) {
+		return errors.Errorf("result at %v is before the last result at %v", r.Time, w.last)
+	}
+
+	b, err := encodeResult(&r)
+	if err != nil {
+		return err
+	}
+
+	// like pmlogger, index the start of the data and every change to the metadata,
+	// so readers seeking to a time find the metadata they need
+	if !w.indexed {
+		if err := w.putIndexEntry(r.Time); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.dw.Write(b)
+	w.dataOff += int64(n)
+	w.last = r.Time
+	return errors.Wrap(err, "failed to write result")
+}
+
+func (w *Writer) putIndexEntry(t time.Time) error {
+	_, err := w.iw.Write(encodeIndexEntry(&IndexEntry{t, 0, w.metaOff, w.dataOff}))
+	w.indexed = true
+	return errors.Wrap(err, "failed to write index entry")
+}
+
+// Flush writes any buffered records to the files of the archive
+func (w *Writer) Flush() error {
+	for _, b := range []*bufio.Writer{w.mw, w.dw, w.iw} {
+		if err := b.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush archive")
+		}
+	}
+	return nil
+}
+
+// Close writes a final index entry at the end of the archive and closes its files
+func (w *Writer) Close() error {
+	t := w.last
+	if t.IsZero() {
+		t = w.label.Start
+	}
+
+	err := w.putIndexEntry(t)
+
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+
+	if cerr := w.closeFiles(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (w *Writer) closeFiles() error {
+	var err error
+	for _, f := range []*os.File{w.meta, w.data, w.index} {
+		if f == nil {
+			continue
+		}
+
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = errors.Wrapf(cerr, "failed to close %v", f.Name())
+		}
+	}
+	return err
+}
+
+// remove closes and removes the files created so far, when creating an archive fails
+func (w *Writer) remove() {
+	_ = w.closeFiles()
+	for _, f := range []*os.File{w.meta, w.data, w.index} {
+		if f != nil {
+			_ = os.Remove(f.Name())
+		}
+	}
+}
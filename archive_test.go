@@ -0,0 +1,157 @@
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/v4/archive"
+)
+
+func TestArchiveRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	r := NewPCPRegistry()
+
+	requests, err := NewPCPCounter(5, "http.requests", "number of requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indom, err := NewPCPInstanceDomain("queues", []string{"high", "low"}, "queues by priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queues, err := NewPCPInstanceMetric(Instances{"high": 3.5, "low": 1.25}, "queue.length", indom, DoubleType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Metric{requests, queues} {
+		if err = r.AddMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	base := filepath.Join(dir, "app")
+	rec, err := NewArchiveRecorder(base, "app", 100, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err = rec.Record(start); err != nil {
+		t.Fatal(err)
+	}
+
+	requests.Up()
+	if err = queues.SetInstance(0.5, "low"); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := NewPCPSingletonMetric("1.0", "version", StringType, DiscreteSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.AddMetric(version); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rec.Record(start.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rec.Record(start); err == nil {
+		t.Error("expected recording before the last record to fail")
+	}
+
+	if err = rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := archive.Read(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := rec.pmda
+	names := make(map[uint32][]string)
+	for _, d := range a.Descs {
+		names[d.PMID] = d.Names
+	}
+
+	expected := map[uint32][]string{
+		p.pmid(requests): {"app.http.requests"},
+		p.pmid(queues):   {"app.queue.length"},
+		p.pmid(version):  {"app.version"},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected metrics %v, got %v", expected, names)
+	}
+
+	if len(a.Indoms) != 1 || a.Indoms[0].Indom != p.indom(indom) || len(a.Indoms[0].Names) != 2 {
+		t.Fatalf("unexpected instance domains %v", a.Indoms)
+	}
+
+	for i, id := range a.Indoms[0].Instances {
+		if in := indom.instances[a.Indoms[0].Names[i]]; in == nil || instanceID(in) != id {
+			t.Errorf("unexpected instance %v named %v", id, a.Indoms[0].Names[i])
+		}
+	}
+
+	if len(a.Texts) != 2 {
+		t.Errorf("expected the help texts of http.requests and queues, got %v", a.Texts)
+	}
+
+	if len(a.Results) != 2 {
+		t.Fatalf("expected 2 results, got %v", len(a.Results))
+	}
+
+	values := func(r *archive.Result) map[uint32]map[int32]interface{} {
+		ans := make(map[uint32]map[int32]interface{})
+		for _, s := range r.Sets {
+			ans[s.PMID] = make(map[int32]interface{})
+			for _, v := range s.Values {
+				ans[s.PMID][v.Inst] = v.Val
+			}
+		}
+		return ans
+	}
+
+	high, low := instanceID(indom.instances["high"]), instanceID(indom.instances["low"])
+
+	first := values(a.Results[0])
+	if !reflect.DeepEqual(first, map[uint32]map[int32]interface{}{
+		p.pmid(requests): {archive.InstNull: int64(5)},
+		p.pmid(queues):   {high: 3.5, low: 1.25},
+	}) {
+		t.Errorf("unexpected first result %v", first)
+	}
+
+	second := values(a.Results[1])
+	if !reflect.DeepEqual(second, map[uint32]map[int32]interface{}{
+		p.pmid(requests): {archive.InstNull: int64(6)},
+		p.pmid(queues):   {high: 3.5, low: 0.5},
+		p.pmid(version):  {archive.InstNull: "1.0"},
+	}) {
+		t.Errorf("unexpected second result %v", second)
+	}
+
+	if !a.Results[1].Time.Equal(start.Add(time.Second)) {
+		t.Errorf("expected the second result at %v, got %v", start.Add(time.Second), a.Results[1].Time)
+	}
+
+	// the version was added after the first result, so its description is indexed before the second
+	if len(a.Index) != 3 || a.Index[1].MetaOff <= a.Index[0].MetaOff {
+		t.Errorf("unexpected index %v", a.Index)
+	}
+}
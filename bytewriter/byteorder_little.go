@@ -0,0 +1,10 @@
+//go:build !armbe && !arm64be && !mips && !mips64 && !mips64p32 && !ppc && !ppc64 && !s390 && !s390x && !sparc && !sparc64
+// +build !armbe,!arm64be,!mips,!mips64,!mips64p32,!ppc,!ppc64,!s390,!s390x,!sparc,!sparc64
+
+package bytewriter
+
+import "encoding/binary"
+
+// byteOrder is the native byte order of the host, which MMV files are written in,
+// as pmcd maps them into memory and reads them directly
+var byteOrder binary.ByteOrder = binary.LittleEndian
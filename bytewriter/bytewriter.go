@@ -7,9 +7,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-// assumes Little Endian, use _arch.go to set it to BigEndian for those archs
-var byteOrder = binary.LittleEndian
-
 // ByteWriter is a simple wrapper over a byte slice that supports writing anywhere
 type ByteWriter struct {
 	buffer []byte
@@ -31,6 +28,9 @@ func (w *ByteWriter) Len() int { return len(w.buffer) }
 // Bytes returns the internal byte array of the ByteWriter
 func (w *ByteWriter) Bytes() []byte { return w.buffer }
 
+// Close does nothing, as a ByteWriter only holds memory
+func (w *ByteWriter) Close() error { return nil }
+
 func (w *ByteWriter) Write(data []byte, offset int) (int, error) {
 	l := len(data)
 
@@ -1,6 +1,9 @@
 package bytewriter
 
-import "testing"
+import (
+	"testing"
+	"unsafe"
+)
 
 func TestWriteInt32(t *testing.T) {
 	cases := []int32{0, 10, 100, 200, 1000, 10000, 10000000, 1000000000, 2147483647}
@@ -126,3 +129,17 @@ func TestOffset(t *testing.T) {
 		return
 	}
 }
+
+func TestNativeByteOrder(t *testing.T) {
+	w := NewByteWriter(4)
+	w.MustWriteUint32(0x01020304, 0)
+
+	// pmcd reads values straight from memory, so they have to be in the order of the host
+	if native := *(*uint32)(unsafe.Pointer(&w.Bytes()[0])); native != 0x01020304 {
+		t.Errorf("expected values to be written in the native byte order, read back 0x%x", native)
+	}
+
+	if v := ByteOrder().Uint32(w.Bytes()); v != 0x01020304 {
+		t.Errorf("expected ByteOrder to decode written values, got 0x%x", v)
+	}
+}
@@ -0,0 +1,85 @@
+package bytewriter
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	mmap "github.com/edsrzf/mmap-go"
+	"github.com/pkg/errors"
+)
+
+// memfdCreate is the number of the memfd_create system call, which the syscall package
+// only knows for some architectures
+var memfdCreate = map[string]uintptr{
+	"386":      356,
+	"amd64":    319,
+	"arm":      385,
+	"arm64":    279,
+	"loong64":  279,
+	"mips":     4354,
+	"mipsle":   4354,
+	"mips64":   5314,
+	"mips64le": 5314,
+	"ppc64":    360,
+	"ppc64le":  360,
+	"riscv64":  279,
+	"s390x":    350,
+}[runtime.GOARCH]
+
+// MemfdWriter is a ByteWriter over an anonymous shared memory region created with memfd_create,
+// that is not visible in any filesystem and can be handed to another process, like a sidecar
+// exporting the metrics, by passing on its file descriptor
+type MemfdWriter struct {
+	*ByteWriter
+	handle *os.File
+}
+
+// NewMemfdWriter creates a shared memory region of size bytes and maps it into memory,
+// name is only used for debugging, showing up in /proc/<pid>/fd
+func NewMemfdWriter(name string, size int) (*MemfdWriter, error) {
+	if memfdCreate == 0 {
+		return nil, errors.Errorf("memfd_create is not supported on %v", runtime.GOARCH)
+	}
+
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// MFD_CLOEXEC, a sidecar started with exec has to be passed the descriptor explicitly
+	fd, _, errno := syscall.Syscall(memfdCreate, uintptr(unsafe.Pointer(n)), 1, 0)
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "memfd_create")
+	}
+
+	f := os.NewFile(fd, "memfd:"+name)
+
+	if err = f.Truncate(int64(size)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	b, err := mmap.Map(f, mmap.RDWR, 0)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &MemfdWriter{NewByteWriterSlice(b), f}, nil
+}
+
+// File returns the shared memory region as a file, to be passed to other processes,
+// for example as an extra file of an exec.Cmd or over a unix socket
+func (w *MemfdWriter) File() *os.File { return w.handle }
+
+// Close deletes the memory mapping and closes the file descriptor of the region,
+// which is freed once no other process refers to it
+func (w *MemfdWriter) Close() error {
+	m := mmap.MMap(w.buffer)
+	if err := m.Unmap(); err != nil {
+		return err
+	}
+	return w.handle.Close()
+}
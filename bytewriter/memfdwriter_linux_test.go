@@ -0,0 +1,32 @@
+package bytewriter
+
+import (
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+func TestMemfdWriter(t *testing.T) {
+	w, err := NewMemfdWriter("memfd_test", 10)
+	if err != nil {
+		t.Skip("memfd_create is not available:", err)
+	}
+
+	if _, err = w.WriteString("x", 5); err != nil {
+		t.Fatal("Cannot Write to MemfdWriter")
+	}
+
+	// reading the region through its file like another process would
+	data, err := ioutil.ReadFile("/proc/self/fd/" + strconv.Itoa(int(w.File().Fd())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 10 || data[5] != 'x' {
+		t.Errorf("Data Written in buffer not getting reflected in the shared region, got %v", data)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+}
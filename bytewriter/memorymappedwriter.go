@@ -3,6 +3,8 @@ package bytewriter
 import (
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	mmap "github.com/edsrzf/mmap-go"
 	"github.com/pkg/errors"
@@ -77,3 +79,66 @@ func (b *MemoryMappedWriter) Unmap(removefile bool) error {
 
 	return nil
 }
+
+// Close deletes the memory mapping of a mapped buffer, keeping the file
+func (b *MemoryMappedWriter) Close() error { return b.Unmap(false) }
+
+// Sync flushes the changes made to a mapped buffer to its file
+func (b *MemoryMappedWriter) Sync() error { return mmap.MMap(b.buffer).Flush() }
+
+// SyncingWriter is a MemoryMappedWriter that periodically flushes its changes to its file,
+// for files that are read by something that does not map them, like a backup or a
+// process on a remote filesystem, or that need to survive a crash of the host
+type SyncingWriter struct {
+	*MemoryMappedWriter
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSyncingWriter creates a MemoryMappedWriter for loc that flushes its changes every interval
+func NewSyncingWriter(loc string, size int, interval time.Duration) (*SyncingWriter, error) {
+	if interval <= 0 {
+		return nil, errors.New("the sync interval has to be positive")
+	}
+
+	m, err := NewMemoryMappedWriter(loc, size)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &SyncingWriter{MemoryMappedWriter: m, done: make(chan struct{})}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				_ = m.Sync()
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// Unmap stops the periodic flushes, flushes one last time and deletes the memory mapping
+func (w *SyncingWriter) Unmap(removefile bool) error {
+	close(w.done)
+	w.wg.Wait()
+
+	if err := w.Sync(); err != nil {
+		return err
+	}
+
+	return w.MemoryMappedWriter.Unmap(removefile)
+}
+
+// Close stops the periodic flushes, flushes one last time and deletes the memory mapping, keeping the file
+func (w *SyncingWriter) Close() error { return w.Unmap(false) }
@@ -1,9 +1,11 @@
 package bytewriter
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestMemoryMappedWriter(t *testing.T) {
@@ -62,3 +64,37 @@ func testUnmap(w *MemoryMappedWriter, loc string, t *testing.T) {
 		t.Error("Memory Mapped File not getting deleted on Unmap")
 	}
 }
+
+func TestSyncingWriter(t *testing.T) {
+	loc := filepath.Join(os.TempDir(), "bytebuffer_syncingwriter_test.tmp")
+
+	if _, err := NewSyncingWriter(loc, 10, 0); err == nil {
+		t.Error("expected an error for a non positive interval")
+	}
+
+	w, err := NewSyncingWriter(loc, 10, time.Millisecond)
+	if err != nil {
+		t.Fatal("Cannot proceed with test as create writer failed:", err)
+	}
+
+	if _, err = w.WriteString("x", 5); err != nil {
+		t.Fatal("Cannot Write to SyncingWriter")
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[5] != 'x' {
+		t.Error("Data Written in buffer not getting reflected in file after closing")
+	}
+
+	if err = os.Remove(loc); err != nil {
+		t.Error(err)
+	}
+}
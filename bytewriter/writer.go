@@ -22,6 +22,8 @@
 // this implements a writer that supports multiple concurrent writes within a fixed length block
 package bytewriter
 
+import "encoding/binary"
+
 // Writer defines an abstraction for an object that allows writing of binary
 // values anywhere within a fixed range
 type Writer interface {
@@ -48,3 +50,20 @@ type Writer interface {
 	MustWriteFloat32(float32, int) int
 	MustWriteFloat64(float64, int) int
 }
+
+// WriteCloser is a Writer that holds resources, like a memory mapping,
+// that have to be released once writing is done
+type WriteCloser interface {
+	Writer
+	Close() error
+}
+
+// FileWriter is a WriteCloser backed by a file at a known location,
+// which it can remove once writing is done
+type FileWriter interface {
+	WriteCloser
+	Unmap(removefile bool) error
+}
+
+// ByteOrder returns the byte order writers write values in, which is the native byte order of the host
+func ByteOrder() binary.ByteOrder { return byteOrder }
@@ -32,7 +32,8 @@ const MaxV1NameLength = 63
 // MaxDataValueSize is the maximum byte length for a stored metric value, unless it is a string
 const MaxDataValueSize = 16
 
-// EraseFileOnStop if set to true, will also delete the memory mapped file
+// EraseFileOnStop if set to true, will also delete the memory mapped file,
+// for clients writing to a file, see bytewriter.FileWriter
 var EraseFileOnStop = false
 
 // Client defines the interface for a type that can talk to an instrumentation agent
@@ -109,7 +110,8 @@ type PCPClient struct {
 
 	r *PCPRegistry // current registry
 
-	writer bytewriter.Writer
+	writer    bytewriter.WriteCloser
+	newWriter WriterFactory
 
 	instanceoffsetc chan int
 	indomoffsetc    chan int
@@ -135,9 +137,56 @@ func NewPCPClientWithRegistry(name string, registry *PCPRegistry) (*PCPClient, e
 		r:         registry,
 		clusterID: hash(name, PCPClusterIDBitLength),
 		flag:      ProcessFlag,
+		newWriter: MemoryMappedWriterFactory,
 	}, nil
 }
 
+// NewInMemoryPCPClient initializes a new PCPClient with the given registry that writes
+// to memory rather than a memory mapped file, so it needs no PCP installation or
+// temporary directory. The data it writes is available from Bytes while it is active.
+func NewInMemoryPCPClient(name string, registry *PCPRegistry) (*PCPClient, error) {
+	if strings.ContainsRune(name, os.PathSeparator) {
+		return nil, errors.New("name cannot have path separator")
+	}
+
+	return &PCPClient{
+		r:         registry,
+		clusterID: hash(name, PCPClusterIDBitLength),
+		flag:      ProcessFlag,
+		newWriter: InMemoryWriterFactory,
+	}, nil
+}
+
+// Bytes returns a copy of the MMV data written by an active client, or nil for a stopped one
+func (c *PCPClient) Bytes() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.writer == nil {
+		return nil
+	}
+
+	return append([]byte(nil), c.writer.Bytes()...)
+}
+
+// SetWriterFactory sets the factory creating the writer the client writes to when it starts,
+// the default is MemoryMappedWriterFactory
+func (c *PCPClient) SetWriterFactory(f WriterFactory) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return errors.New("cannot set the writer factory for an active client")
+	}
+
+	if f == nil {
+		return errors.New("the writer factory cannot be nil")
+	}
+
+	c.newWriter = f
+	return nil
+}
+
 // Registry returns a writer's registry
 func (c *PCPClient) Registry() Registry {
 	return c.r
@@ -198,9 +247,9 @@ func (c *PCPClient) Start() error {
 
 	l := c.Length()
 
-	writer, err := bytewriter.NewMemoryMappedWriter(c.loc, l)
+	writer, err := c.newWriter(c.loc, l)
 	if err != nil {
-		return errors.Wrap(err, "cannot create MemoryMappedBuffer in client")
+		return errors.Wrap(err, "cannot create writer in client")
 	}
 
 	c.writer = writer
@@ -405,7 +454,7 @@ func (c *PCPClient) writeInstanceDomain(indom *PCPInstanceDomain) {
 	var wg sync.WaitGroup
 	wg.Add(indom.InstanceCount())
 
-	off = c.writer.MustWriteUint32(indom.id, off)
+	off = c.writer.MustWriteUint32(c.r.indomID(indom), off)
 	off = c.writer.MustWriteInt32(int32(indom.InstanceCount()), off)
 	off = c.writer.MustWriteInt64(int64(ioff), off)
 
@@ -460,53 +509,53 @@ func (c *PCPClient) writeInstance(i *pcpInstance, indomoff int, off int) {
 func (c *PCPClient) writeMetrics() {
 	var wg sync.WaitGroup
 
-	launchSingletonMetric := func(metric *pcpSingletonMetric) {
+	launchSingletonMetric := func(name string, metric *pcpSingletonMetric) {
 		go func() {
-			c.writeSingletonMetric(metric)
+			c.writeSingletonMetric(name, metric)
 			wg.Done()
 		}()
 	}
 
-	launchInstanceMetric := func(metric *pcpInstanceMetric) {
+	launchInstanceMetric := func(name string, metric *pcpInstanceMetric) {
 		go func() {
-			c.writeInstanceMetric(metric)
+			c.writeInstanceMetric(name, metric)
 			wg.Done()
 		}()
 	}
 
 	wg.Add(c.r.MetricCount())
-	for _, m := range c.r.metrics {
+	for name, m := range c.r.metrics {
 		switch metric := m.(type) {
 		case *PCPSingletonMetric:
-			launchSingletonMetric(metric.pcpSingletonMetric)
+			launchSingletonMetric(name, metric.pcpSingletonMetric)
 		case *PCPCounter:
-			launchSingletonMetric(metric.pcpSingletonMetric)
+			launchSingletonMetric(name, metric.pcpSingletonMetric)
 		case *PCPGauge:
-			launchSingletonMetric(metric.pcpSingletonMetric)
+			launchSingletonMetric(name, metric.pcpSingletonMetric)
 		case *PCPTimer:
-			launchSingletonMetric(metric.pcpSingletonMetric)
+			launchSingletonMetric(name, metric.pcpSingletonMetric)
 		case *PCPInstanceMetric:
-			launchInstanceMetric(metric.pcpInstanceMetric)
+			launchInstanceMetric(name, metric.pcpInstanceMetric)
 		case *PCPCounterVector:
-			launchInstanceMetric(metric.pcpInstanceMetric)
+			launchInstanceMetric(name, metric.pcpInstanceMetric)
 		case *PCPGaugeVector:
-			launchInstanceMetric(metric.pcpInstanceMetric)
+			launchInstanceMetric(name, metric.pcpInstanceMetric)
 		case *PCPHistogram:
-			launchInstanceMetric(metric.pcpInstanceMetric)
+			launchInstanceMetric(name, metric.pcpInstanceMetric)
 		}
 	}
 
 	wg.Wait()
 }
 
-func (c *PCPClient) writeSingletonMetric(m *pcpSingletonMetric) {
+func (c *PCPClient) writeSingletonMetric(name string, m *pcpSingletonMetric) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	doff := <-c.metricoffsetc
 
 	go func() {
-		c.writeMetricDesc(m.pcpMetricDesc, m.Indom(), doff)
+		c.writeMetricDesc(name, m.pcpMetricDesc, m.Indom(), doff)
 		wg.Done()
 	}()
 
@@ -524,14 +573,14 @@ func (c *PCPClient) writeSingletonMetric(m *pcpSingletonMetric) {
 	wg.Wait()
 }
 
-func (c *PCPClient) writeInstanceMetric(m *pcpInstanceMetric) {
+func (c *PCPClient) writeInstanceMetric(name string, m *pcpInstanceMetric) {
 	var wg sync.WaitGroup
 	wg.Add(1 + m.Indom().InstanceCount())
 
 	doff := <-c.metricoffsetc
 
 	go func() {
-		c.writeMetricDesc(m.pcpMetricDesc, m.Indom(), doff)
+		c.writeMetricDesc(name, m.pcpMetricDesc, m.Indom(), doff)
 		wg.Done()
 	}()
 
@@ -551,7 +600,9 @@ func (c *PCPClient) writeInstanceMetric(m *pcpInstanceMetric) {
 	wg.Wait()
 }
 
-func (c *PCPClient) writeMetricDesc(desc *pcpMetricDesc, indom *PCPInstanceDomain, off int) {
+// writeMetricDesc writes a metric description under the name the metric is registered under,
+// which the item id is generated from
+func (c *PCPClient) writeMetricDesc(name string, desc *pcpMetricDesc, indom *PCPInstanceDomain, off int) {
 	if c.r.version2 {
 		c.metricoffsetc <- off + Metric2Length
 
@@ -559,21 +610,21 @@ func (c *PCPClient) writeMetricDesc(desc *pcpMetricDesc, indom *PCPInstanceDomai
 		c.stringoffsetc <- noff + StringLength
 
 		off = c.writer.MustWriteUint64(uint64(noff), off)
-		c.writer.MustWriteString(desc.name, noff)
+		c.writer.MustWriteString(name, noff)
 	} else {
 		c.metricoffsetc <- off + Metric1Length
 
-		c.writer.MustWriteString(desc.name, off)
+		c.writer.MustWriteString(name, off)
 		off += MaxV1NameLength + 1
 	}
 
-	off = c.writer.MustWriteUint32(desc.id, off)
+	off = c.writer.MustWriteUint32(hash(name, PCPMetricItemBitLength), off)
 	off = c.writer.MustWriteInt32(int32(desc.t), off)
 	off = c.writer.MustWriteInt32(int32(desc.sem), off)
 	off = c.writer.MustWriteUint32(desc.u.PMAPI(), off)
 
 	if indom != nil {
-		off = c.writer.MustWriteUint32(indom.ID(), off)
+		off = c.writer.MustWriteUint32(c.r.indomID(indom), off)
 	} else {
 		off = c.writer.MustWriteInt32(-1, off)
 	}
@@ -636,10 +687,18 @@ func (c *PCPClient) Stop() error {
 
 	c.r.mapped = false
 
-	err := c.writer.(*bytewriter.MemoryMappedWriter).Unmap(EraseFileOnStop)
+	// only writers backed by the file at the location of the client remove it,
+	// other writers never wrote it and it may belong to another process
+	var err error
+	if w, ok := c.writer.(bytewriter.FileWriter); ok {
+		err = w.Unmap(EraseFileOnStop)
+	} else {
+		err = c.writer.Close()
+	}
+
 	c.writer = nil
 	if err != nil {
-		return errors.Wrap(err, "client: error unmapping MemoryMappedBuffer")
+		return errors.Wrap(err, "client: error closing writer")
 	}
 
 	return nil
@@ -129,6 +129,30 @@ func TestMapping(t *testing.T) {
 	EraseFileOnStop = false
 }
 
+func TestInMemoryClient(t *testing.T) {
+	c, err := NewInMemoryPCPClient("test", NewPCPRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Bytes() != nil {
+		t.Error("expected no data before starting")
+	}
+
+	c.MustRegisterString("test.1", 2, Int32Type, CounterSemantics, OneUnit)
+	c.MustStart()
+
+	data := c.Bytes()
+	if len(data) != c.Length() || string(data[:3]) != "MMV" {
+		t.Errorf("expected %v bytes of MMV data, got %v", c.Length(), data)
+	}
+
+	c.MustStop()
+	if c.Bytes() != nil {
+		t.Error("expected no data after stopping")
+	}
+}
+
 func findMetric(metric Metric, metrics map[uint64]mmvdump.Metric) (uint64, mmvdump.Metric) {
 	for off, m := range metrics {
 		if uint32(m.Item()) == metric.ID() {
@@ -0,0 +1,190 @@
+package speed
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DBStatsIndom is the name of the instance domain used by DBStatsCollector,
+// its instances are the names of the collected databases.
+const DBStatsIndom = "sql.databases"
+
+// dbStatsMetric describes a single metric generated from sql.DBStats
+type dbStatsMetric struct {
+	name string
+	sem  MetricSemantics
+	unit MetricUnit
+	desc string
+	val  func(s sql.DBStats) int64
+}
+
+var dbStatsMetrics = []dbStatsMetric{
+	{"sql.connections.max_open", DiscreteSemantics, OneUnit, "Maximum number of open connections to the database",
+		func(s sql.DBStats) int64 { return int64(s.MaxOpenConnections) }},
+	{"sql.connections.open", InstantSemantics, OneUnit, "Number of established connections, both in use and idle",
+		func(s sql.DBStats) int64 { return int64(s.OpenConnections) }},
+	{"sql.connections.in_use", InstantSemantics, OneUnit, "Number of connections currently in use",
+		func(s sql.DBStats) int64 { return int64(s.InUse) }},
+	{"sql.connections.idle", InstantSemantics, OneUnit, "Number of idle connections",
+		func(s sql.DBStats) int64 { return int64(s.Idle) }},
+	{"sql.wait.count", CounterSemantics, OneUnit, "Total number of connections waited for",
+		func(s sql.DBStats) int64 { return s.WaitCount }},
+	{"sql.wait.duration", CounterSemantics, NanosecondUnit, "Total time blocked waiting for a new connection",
+		func(s sql.DBStats) int64 { return int64(s.WaitDuration) }},
+	{"sql.closed.max_idle", CounterSemantics, OneUnit, "Total number of connections closed due to SetMaxIdleConns",
+		func(s sql.DBStats) int64 { return s.MaxIdleClosed }},
+	{"sql.closed.max_idle_time", CounterSemantics, OneUnit, "Total number of connections closed due to SetConnMaxIdleTime",
+		maxIdleTimeClosed},
+	{"sql.closed.max_lifetime", CounterSemantics, OneUnit, "Total number of connections closed due to SetConnMaxLifetime",
+		func(s sql.DBStats) int64 { return s.MaxLifetimeClosed }},
+}
+
+// DBStatsCollector publishes the connection pool statistics of a set of
+// *sql.DB handles, with one instance per database.
+//
+// Since instance domains cannot change once created, all databases have to be added
+// before the collector is registered.
+//
+// ```go
+// c, err := speed.NewDBStatsCollector(db, "users")
+// ...
+// err = c.Register(client.Registry())
+// ...
+// s, err := speed.NewSampler(time.Second, c)
+// ```
+type DBStatsCollector struct {
+	mutex sync.Mutex
+
+	dbs     map[string]*sql.DB
+	indom   *PCPInstanceDomain
+	metrics []*PCPInstanceMetric
+}
+
+// NewDBStatsCollector creates a new collector for the passed database,
+// using name as its instance name.
+func NewDBStatsCollector(db *sql.DB, name string) (*DBStatsCollector, error) {
+	c := &DBStatsCollector{dbs: make(map[string]*sql.DB)}
+
+	if err := c.Add(db, name); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Add adds another database to the collector.
+func (c *DBStatsCollector) Add(db *sql.DB, name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.indom != nil {
+		return errors.New("cannot add a database to an already registered collector")
+	}
+
+	if db == nil {
+		return errors.New("cannot collect stats of a nil database")
+	}
+
+	if name == "" {
+		return errors.New("database name cannot be empty")
+	}
+
+	if len(name) > StringLength {
+		return errors.Errorf("database name %v is too long", name)
+	}
+
+	if _, present := c.dbs[name]; present {
+		return errors.Errorf("a database named %v is already being collected", name)
+	}
+
+	c.dbs[name] = db
+	return nil
+}
+
+// Register creates the metrics for all added databases and adds them to the passed registry.
+// Nothing is added if any of the metrics or their instance domain is already in the registry.
+func (c *DBStatsCollector) Register(r Registry) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.indom != nil {
+		return errors.New("the collector is already registered")
+	}
+
+	// nothing is added unless everything can be, as metrics cannot be removed from a registry
+	if r.HasInstanceDomain(DBStatsIndom) {
+		return errors.Errorf("instance domain %v is already registered", DBStatsIndom)
+	}
+
+	for _, d := range dbStatsMetrics {
+		if r.HasMetric(d.name) {
+			return errors.Errorf("metric %v is already registered", d.name)
+		}
+	}
+
+	names := make([]string, 0, len(c.dbs))
+	for name := range c.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	indom, err := NewPCPInstanceDomain(DBStatsIndom, names, "database/sql connection pools")
+	if err != nil {
+		return err
+	}
+
+	metrics := make([]*PCPInstanceMetric, 0, len(dbStatsMetrics))
+	for _, d := range dbStatsMetrics {
+		vals := make(Instances, len(names))
+		for _, name := range names {
+			vals[name] = int64(0)
+		}
+
+		m, err := NewPCPInstanceMetric(vals, d.name, indom, Int64Type, d.sem, d.unit, d.desc)
+		if err != nil {
+			return err
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	for _, m := range metrics {
+		if err := r.AddMetric(m); err != nil {
+			return err
+		}
+	}
+
+	c.indom, c.metrics = indom, metrics
+	return nil
+}
+
+// MustRegister is a Register that panics on failure.
+func (c *DBStatsCollector) MustRegister(r Registry) {
+	if err := c.Register(r); err != nil {
+		panic(err)
+	}
+}
+
+// Collect reads the current stats of all databases and updates the metrics.
+func (c *DBStatsCollector) Collect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.indom == nil {
+		return errors.New("cannot collect from an unregistered collector")
+	}
+
+	for name, db := range c.dbs {
+		s := db.Stats()
+		for i, d := range dbStatsMetrics {
+			if err := c.metrics[i].SetInstance(d.val(s), name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
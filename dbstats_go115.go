@@ -0,0 +1,8 @@
+//go:build go1.15
+// +build go1.15
+
+package speed
+
+import "database/sql"
+
+func maxIdleTimeClosed(s sql.DBStats) int64 { return s.MaxIdleTimeClosed }
@@ -0,0 +1,9 @@
+//go:build !go1.15
+// +build !go1.15
+
+package speed
+
+import "database/sql"
+
+// sql.DBStats.MaxIdleTimeClosed was added in go1.15
+func maxIdleTimeClosed(s sql.DBStats) int64 { return 0 }
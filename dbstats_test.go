@@ -0,0 +1,116 @@
+package speed
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+
+func init() {
+	sql.Register("speedfake", fakeDriver{})
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	users, err := sql.Open("speedfake", "")
+	if err != nil {
+		t.Fatalf("cannot open database, error: %v", err)
+	}
+	defer users.Close()
+
+	orders, err := sql.Open("speedfake", "")
+	if err != nil {
+		t.Fatalf("cannot open database, error: %v", err)
+	}
+	defer orders.Close()
+
+	users.SetMaxOpenConns(5)
+	if err = users.Ping(); err != nil {
+		t.Fatalf("cannot connect to database, error: %v", err)
+	}
+
+	c, err := NewDBStatsCollector(users, "users")
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	if err = c.Add(orders, "users"); err == nil {
+		t.Error("expected adding a duplicate database name to fail")
+	}
+
+	if err = c.Collect(); err == nil {
+		t.Error("expected collecting before registration to fail")
+	}
+
+	if err = c.Add(orders, "orders"); err != nil {
+		t.Fatalf("cannot add database, error: %v", err)
+	}
+
+	// a clashing metric fails registration before anything is added
+	clash := NewPCPRegistry()
+	if _, err = clash.AddMetricByString("sql.closed.max_lifetime", int64(0), Int64Type, CounterSemantics, OneUnit); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Register(clash); err == nil {
+		t.Error("expected registering with a clashing metric name to fail")
+	}
+
+	if clash.MetricCount() != 1 || clash.HasInstanceDomain(DBStatsIndom) {
+		t.Errorf("expected a failed registration to add nothing, got %v metrics", clash.MetricCount())
+	}
+
+	r := NewPCPRegistry()
+	if err = c.Register(r); err != nil {
+		t.Fatalf("cannot register collector, error: %v", err)
+	}
+
+	if r.MetricCount() != len(dbStatsMetrics) || !r.HasInstanceDomain(DBStatsIndom) {
+		t.Errorf("expected %v metrics and the %v indom to be registered", len(dbStatsMetrics), DBStatsIndom)
+	}
+
+	if err = c.Add(orders, "other"); err == nil {
+		t.Error("expected adding a database after registration to fail")
+	}
+
+	if err = c.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	cases := []struct {
+		metric, db string
+		val        int64
+	}{
+		{"sql.connections.max_open", "users", 5},
+		{"sql.connections.open", "users", 1},
+		{"sql.connections.idle", "users", 1},
+		{"sql.connections.in_use", "users", 0},
+		{"sql.connections.open", "orders", 0},
+	}
+
+	for _, tc := range cases {
+		v, err := r.metrics[tc.metric].(*PCPInstanceMetric).ValInstance(tc.db)
+		if err != nil {
+			t.Errorf("cannot get %v[%v], error: %v", tc.metric, tc.db, err)
+			continue
+		}
+
+		if v != tc.val {
+			t.Errorf("expected %v[%v] to be %v, got %v", tc.metric, tc.db, tc.val, v)
+		}
+	}
+
+	if m := r.metrics["sql.wait.duration"]; m.Semantics() != CounterSemantics || m.Unit() != NanosecondUnit {
+		t.Errorf("expected sql.wait.duration to be a counter in nanoseconds, got %v in %v", m.Semantics(), m.Unit())
+	}
+}
@@ -0,0 +1,394 @@
+package speed
+
+import (
+	"encoding/json"
+	"expvar"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ExpvarRule configures the semantics and unit for all expvar variables
+// whose metric name matches Pattern.
+//
+// Patterns use the syntax of path.Match, with metric names being the dot separated
+// path to a value, like `memstats.NumGC` or `memstats.*Alloc`.
+type ExpvarRule struct {
+	Pattern   string
+	Semantics MetricSemantics
+	Unit      MetricUnit
+}
+
+// expvarSeries is a single PCP metric generated from expvar variables
+type expvarSeries struct {
+	name      string
+	t         MetricType
+	sem       MetricSemantics
+	unit      MetricUnit
+	singleton bool
+	vals      Instances // singletons store their value under the empty string
+}
+
+// ExpvarCollector publishes variables exported through the expvar package
+// using a PCPClient.
+//
+// Integer and floating point variables are published as Int64Type and DoubleType
+// metrics and strings as StringType metrics. Nested maps where all values are
+// numbers or all values are strings become instance metrics, with the map keys as instances,
+// while other maps are flattened into one metric per key. Arrays and booleans are skipped.
+//
+// Since instance domains cannot change while a client is active, the collector restarts
+// its client whenever a variable or map key appears or goes away.
+//
+// An ExpvarCollector implements Collector, so it can be run periodically by a Sampler:
+//
+// ```go
+// e, err := speed.NewExpvarCollector("app", speed.ExpvarRule{Pattern: "memstats.NumGC", Semantics: speed.CounterSemantics})
+// ...
+// s, err := speed.NewSampler(time.Second, e)
+// ...
+// s.MustStart()
+// ```
+type ExpvarCollector struct {
+	mutex sync.Mutex
+
+	name  string
+	rules []ExpvarRule
+
+	client  *PCPClient
+	layout  string
+	metrics map[string]PCPMetric
+}
+
+// NewExpvarCollector creates a new collector publishing expvar variables
+// through a PCPClient with the passed name.
+// Variables not matched by any rule get InstantSemantics (DiscreteSemantics for strings)
+// and OneUnit, if a variable matches multiple rules, the first one is used.
+func NewExpvarCollector(name string, rules ...ExpvarRule) (*ExpvarCollector, error) {
+	for _, r := range rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %v", r.Pattern)
+		}
+	}
+
+	if _, err := mmvFileLocation(name); err != nil {
+		return nil, err
+	}
+
+	return &ExpvarCollector{name: name, rules: rules}, nil
+}
+
+// Collect walks all expvar variables once and publishes their current values,
+// restarting the underlying client if the set of variables changed.
+func (e *ExpvarCollector) Collect() error {
+	var ss []*expvarSeries
+	expvar.Do(func(kv expvar.KeyValue) {
+		ss = e.walk(ss, expvarMetricName(kv.Key), expvarValue(kv.Value))
+	})
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].name < ss[j].name })
+	l := expvarLayout(ss)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.client == nil || l != e.layout {
+		return e.rebuild(ss, l)
+	}
+
+	return e.update(ss)
+}
+
+// Stop stops the underlying client, a later Collect starts a new one.
+func (e *ExpvarCollector) Stop() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.client == nil {
+		return errors.New("trying to stop a collector that has not collected anything")
+	}
+
+	err := e.client.Stop()
+	e.client, e.layout, e.metrics = nil, "", nil
+	return err
+}
+
+func (e *ExpvarCollector) rule(name string, t MetricType) (MetricSemantics, MetricUnit) {
+	for _, r := range e.rules {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			u := r.Unit
+			if u == nil {
+				u = OneUnit
+			}
+			return r.Semantics, u
+		}
+	}
+
+	if t == StringType {
+		return DiscreteSemantics, OneUnit
+	}
+
+	return InstantSemantics, OneUnit
+}
+
+func (e *ExpvarCollector) newSeries(name string, t MetricType, singleton bool) *expvarSeries {
+	sem, u := e.rule(name, t)
+	return &expvarSeries{name, t, sem, u, singleton, make(Instances)}
+}
+
+// walk converts a decoded expvar value into series
+func (e *ExpvarCollector) walk(ss []*expvarSeries, name string, val interface{}) []*expvarSeries {
+	switch v := val.(type) {
+	case int64, float64, string:
+		s := e.newSeries(name, expvarType(v), true)
+		s.vals[""] = v
+		return append(ss, s)
+	case map[string]interface{}:
+		if t, ok := expvarMapType(v); ok {
+			s := e.newSeries(name, t, false)
+			for k, iv := range v {
+				if f, isInt := iv.(int64); isInt && t == DoubleType {
+					s.vals[k] = float64(f)
+				} else {
+					s.vals[k] = iv
+				}
+			}
+			return append(ss, s)
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			ss = e.walk(ss, name+"."+expvarMetricName(k), v[k])
+		}
+	}
+
+	return ss
+}
+
+func (e *ExpvarCollector) rebuild(ss []*expvarSeries, l string) error {
+	client, err := NewPCPClient(e.name)
+	if err != nil {
+		return err
+	}
+
+	metrics := make(map[string]PCPMetric, len(ss))
+	var first error
+
+	for _, s := range ss {
+		m, err := newExpvarMetric(s)
+		if err == nil {
+			err = client.Register(m)
+		}
+
+		if err != nil {
+			if first == nil {
+				first = errors.Wrapf(err, "cannot publish %v", s.name)
+			}
+			continue
+		}
+
+		metrics[s.name] = m
+	}
+
+	// the old client has to go first, as both write to the same location
+	if e.client != nil {
+		if err := e.client.Stop(); err != nil {
+			return err
+		}
+		e.client = nil
+	}
+
+	if err := client.Start(); err != nil {
+		return err
+	}
+
+	e.client, e.layout, e.metrics = client, l, metrics
+	return first
+}
+
+func newExpvarMetric(s *expvarSeries) (PCPMetric, error) {
+	if s.singleton {
+		return NewPCPSingletonMetric(s.vals[""], s.name, s.t, s.sem, s.unit)
+	}
+
+	indom, err := NewPCPInstanceDomain(s.name+".indom", s.vals.Keys())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPCPInstanceMetric(s.vals, s.name, indom, s.t, s.sem, s.unit)
+}
+
+func (e *ExpvarCollector) update(ss []*expvarSeries) error {
+	for _, s := range ss {
+		switch m := e.metrics[s.name].(type) {
+		case *PCPSingletonMetric:
+			if err := m.Set(s.vals[""]); err != nil {
+				return err
+			}
+		case *PCPInstanceMetric:
+			for ins, v := range s.vals {
+				if err := m.SetInstance(v, ins); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// expvarLayout generates a signature of everything that requires a client restart on change
+func expvarLayout(ss []*expvarSeries) string {
+	var b strings.Builder
+	for _, s := range ss {
+		b.WriteString(s.name)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(int(s.t)))
+		b.WriteByte(0)
+		b.WriteString(strconv.FormatBool(s.singleton))
+
+		keys := s.vals.Keys()
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteByte(0)
+			b.WriteString(k)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// expvarMetricName converts an expvar key to a valid PCP metric name component
+func expvarMetricName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func expvarType(val interface{}) MetricType {
+	switch val.(type) {
+	case int64:
+		return Int64Type
+	case float64:
+		return DoubleType
+	}
+	return StringType
+}
+
+// expvarMapType checks if all values of a map are numbers or all values are strings,
+// returning the type of an instance metric that can hold them
+func expvarMapType(m map[string]interface{}) (MetricType, bool) {
+	if len(m) == 0 {
+		return 0, false
+	}
+
+	var t MetricType = -1
+	for _, v := range m {
+		switch v.(type) {
+		case int64:
+			if t == -1 {
+				t = Int64Type
+			} else if t == StringType {
+				return 0, false
+			}
+		case float64:
+			if t == StringType {
+				return 0, false
+			}
+			t = DoubleType
+		case string:
+			if t != -1 && t != StringType {
+				return 0, false
+			}
+			t = StringType
+		default:
+			return 0, false
+		}
+	}
+
+	return t, true
+}
+
+// expvarValue converts an expvar variable to an int64, float64, string or
+// a map of such values, or nil for anything that cannot be published
+func expvarValue(v expvar.Var) interface{} {
+	switch ev := v.(type) {
+	case *expvar.Int:
+		return ev.Value()
+	case *expvar.Float:
+		return ev.Value()
+	case *expvar.String:
+		return expvarString(ev.Value())
+	case *expvar.Map:
+		m := make(map[string]interface{})
+		ev.Do(func(kv expvar.KeyValue) {
+			if val := expvarValue(kv.Value); val != nil {
+				m[kv.Key] = val
+			}
+		})
+		return m
+	}
+
+	d := json.NewDecoder(strings.NewReader(v.String()))
+	d.UseNumber()
+
+	var val interface{}
+	if err := d.Decode(&val); err != nil {
+		return nil
+	}
+
+	return expvarJSONValue(val)
+}
+
+// expvarJSONValue normalizes a value decoded from JSON
+func expvarJSONValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case string:
+		return expvarString(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for k, iv := range v {
+			if nv := expvarJSONValue(iv); nv != nil {
+				m[k] = nv
+			}
+		}
+		return m
+	}
+
+	return nil
+}
+
+// expvarString truncates a string to fit in a PCP string, without splitting a character
+func expvarString(s string) string {
+	if len(s) < StringLength {
+		return s
+	}
+
+	n := StringLength - 1
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,138 @@
+package speed
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var (
+	expvarTestRequests = expvar.NewInt("speedtest_requests")
+	expvarTestLoad     = expvar.NewFloat("speedtest.load")
+	expvarTestVersion  = expvar.NewString("speedtest_version")
+	expvarTestCodes    = expvar.NewMap("speedtest_codes")
+	expvarTestNested   = expvar.NewMap("speedtest_nested")
+)
+
+func init() {
+	expvar.Publish("speedtest_func", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"uptime": 12.5,
+			"name":   "test",
+			"tags":   []string{"a", "b"},
+		}
+	}))
+}
+
+func TestExpvarCollector(t *testing.T) {
+	expvarTestRequests.Set(10)
+	expvarTestLoad.Set(0.5)
+	expvarTestVersion.Set("1.0")
+	expvarTestCodes.Add("200", 3)
+	expvarTestCodes.Add("404", 1)
+	expvarTestNested.Add("hits", 2)
+	expvarTestNested.Set("host", expvarTestVersion)
+
+	e, err := NewExpvarCollector("expvar_test", ExpvarRule{"speedtest_requests", CounterSemantics, OneUnit})
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	if err = e.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+	defer func() { _ = e.Stop() }()
+
+	requests, ok := e.metrics["speedtest_requests"].(*PCPSingletonMetric)
+	if !ok {
+		t.Fatalf("expected an Int to be published as a singleton metric, got %T", e.metrics["speedtest_requests"])
+	}
+
+	if requests.Val() != int64(10) || requests.Type() != Int64Type || requests.Semantics() != CounterSemantics {
+		t.Errorf("expected speedtest_requests to be a counter with value 10, got %v (%v, %v)", requests.Val(), requests.Type(), requests.Semantics())
+	}
+
+	if m := e.metrics["speedtest_load"]; m == nil || m.Type() != DoubleType || m.Semantics() != InstantSemantics {
+		t.Errorf("expected speedtest.load to be an instant double named speedtest_load, got %v", m)
+	}
+
+	if m := e.metrics["speedtest_version"]; m == nil || m.Type() != StringType || m.Semantics() != DiscreteSemantics {
+		t.Errorf("expected speedtest_version to be a discrete string, got %v", m)
+	}
+
+	codes, ok := e.metrics["speedtest_codes"].(*PCPInstanceMetric)
+	if !ok {
+		t.Fatalf("expected a map of numbers to be published as an instance metric, got %T", e.metrics["speedtest_codes"])
+	}
+
+	if v, err := codes.ValInstance("200"); err != nil || v != int64(3) {
+		t.Errorf("expected speedtest_codes[200] to be 3, got %v, error: %v", v, err)
+	}
+
+	if e.metrics["speedtest_nested.hits"] == nil || e.metrics["speedtest_nested.host"] == nil {
+		t.Error("expected a mixed map to be flattened into singleton metrics")
+	}
+
+	if e.metrics["speedtest_func.uptime"] == nil || e.metrics["speedtest_func.name"] == nil {
+		t.Error("expected a Func to be decoded from JSON")
+	}
+
+	if e.metrics["speedtest_func.tags"] != nil {
+		t.Error("expected arrays to be skipped")
+	}
+
+	// updating a value keeps the client
+
+	client := e.client
+	expvarTestRequests.Add(5)
+
+	if err = e.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	if e.client != client {
+		t.Error("expected the client to be kept when only values change")
+	}
+
+	if v := e.metrics["speedtest_requests"].(*PCPSingletonMetric).Val(); v != int64(15) {
+		t.Errorf("expected speedtest_requests to be 15, got %v", v)
+	}
+
+	// a new map key restarts the client
+
+	expvarTestCodes.Add("500", 1)
+
+	if err = e.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	if e.client == client {
+		t.Error("expected the client to be restarted when a new map key appears")
+	}
+
+	if v, err := e.metrics["speedtest_codes"].(*PCPInstanceMetric).ValInstance("500"); err != nil || v != int64(1) {
+		t.Errorf("expected speedtest_codes[500] to be 1, got %v, error: %v", v, err)
+	}
+}
+
+func TestNewExpvarCollector(t *testing.T) {
+	if _, err := NewExpvarCollector("test", ExpvarRule{Pattern: "["}); err == nil {
+		t.Error("expected an invalid pattern to generate an error")
+	}
+
+	if _, err := NewExpvarCollector("a/b"); err == nil {
+		t.Error("expected an invalid name to generate an error")
+	}
+}
+
+func TestExpvarString(t *testing.T) {
+	s := expvarString(strings.Repeat("a", StringLength-2) + "é")
+	if len(s) != StringLength-2 || !utf8.ValidString(s) {
+		t.Errorf("expected the string to be truncated before a character that does not fit, got %v bytes", len(s))
+	}
+
+	if s := expvarString("short"); s != "short" {
+		t.Errorf("expected a short string to be kept, got %v", s)
+	}
+}
@@ -0,0 +1,58 @@
+package speed
+
+import (
+	"io"
+	"sort"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+	"github.com/pkg/errors"
+)
+
+// WriteGrafanaDashboard writes the JSON of a Grafana dashboard for the metrics in a registry,
+// named the way pmdammv exposes the MMV file written by a PCPClient with the passed name and flag.
+//
+// Counters are graphed as rates, other metrics as values, instances as series and histograms
+// by their minimum, mean and maximum, as their percentiles are not written to the MMV file,
+// see mmvdump.WriteGrafanaDashboard.
+func WriteGrafanaDashboard(w io.Writer, title, name string, flag MMVFlag, registry *PCPRegistry) error {
+	if registry == nil {
+		return errors.New("the registry cannot be nil")
+	}
+
+	prefix, err := mmvPrefix(name, flag)
+	if err != nil {
+		return err
+	}
+
+	var metrics []*mmvdump.MetricDesc
+	for _, m := range registry.pcpMetrics() {
+		metrics = append(metrics, grafanaMetric(registry, m))
+	}
+
+	return mmvdump.WriteGrafanaDashboard(w, title, prefix, metrics)
+}
+
+// grafanaMetric describes a metric the way mmvdump describes metrics read from an MMV file
+func grafanaMetric(r *PCPRegistry, m PCPMetric) *mmvdump.MetricDesc {
+	d := &mmvdump.MetricDesc{
+		Name:      r.metricName(m),
+		Item:      r.metricID(m),
+		Type:      mmvdump.Type(m.Type()),
+		Semantics: mmvdump.Semantics(m.Semantics()),
+		Indom:     mmvdump.NoIndom,
+		ShortText: m.ShortDescription(),
+		LongText:  m.LongDescription(),
+	}
+
+	if m.Unit() != nil {
+		d.Unit = mmvdump.Unit(m.Unit().PMAPI())
+	}
+
+	if indom := m.Indom(); indom != nil {
+		d.Indom = int32(r.indomID(indom))
+		d.Instances = indom.Instances()
+		sort.Strings(d.Instances)
+	}
+
+	return d
+}
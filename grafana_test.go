@@ -0,0 +1,68 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteGrafanaDashboard(t *testing.T) {
+	r := NewPCPRegistry()
+
+	requests, err := NewPCPCounter(0, "http.requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latency, err := NewPCPHistogram("http.latency", 0, 1000, 3, MillisecondUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Metric{requests, latency} {
+		if err = r.AddMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = WriteGrafanaDashboard(&bytes.Buffer{}, "app", "", 0, r); err == nil {
+		t.Error("expected an error for an empty client name")
+	}
+
+	var buf bytes.Buffer
+	if err = WriteGrafanaDashboard(&buf, "app", "app", ProcessFlag, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var d struct {
+		Panels []struct {
+			Title   string
+			Targets []struct {
+				Expr, LegendFormat string
+			}
+			FieldConfig struct {
+				Defaults  struct{ Unit string }
+				Overrides []interface{}
+			}
+		}
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Panels) != 2 {
+		t.Fatalf("expected 2 panels, got %v", len(d.Panels))
+	}
+
+	h, c := d.Panels[0], d.Panels[1]
+
+	if h.Title != "mmv.app.http.latency (min, mean, max)" || h.Targets[0].LegendFormat != "$instance" ||
+		h.FieldConfig.Defaults.Unit != "ms" || len(h.FieldConfig.Overrides) != 2 {
+		t.Errorf("unexpected histogram panel %+v", h)
+	}
+
+	if c.Title != "mmv.app.http.requests" || c.Targets[0].Expr != "mmv.app.http.requests" || c.FieldConfig.Defaults.Unit != "cps" {
+		t.Errorf("unexpected counter panel %+v", c)
+	}
+}
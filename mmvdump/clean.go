@@ -0,0 +1,62 @@
+package mmvdump
+
+import "os"
+
+// Stale reports whether a file was written with ProcessFlag by a process that is no longer running.
+// pmcd keeps exporting the last values of such files until they are removed.
+func (f *FileInfo) Stale() bool { return f.Err == nil && f.Tracked && !f.Alive }
+
+// Clean removes the stale files in dir, which is usually MMVDir(), returning the files it removed.
+// If dryRun is true, it returns the files it would remove without removing anything.
+//
+// Every file is read again right before it is removed, so a file that was rewritten by a new
+// process after dir was listed is kept. Clean keeps going if a file cannot be removed,
+// returning the first such error along with the files it did remove.
+func Clean(dir string, dryRun bool) ([]*FileInfo, error) {
+	files, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ans      []*FileInfo
+		firstErr error
+	)
+
+	for _, f := range files {
+		if !f.Stale() {
+			continue
+		}
+
+		if dryRun {
+			ans = append(ans, f)
+			continue
+		}
+
+		removed, err := remove(f)
+		if err != nil && firstErr == nil && !os.IsNotExist(err) {
+			firstErr = err
+		}
+
+		if removed {
+			ans = append(ans, f)
+		}
+	}
+
+	return ans, firstErr
+}
+
+// remove removes a stale file after reading it again, so a file that was rewritten
+// by a new process since f was read is kept
+func remove(f *FileInfo) (bool, error) {
+	current := &FileInfo{Path: f.Path}
+	if current.read() != nil || !current.Stale() || current.Generation != f.Generation || current.Process != f.Process {
+		return false, nil
+	}
+
+	if err := os.Remove(f.Path); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmvdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	data, err := ioutil.ReadFile("testdata/test1.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test1.mmv was written with ProcessFlag by a process that is long gone
+	if err = ioutil.WriteFile(filepath.Join(dir, "dead"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	untracked := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(untracked[28:], 0)
+	if err = ioutil.WriteFile(filepath.Join(dir, "untracked"), untracked, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	alive := append([]byte(nil), data...)
+	now := uint64(time.Now().Unix())
+	binary.LittleEndian.PutUint64(alive[8:], now)
+	binary.LittleEndian.PutUint64(alive[16:], now)
+	binary.LittleEndian.PutUint32(alive[32:], uint32(os.Getpid()))
+	if err = ioutil.WriteFile(filepath.Join(dir, "alive"), alive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// writers keep their file mapped while they run
+	r, err := Open(filepath.Join(dir, "alive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "invalid"), []byte("not a mmv file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(files []*FileInfo, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 1 || files[0].Path != filepath.Join(dir, "dead") {
+			t.Fatalf("expected only the dead file to be stale, got %v", files)
+		}
+	}
+
+	check(Clean(dir, true))
+	if _, err = os.Stat(filepath.Join(dir, "dead")); err != nil {
+		t.Errorf("expected a dry run to keep the stale file, got %v", err)
+	}
+
+	check(Clean(dir, false))
+	if _, err = os.Stat(filepath.Join(dir, "dead")); !os.IsNotExist(err) {
+		t.Errorf("expected the stale file to be removed, got %v", err)
+	}
+
+	for _, name := range []string{"untracked", "alive", "invalid"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %v to be kept, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// mmv2prom serves the metrics in MMV files over HTTP in the Prometheus text
+// exposition format, so they can be scraped on hosts without pmcd.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+var (
+	addr = flag.String("addr", ":9101", "address to listen on")
+	path = flag.String("path", "/metrics", "path to serve metrics on")
+)
+
+// files returns the MMV files for the passed arguments, expanding directories to the files in them
+func files(args []string) []string {
+	var ans []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil || !fi.IsDir() {
+			ans = append(ans, arg)
+			continue
+		}
+
+		fs, err := mmvdump.List(arg)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		for _, f := range fs {
+			if f.Err == nil {
+				ans = append(ans, f.Path)
+			}
+		}
+	}
+	return ans
+}
+
+// handler opens every file for the passed arguments on every request and writes their metrics,
+// files that cannot be read are logged and skipped
+func handler(args []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		scrape(w, files(args))
+	}
+}
+
+func scrape(w http.ResponseWriter, files []string) {
+	var readers []*mmvdump.Reader
+	for _, f := range files {
+		r, err := mmvdump.Open(f)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		defer func() { _ = r.Close() }()
+
+		readers = append(readers, r)
+	}
+
+	var buf bytes.Buffer
+	if err := mmvdump.WritePrometheus(&buf, readers...); err != nil {
+		// the files that could be read are still written
+		log.Println(err)
+	}
+
+	w.Header().Set("Content-Type", mmvdump.PrometheusContentType)
+	_, _ = buf.WriteTo(w)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: mmv2prom [-addr :9101] [-path /metrics] [file or dir]...")
+		fmt.Fprintf(flag.CommandLine.Output(), "serves all files in %v by default\n", mmvdump.MMVDir())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{mmvdump.MMVDir()}
+	}
+
+	http.Handle(*path, handler(args))
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
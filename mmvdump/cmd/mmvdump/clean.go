@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// clean removes MMV files left behind by processes that are no longer running
+func clean(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "only print the files that would be removed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump clean [-n] [dir]")
+		fmt.Fprintf(fs.Output(), "dir defaults to %v\n", mmvdump.MMVDir())
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	dir := mmvdump.MMVDir()
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		dir = fs.Arg(0)
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	files, err := mmvdump.Clean(dir, *dryRun)
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+
+	for _, f := range files {
+		fmt.Printf("%v %v (pid %v)\n", verb, f.Path, f.Process)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// diff compares two MMV files, exiting with status 1 if any incompatible change is found
+// and 2 if the files cannot be compared
+func diff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	values := fs.Bool("values", true, "report changed values")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump diff [-values=false] <old> <new>")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	changes, err := diffFiles(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	incompatible, err := writeChanges(os.Stdout, changes, *values)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if incompatible {
+		return 1
+	}
+
+	return 0
+}
+
+func diffFiles(beforeFile, afterFile string) ([]mmvdump.Change, error) {
+	before, err := mmvdump.Open(beforeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = before.Close() }()
+
+	after, err := mmvdump.Open(afterFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = after.Close() }()
+
+	return mmvdump.Diff(before, after)
+}
+
+func writeChanges(w io.Writer, changes []mmvdump.Change, values bool) (bool, error) {
+	incompatible := false
+
+	for _, c := range changes {
+		if c.Field == "value" && !values {
+			continue
+		}
+
+		prefix := " "
+		if c.Incompatible() {
+			prefix = "!"
+			incompatible = true
+		}
+
+		if _, err := fmt.Fprintln(w, prefix, c); err != nil {
+			return incompatible, err
+		}
+	}
+
+	return incompatible, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// fsck validates MMV files, exiting with status 1 if any file has problems
+// and 2 if any file cannot be read
+func fsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only set the exit status, without reporting problems")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump fsck [-q] <file>...")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, file := range fs.Args() {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		ps := mmvdump.Validate(data)
+		if len(ps) > 0 && status == 0 {
+			status = 1
+		}
+
+		if *quiet {
+			continue
+		}
+
+		for _, p := range ps {
+			fmt.Printf("%v: %v\n", file, p)
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// grafana writes a Grafana dashboard for the metrics in MMV files
+func grafana(args []string) int {
+	fs := flag.NewFlagSet("grafana", flag.ExitOnError)
+	title := fs.String("title", "", "dashboard title, defaults to the name of the first file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump grafana [-title title] <file>...")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var readers []*mmvdump.Reader
+	for _, file := range fs.Args() {
+		r, err := mmvdump.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer func(r *mmvdump.Reader) { _ = r.Close() }(r)
+
+		readers = append(readers, r)
+	}
+
+	if *title == "" {
+		*title = readers[0].Name()
+	}
+
+	if err := mmvdump.WriteGrafana(os.Stdout, *title, readers...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
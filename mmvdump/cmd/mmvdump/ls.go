@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// ls lists the MMV files in a directory, defaulting to the one speed writes to
+func ls(args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump ls [dir]")
+		fmt.Fprintf(fs.Output(), "dir defaults to %v\n", mmvdump.MMVDir())
+	}
+	_ = fs.Parse(args)
+
+	dir := mmvdump.MMVDir()
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		dir = fs.Arg(0)
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	files, err := mmvdump.List(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tPID\tALIVE\tCLUSTER\tVERSION\tGENERATION\tMETRICS\tINDOMS\tINSTANCES\tVALUES\tSIZE")
+
+	for _, f := range files {
+		name := filepath.Base(f.Path)
+
+		if f.Err != nil {
+			fmt.Fprintf(w, "%v\t-\t-\t-\t-\t-\t-\t-\t-\t-\t%v\t(%v)\n", name, f.Size, f.Err)
+			continue
+		}
+
+		alive := "-"
+		if f.Tracked {
+			alive = fmt.Sprint(f.Alive)
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			name, f.Process, alive, f.Cluster, f.Version, f.Generation,
+			f.Metrics, f.Indoms, f.Instances, f.Values, f.Size)
+	}
+
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	return 0
+}
@@ -1,35 +1,114 @@
 package main
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/performancecopilot/speed/v4/mmvdump"
 )
 
+var (
+	format   = flag.String("format", "text", "output format, one of text, json or csv")
+	watching = flag.Bool("watch", false, "sample values every interval, printing rates for counters")
+	interval = flag.Duration("interval", time.Second, "sampling interval for -watch")
+	metric   = flag.String("metric", "", "only watch metrics with names matching this pattern, in path.Match syntax")
+	order    = flag.String("byteorder", "auto", "byte order the file was written in, one of auto, little or big")
+)
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			os.Exit(diff(os.Args[2:]))
+		case "fsck":
+			os.Exit(fsck(os.Args[2:]))
+		case "ls":
+			os.Exit(ls(os.Args[2:]))
+		case "clean":
+			os.Exit(clean(os.Args[2:]))
+		case "record":
+			os.Exit(record(os.Args[2:]))
+		case "replay":
+			os.Exit(replay(os.Args[2:]))
+		case "grafana":
+			os.Exit(grafana(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	if flag.NArg() < 1 {
-		fmt.Println("usage: mmvdump <file>")
+		fmt.Println("usage: mmvdump [-format text|json|csv] [-byteorder auto|little|big] <file>")
+		fmt.Println("       mmvdump -watch [-interval 1s] [-metric pattern] <file>")
+		fmt.Println("       mmvdump diff [-values=false] <old> <new>")
+		fmt.Println("       mmvdump fsck [-q] <file>...")
+		fmt.Println("       mmvdump ls [dir]")
+		fmt.Println("       mmvdump clean [-n] [dir]")
+		fmt.Println("       mmvdump record [-interval 1s] [-count n] <file> <recording>")
+		fmt.Println("       mmvdump replay [-format csv|json] <recording>")
+		fmt.Println("       mmvdump grafana [-title title] <file>...")
 		return
 	}
 
 	file := flag.Arg(0)
+
+	if *watching {
+		if *interval <= 0 {
+			fmt.Fprintln(os.Stderr, "the interval has to be positive")
+			os.Exit(2)
+		}
+
+		if err := watch(os.Stdout, file, *interval, *metric); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	write := mmvdump.Write
+	switch *format {
+	case "text":
+	case "json":
+		write = mmvdump.WriteJSON
+	case "csv":
+		write = mmvdump.WriteCSV
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %v, expected one of text, json or csv\n", *format)
+		os.Exit(2)
+	}
+
 	d, err := ioutil.ReadFile(file)
 	if err != nil {
 		panic(err)
 	}
 
-	header, tocs, metrics, values, instances, indoms, strings, err := mmvdump.Dump(d)
+	var byteOrder binary.ByteOrder
+	switch *order {
+	case "auto":
+		byteOrder = mmvdump.DetectByteOrder(d)
+	case "little":
+		byteOrder = binary.LittleEndian
+	case "big":
+		byteOrder = binary.BigEndian
+	default:
+		fmt.Fprintf(os.Stderr, "unknown byte order %v, expected one of auto, little or big\n", *order)
+		os.Exit(2)
+	}
+
+	header, tocs, metrics, values, instances, indoms, strings, err := mmvdump.DumpWithByteOrder(d, byteOrder)
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("File      = %v\n", file)
-	if err := mmvdump.Write(os.Stdout, header, tocs, metrics, values, instances, indoms, strings); err != nil {
+	if *format == "text" {
+		fmt.Printf("File      = %v\n", file)
+	}
+
+	if err := write(os.Stdout, header, tocs, metrics, values, instances, indoms, strings); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// record appends samples of a MMV file to a recording every interval,
+// until it has taken count samples or fails to read the file
+func record(args []string) int {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Second, "sampling interval")
+	count := fs.Int("count", 0, "number of samples to take, 0 to keep sampling until the file cannot be read")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump record [-interval 1s] [-count n] <file> <recording>")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "the interval has to be positive")
+		return 2
+	}
+
+	r, err := mmvdump.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { _ = r.Close() }()
+
+	out, err := os.OpenFile(fs.Arg(1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { _ = out.Close() }()
+
+	rec := mmvdump.NewRecorder(out)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			<-ticker.C
+		}
+
+		if err := rec.Record(r, time.Now()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// replay writes a recording as CSV or JSON time series
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format, one of csv or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mmvdump replay [-format csv|json] <recording>")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	write := mmvdump.WriteRecordingCSV
+	switch *format {
+	case "csv":
+	case "json":
+		write = mmvdump.WriteRecordingJSON
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %v, expected one of csv or json\n", *format)
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := write(os.Stdout, mmvdump.NewRecordingReader(f)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
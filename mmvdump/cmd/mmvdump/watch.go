@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// watch samples the file every interval, printing current values for most metrics
+// and rates for counters, until it fails to read the file. A file that is missing or
+// being rewritten while its writer restarts is polled until it can be read again.
+func watch(w io.Writer, file string, interval time.Duration, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	r, err := mmvdump.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		prev     *mmvdump.Snapshot
+		prevTime time.Time
+		waiting  bool
+	)
+
+	for {
+		s, err := r.Snapshot()
+		if os.IsNotExist(err) || err == mmvdump.ErrFileRewritten {
+			// the writer is restarting, keep polling until it has written the new file
+			if !waiting {
+				if _, err := fmt.Fprintf(w, "File      = %v is being rewritten, waiting for the writer\n\n", file); err != nil {
+					return err
+				}
+			}
+
+			prev, waiting = nil, true
+			<-ticker.C
+			continue
+		} else if err != nil {
+			return err
+		}
+		now := time.Now()
+		waiting = false
+
+		if s.Reloaded {
+			// values of the old generation cannot be compared with the new ones
+			prev = nil
+			if _, err := fmt.Fprintf(w, "File      = %v, Generation = %v, Process = %v\n\n", file, s.Header.G1, s.Header.Process); err != nil {
+				return err
+			}
+		}
+
+		if err := writeSample(w, r, prev, s, now.Sub(prevTime), pattern, now); err != nil {
+			return err
+		}
+
+		prev, prevTime = s, now
+		<-ticker.C
+	}
+}
+
+func writeSample(w io.Writer, r *mmvdump.Reader, prev, cur *mmvdump.Snapshot, elapsed time.Duration, pattern string, now time.Time) error {
+	if _, err := fmt.Fprintln(w, now.Format("15:04:05.000")); err != nil {
+		return err
+	}
+
+	for _, m := range r.Metrics() {
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, m.Name); !ok {
+				continue
+			}
+		}
+
+		vals := cur.Values[m.Name]
+		instances := make([]string, 0, len(vals))
+		for ins := range vals {
+			instances = append(instances, ins)
+		}
+		sort.Strings(instances)
+
+		for _, ins := range instances {
+			name := m.Name
+			if ins != "" {
+				name += "[" + ins + "]"
+			}
+
+			var line string
+			switch {
+			case m.Semantics != mmvdump.CounterSemantics:
+				line = fmt.Sprintf("\t%v = %v %v", name, vals[ins], m.Unit)
+			case prev == nil:
+				// a rate needs two samples
+				line = fmt.Sprintf("\t%v = ?", name)
+			default:
+				rate, err := mmvdump.Rate(m.Unit, prev.Values[m.Name][ins], vals[ins], elapsed)
+				if err != nil {
+					line = fmt.Sprintf("\t%v = ? (%v)", name, err)
+				} else {
+					line = fmt.Sprintf("\t%v = %.4g %v", name, rate, mmvdump.RateUnit(m.Unit))
+				}
+			}
+
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,256 @@
+package mmvdump
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ChangeKind is an enumerated type representing the kinds of differences between two MMV files
+type ChangeKind int
+
+// Values for ChangeKind
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "changed"
+	}
+	return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Change describes a single difference between two MMV files
+type Change struct {
+	Kind ChangeKind
+
+	// Metric is the name of the metric that changed, if any
+	Metric string
+
+	// Indom is the serial of the instance domain that changed, if any
+	Indom uint32
+
+	// Instance is the name of the instance that was added, removed or whose value changed, if any
+	Instance string
+
+	// Field is the name of the header field, descriptor field or "value" for modified items
+	Field string
+
+	Old, New interface{}
+}
+
+func (c Change) String() string {
+	var subject string
+	switch {
+	case c.Metric != "" && c.Instance != "":
+		subject = fmt.Sprintf("metric %v[%v]", c.Metric, c.Instance)
+	case c.Metric != "":
+		subject = "metric " + c.Metric
+	case c.Instance != "":
+		subject = fmt.Sprintf("instance %v of indom %v", c.Instance, c.Indom)
+	case c.Indom != 0:
+		subject = fmt.Sprintf("indom %v", c.Indom)
+	default:
+		subject = "header"
+	}
+
+	if c.Kind != Modified {
+		return c.Kind.String() + " " + subject
+	}
+
+	return fmt.Sprintf("changed %v of %v: %v -> %v", c.Field, subject, c.Old, c.New)
+}
+
+// Incompatible reports whether the change can break consumers of the old file, like pmlogger
+// archives, pmie rules and dashboards. Removals and changes to anything identifying or interpreting
+// a metric are incompatible, while additions and changes to values, help texts and the header
+// fields that change on every restart are not.
+func (c Change) Incompatible() bool {
+	switch c.Kind {
+	case Added:
+		return false
+	case Removed:
+		return true
+	}
+
+	switch c.Field {
+	case "value", "shorttext", "longtext", "generation", "process", "version":
+		return false
+	}
+
+	return true
+}
+
+// Diff compares the metadata and current values of two MMV files, returning all
+// differences between them sorted by the header, instance domains and then metrics.
+func Diff(before, after *Reader) ([]Change, error) {
+	osnap, err := before.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	nsnap, err := after.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+
+	modified := func(c Change, o, n interface{}) {
+		if o != n {
+			c.Kind, c.Old, c.New = Modified, o, n
+			changes = append(changes, c)
+		}
+	}
+
+	oh, nh := osnap.Header, nsnap.Header
+	modified(Change{Field: "version"}, oh.Version, nh.Version)
+	modified(Change{Field: "cluster"}, oh.Cluster, nh.Cluster)
+	modified(Change{Field: "flags"}, oh.Flag, nh.Flag)
+	modified(Change{Field: "process"}, oh.Process, nh.Process)
+	modified(Change{Field: "generation"}, oh.G1, nh.G1)
+
+	changes = append(changes, diffIndoms(before.InstanceDomains(), after.InstanceDomains())...)
+
+	oms, nms := before.Metrics(), after.Metrics()
+	for _, name := range mergedNames(oms, nms) {
+		om, inOld := before.Metric(name)
+		nm, inNew := after.Metric(name)
+
+		switch {
+		case !inNew:
+			changes = append(changes, Change{Kind: Removed, Metric: name})
+			continue
+		case !inOld:
+			changes = append(changes, Change{Kind: Added, Metric: name})
+			continue
+		}
+
+		c := Change{Metric: name}
+		modified(with(c, "item"), om.Item, nm.Item)
+		modified(with(c, "type"), om.Type, nm.Type)
+		modified(with(c, "semantics"), om.Semantics, nm.Semantics)
+		modified(with(c, "units"), om.Unit, nm.Unit)
+		modified(with(c, "indom"), om.Indom, nm.Indom)
+		modified(with(c, "shorttext"), om.ShortText, nm.ShortText)
+		modified(with(c, "longtext"), om.LongText, nm.LongText)
+
+		ovals, nvals := osnap.Values[name], nsnap.Values[name]
+		for _, ins := range mergedKeys(ovals, nvals) {
+			ov, inOld := ovals[ins]
+			nv, inNew := nvals[ins]
+
+			ic := Change{Metric: name, Instance: ins}
+			switch {
+			case !inNew:
+				ic.Kind = Removed
+				changes = append(changes, ic)
+			case !inOld:
+				ic.Kind = Added
+				changes = append(changes, ic)
+			default:
+				modified(with(ic, "value"), ov, nv)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func with(c Change, field string) Change {
+	c.Field = field
+	return c
+}
+
+func diffIndoms(before, after []*InstanceDomainDesc) []Change {
+	var changes []Change
+
+	oi, ni := make(map[uint32]*InstanceDomainDesc), make(map[uint32]*InstanceDomainDesc)
+	var serials []uint32
+	for _, d := range before {
+		oi[d.Serial] = d
+		serials = append(serials, d.Serial)
+	}
+	for _, d := range after {
+		if _, ok := oi[d.Serial]; !ok {
+			serials = append(serials, d.Serial)
+		}
+		ni[d.Serial] = d
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+
+	for _, s := range serials {
+		od, nd := oi[s], ni[s]
+		switch {
+		case nd == nil:
+			changes = append(changes, Change{Kind: Removed, Indom: s})
+			continue
+		case od == nil:
+			changes = append(changes, Change{Kind: Added, Indom: s})
+			continue
+		}
+
+		if od.ShortText != nd.ShortText {
+			changes = append(changes, Change{Kind: Modified, Indom: s, Field: "shorttext", Old: od.ShortText, New: nd.ShortText})
+		}
+
+		if od.LongText != nd.LongText {
+			changes = append(changes, Change{Kind: Modified, Indom: s, Field: "longtext", Old: od.LongText, New: nd.LongText})
+		}
+
+		oset, nset := make(map[string]interface{}), make(map[string]interface{})
+		for _, i := range od.Instances {
+			oset[i] = nil
+		}
+		for _, i := range nd.Instances {
+			nset[i] = nil
+		}
+
+		for _, i := range mergedKeys(oset, nset) {
+			_, inOld := oset[i]
+			_, inNew := nset[i]
+
+			switch {
+			case !inNew:
+				changes = append(changes, Change{Kind: Removed, Indom: s, Instance: i})
+			case !inOld:
+				changes = append(changes, Change{Kind: Added, Indom: s, Instance: i})
+			}
+		}
+	}
+
+	return changes
+}
+
+func mergedNames(before, after []*MetricDesc) []string {
+	seen := make(map[string]interface{})
+	for _, m := range before {
+		seen[m.Name] = nil
+	}
+	for _, m := range after {
+		seen[m.Name] = nil
+	}
+	return mergedKeys(seen, nil)
+}
+
+func mergedKeys(a, b map[string]interface{}) []string {
+	ans := make([]string, 0, len(a))
+	for k := range a {
+		ans = append(ans, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			ans = append(ans, k)
+		}
+	}
+	sort.Strings(ans)
+	return ans
+}
@@ -0,0 +1,81 @@
+package mmvdump
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func readTestdata(name string, t *testing.T) *Reader {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestDiff(t *testing.T) {
+	changes, err := Diff(readTestdata("test1.mmv", t), readTestdata("test2.mmv", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{Kind: Modified, Field: "cluster", Old: int32(127), New: int32(1297)},
+		{Kind: Modified, Field: "process", Old: int32(29956), New: int32(6410)},
+		{Kind: Modified, Field: "generation", Old: uint64(1468770536), New: uint64(1469335238)},
+		{Kind: Added, Indom: 3094651},
+		{Kind: Added, Metric: "language.users"},
+		{Kind: Removed, Metric: "simple.counter"},
+	}
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected\n%v\ngot\n%v", expected, changes)
+	}
+
+	var incompatible []string
+	for _, c := range changes {
+		if c.Incompatible() {
+			incompatible = append(incompatible, c.String())
+		}
+	}
+
+	if e := []string{"changed cluster of header: 127 -> 1297", "removed metric simple.counter"}; !reflect.DeepEqual(incompatible, e) {
+		t.Errorf("expected incompatible changes %v, got %v", e, incompatible)
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	changes, err := Diff(readTestdata("test3.mmv", t), readTestdata("test4.mmv", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := changes[len(changes)-1]
+	if e := (Change{Kind: Modified, Metric: "bat.names", Field: "value", Old: "Robin", New: ""}); last != e {
+		t.Errorf("expected %v, got %v", e, last)
+	}
+
+	for _, c := range changes {
+		if c.Incompatible() {
+			t.Errorf("expected %v to be compatible", c)
+		}
+	}
+}
+
+func TestDiffSame(t *testing.T) {
+	changes, err := Diff(readTestdata("test2.mmv", t), readTestdata("test2.mmv", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 0 {
+		t.Errorf("expected no changes between identical files, got %v", changes)
+	}
+}
@@ -0,0 +1,327 @@
+package mmvdump
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+type instanceJSON struct {
+	Internal int32  `json:"internal"`
+	External string `json:"external"`
+}
+
+type indomJSON struct {
+	Serial    uint32         `json:"serial"`
+	Offset    uint64         `json:"offset"`
+	Instances []instanceJSON `json:"instances"`
+	ShortText string         `json:"shorttext,omitempty"`
+	LongText  string         `json:"longtext,omitempty"`
+}
+
+type valueJSON struct {
+	Offset   uint64      `json:"offset"`
+	Instance *string     `json:"instance,omitempty"`
+	Value    interface{} `json:"value"`
+}
+
+type metricJSON struct {
+	Name      string      `json:"name"`
+	Item      uint32      `json:"item"`
+	Offset    uint64      `json:"offset"`
+	Type      string      `json:"type"`
+	Semantics string      `json:"semantics"`
+	Units     string      `json:"units"`
+	Indom     *int32      `json:"indom,omitempty"`
+	ShortText string      `json:"shorttext,omitempty"`
+	LongText  string      `json:"longtext,omitempty"`
+	Values    []valueJSON `json:"values"`
+}
+
+type dumpJSON struct {
+	Version    int32         `json:"version"`
+	Generation uint64        `json:"generation"`
+	TocCount   int32         `json:"tocs"`
+	Cluster    int32         `json:"cluster"`
+	Process    int32         `json:"process"`
+	Flags      int32         `json:"flags"`
+	Indoms     []*indomJSON  `json:"indoms"`
+	Metrics    []*metricJSON `json:"metrics"`
+}
+
+func sortOffsets(offs []uint64) []uint64 {
+	sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+	return offs
+}
+
+// jsonFloat converts the float values JSON cannot represent to strings
+func jsonFloat(v interface{}, f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return v
+}
+
+// decodeValue returns the value held by v for a metric of type t
+func decodeValue(v *Value, t Type, strings map[uint64]*String) (interface{}, error) {
+	if t == StringType {
+		s, ok := strings[uint64(v.Extra)]
+		if !ok {
+			return nil, errors.Errorf("invalid string address")
+		}
+		return cstring(s.Payload[:]), nil
+	}
+
+	return FixedVal(v.Val, t)
+}
+
+// textAt resolves an optional string offset
+func textAt(off uint64, strings map[uint64]*String) string {
+	if s, ok := strings[off]; off != 0 && ok {
+		return cstring(s.Payload[:])
+	}
+	return ""
+}
+
+// structured builds a structured representation of a dump, with everything in file order
+func structured(
+	header *Header,
+	tocs []*Toc,
+	metrics map[uint64]Metric,
+	values map[uint64]*Value,
+	instances map[uint64]Instance,
+	indoms map[uint64]*InstanceDomain,
+	strings map[uint64]*String,
+) (*dumpJSON, error) {
+	d := &dumpJSON{
+		Version:    header.Version,
+		Generation: header.G1,
+		TocCount:   header.Toc,
+		Cluster:    header.Cluster,
+		Process:    header.Process,
+		Flags:      header.Flag,
+		Indoms:     []*indomJSON{},
+		Metrics:    []*metricJSON{},
+	}
+
+	instanceLength := Instance1Length
+	if header.Version == 2 {
+		instanceLength = Instance2Length
+	}
+
+	indomOffsets := make([]uint64, 0, len(indoms))
+	for off := range indoms {
+		indomOffsets = append(indomOffsets, off)
+	}
+
+	for _, off := range sortOffsets(indomOffsets) {
+		indom := indoms[off]
+		id := &indomJSON{
+			Serial:    indom.Serial,
+			Offset:    off,
+			Instances: []instanceJSON{},
+			ShortText: textAt(indom.Shorttext, strings),
+			LongText:  textAt(indom.Longtext, strings),
+		}
+
+		for i, ioff := uint32(0), indom.Offset; i < indom.Count; i, ioff = i+1, ioff+instanceLength {
+			ins, ok := instances[ioff]
+			if !ok {
+				return nil, errors.Errorf("no instance at offset %v for indom %v", ioff, indom.Serial)
+			}
+			name, err := instanceName(ins, header, strings)
+			if err != nil {
+				return nil, err
+			}
+			id.Instances = append(id.Instances, instanceJSON{ins.Internal(), cstring([]byte(name))})
+		}
+
+		d.Indoms = append(d.Indoms, id)
+	}
+
+	byOffset := make(map[uint64]*metricJSON, len(metrics))
+	metricOffsets := make([]uint64, 0, len(metrics))
+	for off := range metrics {
+		metricOffsets = append(metricOffsets, off)
+	}
+
+	for _, off := range sortOffsets(metricOffsets) {
+		m := metrics[off]
+		name, err := metricName(m, header, strings)
+		if err != nil {
+			return nil, err
+		}
+
+		md := &metricJSON{
+			Name:      cstring([]byte(name)),
+			Item:      m.Item(),
+			Offset:    off,
+			Type:      m.Typ().String(),
+			Semantics: m.Sem().String(),
+			Units:     m.Unit().String(),
+			ShortText: textAt(m.ShortText(), strings),
+			LongText:  textAt(m.LongText(), strings),
+			Values:    []valueJSON{},
+		}
+
+		if m.Indom() != NoIndom {
+			indom := m.Indom()
+			md.Indom = &indom
+		}
+
+		byOffset[off] = md
+		d.Metrics = append(d.Metrics, md)
+	}
+
+	valueOffsets := make([]uint64, 0, len(values))
+	for off := range values {
+		valueOffsets = append(valueOffsets, off)
+	}
+
+	for _, off := range sortOffsets(valueOffsets) {
+		v := values[off]
+		md, ok := byOffset[v.Metric]
+		if !ok {
+			return nil, errors.Errorf("value at offset %v refers to a missing metric", off)
+		}
+
+		val, err := decodeValue(v, metrics[v.Metric].Typ(), strings)
+		if err != nil {
+			return nil, err
+		}
+
+		switch f := val.(type) {
+		case float32:
+			val = jsonFloat(f, float64(f))
+		case float64:
+			val = jsonFloat(f, f)
+		}
+
+		vd := valueJSON{Offset: off, Value: val}
+
+		if v.Instance != 0 {
+			ins, ok := instances[v.Instance]
+			if !ok {
+				return nil, errors.Errorf("value at offset %v refers to a missing instance", off)
+			}
+
+			name, err := instanceName(ins, header, strings)
+			if err != nil {
+				return nil, err
+			}
+
+			name = cstring([]byte(name))
+			vd.Instance = &name
+		}
+
+		md.Values = append(md.Values, vd)
+	}
+
+	return d, nil
+}
+
+// WriteJSON writes a MMV dump as a JSON document to the passed writer.
+//
+// The document holds the header fields along with lists of instance domains and metrics in file order,
+// with every metric holding its decoded values. Floating point values that JSON cannot represent
+// are written as the strings "NaN", "+Inf" and "-Inf".
+func WriteJSON(
+	w io.Writer,
+	header *Header,
+	tocs []*Toc,
+	metrics map[uint64]Metric,
+	values map[uint64]*Value,
+	instances map[uint64]Instance,
+	indoms map[uint64]*InstanceDomain,
+	strings map[uint64]*String,
+) error {
+	d, err := structured(header, tocs, metrics, values, instances, indoms, strings)
+	if err != nil {
+		return err
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(d)
+}
+
+// CSVHeader holds the column names written by WriteCSV.
+var CSVHeader = []string{"metric", "item", "type", "semantics", "units", "indom", "instance", "value"}
+
+// WriteCSV writes a MMV dump to the passed writer as CSV, starting with CSVHeader
+// followed by a row for every value in the file, in file order.
+// Values of singleton metrics have an empty instance.
+func WriteCSV(
+	w io.Writer,
+	header *Header,
+	tocs []*Toc,
+	metrics map[uint64]Metric,
+	values map[uint64]*Value,
+	instances map[uint64]Instance,
+	indoms map[uint64]*InstanceDomain,
+	strings map[uint64]*String,
+) error {
+	d, err := structured(header, tocs, metrics, values, instances, indoms, strings)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+
+	for _, m := range d.Metrics {
+		indom := ""
+		if m.Indom != nil {
+			indom = strconv.Itoa(int(*m.Indom))
+		}
+
+		for _, v := range m.Values {
+			instance := ""
+			if v.Instance != nil {
+				instance = *v.Instance
+			}
+
+			row := []string{
+				m.Name,
+				strconv.FormatUint(uint64(m.Item), 10),
+				m.Type,
+				m.Semantics,
+				m.Units,
+				indom,
+				instance,
+				csvValue(v.Value),
+			}
+
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case string:
+		return val
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
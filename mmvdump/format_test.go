@@ -0,0 +1,135 @@
+package mmvdump
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type writeFunc func(io.Writer, *Header, []*Toc, map[uint64]Metric, map[uint64]*Value, map[uint64]Instance, map[uint64]*InstanceDomain, map[uint64]*String) error
+
+func dumpTestdata(input string, write writeFunc, t *testing.T) []byte {
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, tocs, metrics, values, instances, indoms, strings, err := Dump(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(bytes.Buffer)
+	if err = write(b, header, tocs, metrics, values, instances, indoms, strings); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestWriteJSON(t *testing.T) {
+	var d struct {
+		Generation uint64
+		Indoms     []struct {
+			Serial    uint32
+			Instances []struct{ External string }
+		}
+		Metrics []struct {
+			Name, Type, Semantics, Units string
+			Indom                        *int32
+			Values                       []struct {
+				Instance *string
+				Value    interface{}
+			}
+		}
+	}
+
+	if err := json.Unmarshal(dumpTestdata("testdata/test2.mmv", WriteJSON, t), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Generation != 1469335238 {
+		t.Errorf("expected generation 1469335238, got %v", d.Generation)
+	}
+
+	if len(d.Indoms) != 1 || d.Indoms[0].Serial != 3094651 || len(d.Indoms[0].Instances) != 3 || d.Indoms[0].Instances[2].External != "go" {
+		t.Errorf("unexpected instance domains %+v", d.Indoms)
+	}
+
+	if len(d.Metrics) != 1 {
+		t.Fatalf("expected 1 metric, got %v", len(d.Metrics))
+	}
+
+	m := d.Metrics[0]
+	if m.Name != "language.users" || m.Type != "Uint64Type" || m.Semantics != "CounterSemantics" || m.Units != "count" || m.Indom == nil || *m.Indom != 3094651 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	if len(m.Values) != 3 || *m.Values[0].Instance != "go" || m.Values[0].Value != float64(8388608) {
+		t.Errorf("unexpected values %+v", m.Values)
+	}
+}
+
+func TestWriteJSONStrings(t *testing.T) {
+	var d struct {
+		Metrics []struct {
+			Indom  *int32
+			Values []struct {
+				Instance *string
+				Value    interface{}
+			}
+		}
+	}
+
+	if err := json.Unmarshal(dumpTestdata("testdata/test3.mmv", WriteJSON, t), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	m := d.Metrics[0]
+	if m.Indom != nil {
+		t.Errorf("expected no indom for a singleton metric, got %v", *m.Indom)
+	}
+
+	if len(m.Values) != 1 || m.Values[0].Instance != nil || m.Values[0].Value != "Robin" {
+		t.Errorf("unexpected values %+v", m.Values)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	rows, err := csv.NewReader(bytes.NewReader(dumpTestdata("testdata/test5.mmv", WriteCSV, t))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		CSVHeader,
+		{"download_speed", "150", "DoubleType", "InstantSemantics", "MiB / sec", "0", "", "0.3333333333333333"},
+		{"frequency", "372", "FloatType", "InstantSemantics", " / sec", "0", "", "0.33333334"},
+		{"time", "433", "Int32Type", "InstantSemantics", "hr", "0", "", "-6"},
+	}
+
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected\n%v\ngot\n%v", expected, rows)
+	}
+}
+
+func TestJSONFloat(t *testing.T) {
+	for _, c := range []struct {
+		in       float64
+		expected interface{}
+	}{
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{1.5, 1.5},
+	} {
+		if v := jsonFloat(c.in, c.in); v != c.expected {
+			t.Errorf("expected %v for %v, got %v", c.expected, c.in, v)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzDump(f *testing.F) {
+	files, err := filepath.Glob("testdata/*.mmv")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			h, tocs, metrics, values, instances, indoms, strings, err := DumpWithByteOrder(data, order)
+			if err != nil {
+				continue
+			}
+
+			for _, write := range []func(io.Writer, *Header, []*Toc, map[uint64]Metric, map[uint64]*Value, map[uint64]Instance, map[uint64]*InstanceDomain, map[uint64]*String) error{Write, WriteJSON, WriteCSV} {
+				_ = write(ioutil.Discard, h, tocs, metrics, values, instances, indoms, strings)
+			}
+		}
+
+		if r, err := NewReader(data); err == nil {
+			for _, m := range r.Metrics() {
+				_, _ = r.Values(m.Name)
+			}
+		}
+
+		_ = Validate(data)
+	})
+}
@@ -0,0 +1,262 @@
+package mmvdump
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// GrafanaDatasource is the type of the PCP Vector datasource of the grafana-pcp plugin,
+// which reads live metrics from pmproxy and converts counters to rates.
+//
+// see: https://grafana-pcp.readthedocs.io/en/latest/datasources/vector.html
+const GrafanaDatasource = "performancecopilot-vector-datasource"
+
+// grafanaHiddenInstances are the instances of histograms that are not graphed,
+// as they are not in the same unit as the other instances
+var grafanaHiddenInstances = []string{"variance", "standard_deviation"}
+
+// Grafana unit identifiers by space and time scale
+var (
+	grafanaSpaceUnits     = []string{"bytes", "kbytes", "mbytes", "gbytes", "tbytes", "pbytes"}
+	grafanaSpaceRateUnits = []string{"binBps", "KiBs", "MiBs", "GiBs", "TiBs", "PiBs"}
+	grafanaTimeUnits      = []string{"ns", "µs", "ms", "s", "m", "h"}
+)
+
+// secondScale is the time scale of seconds
+const secondScale = 3
+
+// GrafanaUnit returns the identifier of the Grafana unit of values of metrics with unit u
+// and semantics s, which is a unit per second for counters, as they are graphed as rates.
+// Units Grafana has no identifier for map to "short", which shows plain numbers.
+func GrafanaUnit(u Unit, s Semantics) string {
+	sd, td, cd := u.SpaceDim(), u.TimeDim(), u.CountDim()
+	rate := s == CounterSemantics
+
+	if td == -1 && u.TimeScale() == secondScale && !rate {
+		td, rate = 0, true
+	}
+
+	index := func(units []string, scale uint8) string {
+		if int(scale) < len(units) {
+			return units[scale]
+		}
+		return "short"
+	}
+
+	switch {
+	case sd == 0 && td == 1 && cd == 0 && rate:
+		// like pmval, a rate of time spent in a state in seconds is a utilization
+		if u.TimeScale() == secondScale {
+			return "percentunit"
+		}
+	case sd == 1 && td == 0 && cd == 0 && rate:
+		return index(grafanaSpaceRateUnits, u.SpaceScale())
+	case sd == 1 && td == 0 && cd == 0:
+		return index(grafanaSpaceUnits, u.SpaceScale())
+	case sd == 0 && td == 1 && cd == 0:
+		return index(grafanaTimeUnits, u.TimeScale())
+	case sd == 0 && td == 0 && (cd == 0 || cd == 1 && u.CountScale() == 0) && rate:
+		return "cps"
+	}
+
+	return "short"
+}
+
+// isHistogram checks if a metric is a histogram written by speed, which has the
+// minimum, maximum and mean of the recorded values as instances
+func isHistogram(m *MetricDesc) bool {
+	found := 0
+	for _, i := range m.Instances {
+		if i == "min" || i == "max" || i == "mean" {
+			found++
+		}
+	}
+	return found == 3
+}
+
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	Timezone      string            `json:"timezone"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type grafanaPanel struct {
+	ID          int                `json:"id"`
+	Type        string             `json:"type"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	Datasource  grafanaRef         `json:"datasource"`
+	GridPos     grafanaGridPos     `json:"gridPos"`
+	Targets     []grafanaTarget    `json:"targets"`
+	FieldConfig grafanaFieldConfig `json:"fieldConfig"`
+}
+
+type grafanaRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	Format       string `json:"format"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults  grafanaDefaults   `json:"defaults"`
+	Overrides []grafanaOverride `json:"overrides"`
+}
+
+type grafanaDefaults struct {
+	Unit string `json:"unit"`
+}
+
+type grafanaOverride struct {
+	Matcher    grafanaMatcher    `json:"matcher"`
+	Properties []grafanaProperty `json:"properties"`
+}
+
+type grafanaMatcher struct {
+	ID      string `json:"id"`
+	Options string `json:"options"`
+}
+
+type grafanaProperty struct {
+	ID    string          `json:"id"`
+	Value map[string]bool `json:"value"`
+}
+
+// newGrafanaPanel returns a panel graphing a metric named name in PCP
+func newGrafanaPanel(id int, name string, m *MetricDesc) grafanaPanel {
+	p := grafanaPanel{
+		ID:          id,
+		Type:        "timeseries",
+		Title:       name,
+		Description: m.ShortText,
+		Datasource:  grafanaRef{GrafanaDatasource, "${datasource}"},
+		Targets:     []grafanaTarget{{RefID: "A", Expr: name, Format: "time_series"}},
+		FieldConfig: grafanaFieldConfig{
+			Defaults:  grafanaDefaults{GrafanaUnit(m.Unit, m.Semantics)},
+			Overrides: []grafanaOverride{},
+		},
+	}
+
+	// instances are graphed as separate series named by the instance
+	if m.Instances != nil {
+		p.Targets[0].LegendFormat = "$instance"
+	}
+
+	if isHistogram(m) {
+		p.Title += " (min, mean, max)"
+
+		for _, i := range grafanaHiddenInstances {
+			p.FieldConfig.Overrides = append(p.FieldConfig.Overrides, grafanaOverride{
+				Matcher: grafanaMatcher{"byName", i},
+				Properties: []grafanaProperty{{
+					ID:    "custom.hideFrom",
+					Value: map[string]bool{"legend": true, "tooltip": true, "viz": true},
+				}},
+			})
+		}
+	}
+
+	return p
+}
+
+// WriteGrafanaDashboard writes the JSON of a Grafana dashboard with a panel for each of the passed
+// metrics, named in PCP by their names prefixed by prefix, as read by the grafana-pcp Vector datasource,
+// which is chosen with a variable of the dashboard.
+//
+// Counters are graphed as rates, other metrics as values, and every instance as its own series.
+// Histograms graph their minimum, mean and maximum, but not their variance and standard deviation.
+// Histograms written by speed have no percentile instances, so there are no percentiles to graph.
+// String metrics have no panels.
+func WriteGrafanaDashboard(w io.Writer, title, prefix string, metrics []*MetricDesc) error {
+	d := grafanaDashboard{
+		Title:         title,
+		Tags:          []string{"pcp", "speed"},
+		Timezone:      "browser",
+		SchemaVersion: 39,
+		Refresh:       "5s",
+		Time:          grafanaTimeRange{"now-15m", "now"},
+		Templating: grafanaTemplating{[]grafanaVariable{
+			{Name: "datasource", Label: "Datasource", Type: "datasource", Query: GrafanaDatasource},
+		}},
+		Panels: []grafanaPanel{},
+	}
+
+	// two panels in a row, a dashboard is 24 units wide
+	for _, m := range metrics {
+		if m.Type == StringType {
+			continue
+		}
+
+		n := len(d.Panels)
+		p := newGrafanaPanel(n+1, prefix+m.Name, m)
+		p.GridPos = grafanaGridPos{H: 8, W: 12, X: n % 2 * 12, Y: n / 2 * 8}
+		d.Panels = append(d.Panels, p)
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(d)
+}
+
+// WriteGrafana writes a Grafana dashboard for the metrics in the passed readers, see WriteGrafanaDashboard.
+//
+// Metric names are prefixed by "mmv." and the name of their file like pmdammv does, unless the file
+// has the NoPrefixFlag set. When more than one file has a metric with the same name, only the one
+// in the first file gets a panel.
+func WriteGrafana(w io.Writer, title string, readers ...*Reader) error {
+	var metrics []*MetricDesc
+	seen := make(map[string]bool)
+
+	for _, r := range readers {
+		prefix := "mmv."
+		if r.Header().Flag&NoPrefixFlag == 0 && r.Name() != "" {
+			prefix += r.Name() + "."
+		}
+
+		for _, m := range r.Metrics() {
+			if seen[prefix+m.Name] {
+				continue
+			}
+			seen[prefix+m.Name] = true
+
+			c := *m
+			c.Name = prefix + m.Name
+			metrics = append(metrics, &c)
+		}
+	}
+
+	return WriteGrafanaDashboard(w, title, "", metrics)
+}
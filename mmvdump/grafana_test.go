@@ -0,0 +1,126 @@
+package mmvdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestGrafanaUnit(t *testing.T) {
+	cases := []struct {
+		u    Unit
+		s    Semantics
+		unit string
+	}{
+		{0, InstantSemantics, "short"},
+		{0, CounterSemantics, "cps"},
+		{1 << 20, CounterSemantics, "cps"},
+		{1<<20 | 3<<8, CounterSemantics, "short"},
+		{1 << 28, InstantSemantics, "bytes"},
+		{1<<28 | 2<<16, InstantSemantics, "mbytes"},
+		{1<<28 | 1<<16, CounterSemantics, "KiBs"},
+		{1<<28 | 0xF<<24 | 1<<16 | 3<<12, InstantSemantics, "KiBs"},
+		{1<<24 | 2<<12, InstantSemantics, "ms"},
+		{1<<24 | 3<<12, CounterSemantics, "percentunit"},
+		{1<<24 | 2<<12, CounterSemantics, "short"},
+		{0xF<<24 | 3<<12, InstantSemantics, "cps"},
+		{0xF<<24 | 2<<12, InstantSemantics, "short"},
+		{1<<20 | 0xF<<24 | 3<<12, InstantSemantics, "cps"},
+		{2 << 28, InstantSemantics, "short"},
+	}
+
+	for _, c := range cases {
+		if unit := GrafanaUnit(c.u, c.s); unit != c.unit {
+			t.Errorf("expected %v with semantics %v to map to %q, got %q", c.u, c.s, c.unit, unit)
+		}
+	}
+}
+
+func TestWriteGrafana(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteGrafana(&buf, "test", readTestdata("test2.mmv", t), readTestdata("test3.mmv", t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var d struct {
+		Title  string
+		Panels []struct {
+			Title      string
+			Datasource struct{ Type, UID string }
+			GridPos    struct{ X, Y, W, H int }
+			Targets    []struct {
+				Expr, LegendFormat string
+			}
+			FieldConfig struct {
+				Defaults struct{ Unit string }
+			}
+		}
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	// test3.mmv only has a string metric, which has no panel
+	if d.Title != "test" || len(d.Panels) != 1 {
+		t.Fatalf("expected a dashboard with a single panel, got %+v", d)
+	}
+
+	p := d.Panels[0]
+	if p.Title != "mmv.language.users" || len(p.Targets) != 1 || p.Targets[0].Expr != "mmv.language.users" ||
+		p.Targets[0].LegendFormat != "$instance" {
+		t.Errorf("unexpected panel %+v", p)
+	}
+
+	if p.Datasource.Type != GrafanaDatasource || p.Datasource.UID != "${datasource}" {
+		t.Errorf("unexpected datasource %+v", p.Datasource)
+	}
+
+	if p.FieldConfig.Defaults.Unit != "cps" {
+		t.Errorf("expected the counter to be graphed in counts per second, got %v", p.FieldConfig.Defaults.Unit)
+	}
+}
+
+func TestWriteGrafanaDashboard(t *testing.T) {
+	metrics := []*MetricDesc{
+		{Name: "a", Type: DoubleType, Semantics: InstantSemantics},
+		{Name: "b", Type: DoubleType, Semantics: InstantSemantics, Instances: []string{"min", "max", "mean", "variance", "standard_deviation"}},
+		{Name: "c", Type: Int64Type, Semantics: CounterSemantics},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGrafanaDashboard(&buf, "test", "mmv.app.", metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	var d struct {
+		Panels []struct {
+			Title       string
+			GridPos     struct{ X, Y int }
+			FieldConfig struct {
+				Overrides []struct {
+					Matcher struct{ Options string }
+				}
+			}
+		}
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Panels) != 3 {
+		t.Fatalf("expected 3 panels, got %v", len(d.Panels))
+	}
+
+	for i, pos := range [][2]int{{0, 0}, {12, 0}, {0, 8}} {
+		if p := d.Panels[i].GridPos; p.X != pos[0] || p.Y != pos[1] {
+			t.Errorf("expected panel %v at %v, got %+v", i, pos, p)
+		}
+	}
+
+	h := d.Panels[1]
+	if h.Title != "mmv.app.b (min, mean, max)" || len(h.FieldConfig.Overrides) != 2 || h.FieldConfig.Overrides[0].Matcher.Options != "variance" {
+		t.Errorf("unexpected histogram panel %+v", h)
+	}
+}
@@ -0,0 +1,171 @@
+package mmvdump
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// TmpDir returns the PCP temporary directory, which holds the mmv directory MMV files are written to.
+//
+// It is looked up the same way PCP does, using $PCP_TMP_DIR if set, otherwise PCP_TMP_DIR from pcp.conf,
+// which is at $PCP_CONF or $PCP_DIR/etc/pcp.conf, falling back to the system temporary directory
+// when PCP is not installed.
+func TmpDir() string {
+	if d, ok := os.LookupEnv("PCP_TMP_DIR"); ok {
+		return d
+	}
+
+	root, ok := os.LookupEnv("PCP_DIR")
+	if !ok {
+		root = "/"
+	}
+
+	conf, ok := os.LookupEnv("PCP_CONF")
+	if !ok {
+		conf = filepath.Join(root, "etc", "pcp.conf")
+	}
+
+	if d, ok := readConfig(conf)["PCP_TMP_DIR"]; ok {
+		return filepath.Join(root, d)
+	}
+
+	return os.TempDir()
+}
+
+// MMVDir returns the directory MMV files are written to, the mmv directory in TmpDir.
+func MMVDir() string { return filepath.Join(TmpDir(), "mmv") }
+
+var configLine = regexp.MustCompile("^([A-Z0-9_]+)=(.*)")
+
+// readConfig reads the key value pairs from a pcp.conf file, returning nil if it cannot be read
+func readConfig(path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := configLine.FindStringSubmatch(scanner.Text()); m != nil {
+			config[m[1]] = m[2]
+		}
+	}
+
+	return config
+}
+
+// FileInfo describes a MMV file found by List
+type FileInfo struct {
+	Path string
+	Size int64
+
+	Version    int32
+	Generation uint64
+	Flag       int32
+	Process    int32
+	Cluster    int32
+
+	// Tracked is true if the file has the ProcessFlag set, so its metrics are only valid while
+	// its writer is running, and Alive is true if that process is still running, which on Linux
+	// also means it still has the file mapped
+	Tracked, Alive bool
+
+	Indoms, Instances, Metrics, Values, Strings int32
+
+	// Err is set if the file could not be read as a MMV file, in which case
+	// only Path and Size are valid
+	Err error
+}
+
+// List returns information about every file in dir, which is usually MMVDir(),
+// sorted by path. Files that are not valid MMV files are included with Err set.
+func List(dir string) ([]*FileInfo, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ans []*FileInfo
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		info := &FileInfo{Path: filepath.Join(dir, fi.Name()), Size: fi.Size()}
+		info.Err = info.read()
+		ans = append(ans, info)
+	}
+
+	sort.Slice(ans, func(i, j int) bool { return ans[i].Path < ans[j].Path })
+	return ans, nil
+}
+
+// read fills in the fields of a FileInfo from the header and TOCs of its file
+func (f *FileInfo) read() error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	h := make([]byte, HeaderLength)
+	if _, err = io.ReadFull(file, h); err != nil {
+		return errors.New("file too small to contain a valid Header")
+	}
+
+	if string(h[:3]) != "MMV" {
+		return errors.Errorf("Bad Magic: %v", string(h[:3]))
+	}
+
+	order := DetectByteOrder(h)
+	f.Version = int32(order.Uint32(h[4:]))
+	f.Generation = order.Uint64(h[8:])
+	if g2 := order.Uint64(h[16:]); g2 != f.Generation {
+		return ErrFileRewritten
+	}
+
+	tocs := int32(order.Uint32(h[24:]))
+	f.Flag = int32(order.Uint32(h[28:]))
+	f.Process = int32(order.Uint32(h[32:]))
+	f.Cluster = int32(order.Uint32(h[36:]))
+
+	if tocs < 0 || tocs > 5 {
+		return errors.Errorf("invalid TOC count %v", tocs)
+	}
+
+	t := make([]byte, uint64(tocs)*TocLength)
+	if _, err = io.ReadFull(file, t); err != nil {
+		return errors.New("Incomplete/Partially Written TOC")
+	}
+
+	for i := uint64(0); i < uint64(tocs); i++ {
+		count := int32(order.Uint32(t[i*TocLength+4:]))
+		switch TocType(order.Uint32(t[i*TocLength:])) {
+		case TocIndoms:
+			f.Indoms = count
+		case TocInstances:
+			f.Instances = count
+		case TocMetrics:
+			f.Metrics = count
+		case TocValues:
+			f.Values = count
+		case TocStrings:
+			f.Strings = count
+		}
+	}
+
+	if f.Flag&ProcessFlag != 0 {
+		f.Tracked, f.Alive = true, processAlive(int(f.Process), f.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmvdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	writeTestdata("test1.mmv", filepath.Join(dir, "a"), t)
+	writeTestdata("test2.mmv", filepath.Join(dir, "b"), t)
+
+	// a file written by this process just now
+	data, err := ioutil.ReadFile("testdata/test1.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(time.Now().Unix())
+	binary.LittleEndian.PutUint64(data[8:], now)
+	binary.LittleEndian.PutUint64(data[16:], now)
+	binary.LittleEndian.PutUint32(data[32:], uint32(os.Getpid()))
+	if err = ioutil.WriteFile(filepath.Join(dir, "c"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// writers keep their file mapped while they run
+	r, err := Open(filepath.Join(dir, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "d"), []byte("not a mmv file, but long enough for a header"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Mkdir(filepath.Join(dir, "e"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 4 {
+		t.Fatalf("expected 4 files, got %v", len(files))
+	}
+
+	a, b, c, d := files[0], files[1], files[2], files[3]
+
+	if a.Err != nil || b.Err != nil || c.Err != nil {
+		t.Fatalf("unexpected errors %v, %v and %v", a.Err, b.Err, c.Err)
+	}
+
+	if a.Path != filepath.Join(dir, "a") {
+		t.Errorf("expected the first file to be a, got %v", a.Path)
+	}
+
+	if a.Version != 1 || a.Generation != 1468770536 || a.Process != 29956 || a.Cluster != 127 || a.Flag != ProcessFlag {
+		t.Errorf("unexpected header fields %+v", a)
+	}
+
+	if a.Metrics != 1 || a.Values != 1 || a.Strings != 2 || a.Indoms != 0 || a.Instances != 0 {
+		t.Errorf("unexpected counts %+v", a)
+	}
+
+	if a.Size != 736 {
+		t.Errorf("expected a size of 736, got %v", a.Size)
+	}
+
+	if b.Indoms != 1 || b.Instances != 3 {
+		t.Errorf("unexpected counts %+v", b)
+	}
+
+	if !a.Tracked || !c.Tracked {
+		t.Errorf("expected files with the process flag to be tracked")
+	}
+
+	if !c.Alive {
+		t.Errorf("expected the file written by this process to be alive")
+	}
+
+	if d.Err == nil {
+		t.Errorf("expected an error for a file that is not a MMV file")
+	}
+}
+
+func TestListMissing(t *testing.T) {
+	if _, err := List(filepath.Join("testdata", "missing")); err == nil {
+		t.Errorf("expected an error listing a missing directory")
+	}
+}
+
+func TestMMVDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmvdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	conf := filepath.Join(dir, "pcp.conf")
+	if err = ioutil.WriteFile(conf, []byte("# comment\nPCP_TMP_DIR=/var/tmp/pcp\nPCP_VAR_DIR=/var/lib/pcp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"PCP_DIR", "PCP_CONF", "PCP_TMP_DIR"} {
+		if old, ok := os.LookupEnv(v); ok {
+			defer func(v, old string) { _ = os.Setenv(v, old) }(v, old)
+		} else {
+			defer func(v string) { _ = os.Unsetenv(v) }(v)
+		}
+		_ = os.Unsetenv(v)
+	}
+
+	_ = os.Setenv("PCP_DIR", dir)
+	if d := MMVDir(); d != filepath.Join(os.TempDir(), "mmv") {
+		t.Errorf("expected the system temporary directory without a pcp.conf, got %v", d)
+	}
+
+	_ = os.Setenv("PCP_CONF", conf)
+	if d := MMVDir(); d != filepath.Join(dir, "var", "tmp", "pcp", "mmv") {
+		t.Errorf("expected PCP_TMP_DIR from pcp.conf under PCP_DIR, got %v", d)
+	}
+
+	_ = os.Setenv("PCP_TMP_DIR", "/tmp/pcp")
+	if d := MMVDir(); d != filepath.Join("/tmp/pcp", "mmv") {
+		t.Errorf("expected PCP_TMP_DIR from the environment, got %v", d)
+	}
+}
@@ -14,19 +14,46 @@
 package mmvdump
 
 import (
+	"encoding/binary"
 	"math"
 	"sync"
-	"unsafe"
 
 	"github.com/pkg/errors"
 )
 
-func readHeader(data []byte) (*Header, error) {
-	if uint64(len(data)) < HeaderLength {
+// DetectByteOrder returns the byte order a MMV file was written in, which is the native byte order
+// of the host that wrote it. It is detected from the version in the header, which is either 1 or 2,
+// defaulting to little endian, the byte order of most hosts, if the data is not a MMV file.
+func DetectByteOrder(data []byte) binary.ByteOrder {
+	if uint64(len(data)) >= 8 {
+		if v := binary.BigEndian.Uint32(data[4:]); v == 1 || v == 2 {
+			return binary.BigEndian
+		}
+	}
+	return binary.LittleEndian
+}
+
+// inBounds checks if length bytes starting at offset are inside data,
+// without overflowing for offsets and lengths read from corrupt files
+func inBounds(data []byte, offset, length uint64) bool {
+	return offset <= uint64(len(data)) && length <= uint64(len(data))-offset
+}
+
+func readHeader(data []byte, order binary.ByteOrder) (*Header, error) {
+	if !inBounds(data, 0, HeaderLength) {
 		return nil, errors.New("file too small to contain a valid Header")
 	}
 
-	header := (*Header)(unsafe.Pointer(&data[0]))
+	header := &Header{
+		Version: int32(order.Uint32(data[4:])),
+		G1:      order.Uint64(data[8:]),
+		G2:      order.Uint64(data[16:]),
+		Toc:     int32(order.Uint32(data[24:])),
+		Flag:    int32(order.Uint32(data[28:])),
+		Process: int32(order.Uint32(data[32:])),
+		Cluster: int32(order.Uint32(data[36:])),
+	}
+	copy(header.Magic[:], data)
 
 	if m := header.Magic[:3]; string(m) != "MMV" {
 		return nil, errors.Errorf("Bad Magic: %v", string(m))
@@ -39,89 +66,167 @@ func readHeader(data []byte) (*Header, error) {
 	return header, nil
 }
 
-func readToc(data []byte, offset uint64) (*Toc, error) {
-	if uint64(len(data)) < offset+TocLength {
+func readToc(data []byte, offset uint64, order binary.ByteOrder) (*Toc, error) {
+	if !inBounds(data, offset, TocLength) {
 		return nil, errors.New("Incomplete/Partially Written TOC")
 	}
 
-	return (*Toc)(unsafe.Pointer(&data[offset])), nil
+	b := data[offset:]
+	return &Toc{TocType(order.Uint32(b)), int32(order.Uint32(b[4:])), order.Uint64(b[8:])}, nil
 }
 
-type itemReaderFunc func([]byte, uint64, int32) (interface{}, error)
+type itemReaderFunc func([]byte, uint64, int32, binary.ByteOrder) (interface{}, error)
 
-func readInstance(data []byte, offset uint64, version int32) (interface{}, error) {
+func readInstance(data []byte, offset uint64, version int32, order binary.ByteOrder) (interface{}, error) {
 	var InstanceLength = Instance1Length
 	if version == 2 {
 		InstanceLength = Instance2Length
 	}
 
-	if uint64(len(data)) < offset+InstanceLength {
+	if !inBounds(data, offset, InstanceLength) {
 		return nil, errors.New("Incomplete/Partially Written Instance")
 	}
 
+	b := data[offset:]
+	base := InstanceBase{order.Uint64(b), order.Uint32(b[8:]), int32(order.Uint32(b[12:]))}
+
 	if version == 1 {
-		return (*Instance1)(unsafe.Pointer(&data[offset])), nil
+		i := &Instance1{InstanceBase: base}
+		copy(i.External[:], b[16:])
+		return i, nil
 	}
 
-	return (*Instance2)(unsafe.Pointer(&data[offset])), nil
+	return &Instance2{base, order.Uint64(b[16:])}, nil
 }
 
-func readInstanceDomain(data []byte, offset uint64, version int32) (interface{}, error) {
-	if uint64(len(data)) < offset+InstanceDomainLength {
+func readInstanceDomain(data []byte, offset uint64, version int32, order binary.ByteOrder) (interface{}, error) {
+	if !inBounds(data, offset, InstanceDomainLength) {
 		return nil, errors.New("Incomplete/Partially Written InstanceDomain")
 	}
 
-	return (*InstanceDomain)(unsafe.Pointer(&data[offset])), nil
+	b := data[offset:]
+	return &InstanceDomain{
+		Serial:    order.Uint32(b),
+		Count:     order.Uint32(b[4:]),
+		Offset:    order.Uint64(b[8:]),
+		Shorttext: order.Uint64(b[16:]),
+		Longtext:  order.Uint64(b[24:]),
+	}, nil
+}
+
+// readMetricBase decodes the fields common to both metric versions
+func readMetricBase(b []byte, order binary.ByteOrder) MetricBase {
+	return MetricBase{
+		item:      order.Uint32(b),
+		typ:       Type(order.Uint32(b[4:])),
+		sem:       Semantics(order.Uint32(b[8:])),
+		unit:      Unit(order.Uint32(b[12:])),
+		indom:     int32(order.Uint32(b[16:])),
+		padding:   order.Uint32(b[20:]),
+		shorttext: order.Uint64(b[24:]),
+		longtext:  order.Uint64(b[32:]),
+	}
 }
 
-func readMetric(data []byte, offset uint64, version int32) (interface{}, error) {
+func readMetric(data []byte, offset uint64, version int32, order binary.ByteOrder) (interface{}, error) {
 	var MetricLength = Metric1Length
 	if version == 2 {
 		MetricLength = Metric2Length
 	}
 
-	if uint64(len(data)) < offset+MetricLength {
+	if !inBounds(data, offset, MetricLength) {
 		return nil, errors.New("Incomplete/Partially Written Metric")
 	}
 
+	b := data[offset:]
+
 	if version == 1 {
-		return (*Metric1)(unsafe.Pointer(&data[offset])), nil
+		m := &Metric1{MetricBase: readMetricBase(b[NameMax:], order)}
+		copy(m.Name[:], b)
+		return m, nil
 	}
 
-	return (*Metric2)(unsafe.Pointer(&data[offset])), nil
+	return &Metric2{order.Uint64(b), readMetricBase(b[8:], order)}, nil
 }
 
-func readValue(data []byte, offset uint64, version int32) (interface{}, error) {
-	if uint64(len(data)) < offset+ValueLength {
+func readValue(data []byte, offset uint64, version int32, order binary.ByteOrder) (interface{}, error) {
+	if !inBounds(data, offset, ValueLength) {
 		return nil, errors.New("Incomplete/Partially Written Value")
 	}
 
-	return (*Value)(unsafe.Pointer(&data[offset])), nil
+	b := data[offset:]
+	return &Value{
+		Val:      order.Uint64(b),
+		Extra:    int64(order.Uint64(b[8:])),
+		Metric:   order.Uint64(b[16:]),
+		Instance: order.Uint64(b[24:]),
+	}, nil
 }
 
-func readString(data []byte, offset uint64, version int32) (interface{}, error) {
-	if uint64(len(data)) < offset+StringLength {
+// normalizeVal moves the value of a 32 bit type to the low 32 bits of Val. Values are stored
+// in a union in the first bytes of the 8 byte slot, which is where the low bits are for
+// little endian data, while for big endian data they are the high bits.
+func normalizeVal(val uint64, t Type, order binary.ByteOrder) uint64 {
+	if order != binary.BigEndian {
+		return val
+	}
+
+	switch t {
+	case Int32Type, Uint32Type, FloatType:
+		return val >> 32
+	}
+
+	return val
+}
+
+func readString(data []byte, offset uint64, version int32, order binary.ByteOrder) (interface{}, error) {
+	if !inBounds(data, offset, StringLength) {
 		return nil, errors.New("Incomplete/Partially Written String")
 	}
 
-	return (*String)(unsafe.Pointer(&data[offset])), nil
+	s := new(String)
+	copy(s.Payload[:], data[offset:])
+	return s, nil
 }
 
-func readTocs(data []byte, count int32) ([]*Toc, error) {
+func readTocs(data []byte, count int32, order binary.ByteOrder) ([]*Toc, error) {
+	if count < 0 || count > 5 {
+		return nil, errors.Errorf("invalid TOC count %v", count)
+	}
+
 	tocs := make([]*Toc, count)
+	seen := make(map[TocType]bool, count)
 
 	for i := int32(0); i < count; i++ {
-		t, err := readToc(data, HeaderLength+uint64(i)*TocLength)
+		t, err := readToc(data, HeaderLength+uint64(i)*TocLength, order)
 		if err != nil {
 			return nil, err
 		}
+
+		if t.Type < TocIndoms || t.Type > TocStrings {
+			return nil, errors.Errorf("invalid TOC type %v", uint32(t.Type))
+		}
+
+		if seen[t.Type] {
+			return nil, errors.Errorf("duplicate TOC for %v", t.Type)
+		}
+		seen[t.Type] = true
+
 		tocs[i] = t
 	}
 
 	return tocs, nil
 }
 
-func readItems(data []byte, offset uint64, count int32, itemlength uint64, readItem itemReaderFunc, version int32) (map[uint64]interface{}, error) {
+func readItems(data []byte, offset uint64, count int32, itemlength uint64, readItem itemReaderFunc, version int32, order binary.ByteOrder) (map[uint64]interface{}, error) {
+	if count < 0 {
+		return nil, errors.Errorf("invalid item count %v", count)
+	}
+
+	if !inBounds(data, offset, 0) || uint64(count) > (uint64(len(data))-offset)/itemlength {
+		return nil, errors.Errorf("%v items of length %v at offset %v are out of bounds", count, itemlength, offset)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(int(count))
 
@@ -134,16 +239,14 @@ func readItems(data []byte, offset uint64, count int32, itemlength uint64, readI
 
 	for i := int32(0); i < count; i, offset = i+1, offset+itemlength {
 		go func(offset uint64) {
-			if err == nil {
-				item, ierr := readItem(data, offset, version)
-				if ierr == nil {
-					m.Lock()
-					items[offset] = item
-					m.Unlock()
-				} else {
-					err = ierr
-				}
+			item, ierr := readItem(data, offset, version, order)
+			m.Lock()
+			if ierr == nil {
+				items[offset] = item
+			} else {
+				err = ierr
 			}
+			m.Unlock()
 			wg.Done()
 		}(offset)
 	}
@@ -157,13 +260,13 @@ func readItems(data []byte, offset uint64, count int32, itemlength uint64, readI
 	return items, nil
 }
 
-func readInstances(data []byte, offset uint64, count int32, version int32) (map[uint64]Instance, error) {
+func readInstances(data []byte, offset uint64, count int32, version int32, order binary.ByteOrder) (map[uint64]Instance, error) {
 	InstanceLength := Instance1Length
 	if version == 2 {
 		InstanceLength = Instance2Length
 	}
 
-	i, err := readItems(data, offset, count, InstanceLength, readInstance, version)
+	i, err := readItems(data, offset, count, InstanceLength, readInstance, version, order)
 	if err != nil {
 		return nil, err
 	}
@@ -176,8 +279,8 @@ func readInstances(data []byte, offset uint64, count int32, version int32) (map[
 	return instances, nil
 }
 
-func readInstanceDomains(data []byte, offset uint64, count int32, version int32) (map[uint64]*InstanceDomain, error) {
-	i, err := readItems(data, offset, count, InstanceDomainLength, readInstanceDomain, version)
+func readInstanceDomains(data []byte, offset uint64, count int32, version int32, order binary.ByteOrder) (map[uint64]*InstanceDomain, error) {
+	i, err := readItems(data, offset, count, InstanceDomainLength, readInstanceDomain, version, order)
 	if err != nil {
 		return nil, err
 	}
@@ -190,13 +293,13 @@ func readInstanceDomains(data []byte, offset uint64, count int32, version int32)
 	return indoms, nil
 }
 
-func readMetrics(data []byte, offset uint64, count int32, version int32) (map[uint64]Metric, error) {
+func readMetrics(data []byte, offset uint64, count int32, version int32, order binary.ByteOrder) (map[uint64]Metric, error) {
 	var MetricLength = Metric1Length
 	if version == 2 {
 		MetricLength = Metric2Length
 	}
 
-	m, err := readItems(data, offset, count, MetricLength, readMetric, version)
+	m, err := readItems(data, offset, count, MetricLength, readMetric, version, order)
 	if err != nil {
 		return nil, err
 	}
@@ -209,8 +312,8 @@ func readMetrics(data []byte, offset uint64, count int32, version int32) (map[ui
 	return metrics, nil
 }
 
-func readValues(data []byte, offset uint64, count int32, version int32) (map[uint64]*Value, error) {
-	v, err := readItems(data, offset, count, ValueLength, readValue, version)
+func readValues(data []byte, offset uint64, count int32, version int32, order binary.ByteOrder) (map[uint64]*Value, error) {
+	v, err := readItems(data, offset, count, ValueLength, readValue, version, order)
 	if err != nil {
 		return nil, err
 	}
@@ -223,8 +326,8 @@ func readValues(data []byte, offset uint64, count int32, version int32) (map[uin
 	return values, nil
 }
 
-func readStrings(data []byte, offset uint64, count int32, version int32) (map[uint64]*String, error) {
-	s, err := readItems(data, offset, count, StringLength, readString, version)
+func readStrings(data []byte, offset uint64, count int32, version int32, order binary.ByteOrder) (map[uint64]*String, error) {
+	s, err := readItems(data, offset, count, StringLength, readString, version, order)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +340,7 @@ func readStrings(data []byte, offset uint64, count int32, version int32) (map[ui
 	return strings, nil
 }
 
-func readComponents(data []byte, tocs []*Toc, version int32) (
+func readComponents(data []byte, tocs []*Toc, version int32, order binary.ByteOrder) (
 	metrics map[uint64]Metric,
 	values map[uint64]*Value,
 	instances map[uint64]Instance,
@@ -252,27 +355,27 @@ func readComponents(data []byte, tocs []*Toc, version int32) (
 		switch toc.Type {
 		case TocInstances:
 			go func(offset uint64, count int32) {
-				instances, ierr = readInstances(data, offset, count, version)
+				instances, ierr = readInstances(data, offset, count, version, order)
 				wg.Done()
 			}(toc.Offset, toc.Count)
 		case TocIndoms:
 			go func(offset uint64, count int32) {
-				indoms, inerr = readInstanceDomains(data, offset, count, version)
+				indoms, inerr = readInstanceDomains(data, offset, count, version, order)
 				wg.Done()
 			}(toc.Offset, toc.Count)
 		case TocMetrics:
 			go func(offset uint64, count int32) {
-				metrics, merr = readMetrics(data, offset, count, version)
+				metrics, merr = readMetrics(data, offset, count, version, order)
 				wg.Done()
 			}(toc.Offset, toc.Count)
 		case TocValues:
 			go func(offset uint64, count int32) {
-				values, verr = readValues(data, offset, count, version)
+				values, verr = readValues(data, offset, count, version, order)
 				wg.Done()
 			}(toc.Offset, toc.Count)
 		case TocStrings:
 			go func(offset uint64, count int32) {
-				strings, serr = readStrings(data, offset, count, version)
+				strings, serr = readStrings(data, offset, count, version, order)
 				wg.Done()
 			}(toc.Offset, toc.Count)
 		}
@@ -283,7 +386,10 @@ func readComponents(data []byte, tocs []*Toc, version int32) (
 	return
 }
 
-// Dump creates a data dump from the passed data
+// Dump creates a data dump from the passed data, in the byte order detected by DetectByteOrder.
+//
+// Values of 32 bit types are returned in the low 32 bits of Value.Val regardless of the byte order,
+// so FixedVal can be used on all values.
 func Dump(data []byte) (
 	h *Header,
 	tocs []*Toc,
@@ -294,19 +400,34 @@ func Dump(data []byte) (
 	strings map[uint64]*String,
 	err error,
 ) {
-	h, err = readHeader(data)
+	return DumpWithByteOrder(data, DetectByteOrder(data))
+}
+
+// DumpWithByteOrder creates a data dump from data written in the passed byte order,
+// for inspecting files written by hosts with a different byte order.
+func DumpWithByteOrder(data []byte, order binary.ByteOrder) (
+	h *Header,
+	tocs []*Toc,
+	metrics map[uint64]Metric,
+	values map[uint64]*Value,
+	instances map[uint64]Instance,
+	indoms map[uint64]*InstanceDomain,
+	strings map[uint64]*String,
+	err error,
+) {
+	h, err = readHeader(data, order)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	tocs, err = readTocs(data, h.Toc)
+	tocs, err = readTocs(data, h.Toc, order)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	var ierr, inerr, merr, verr, serr error
 
-	metrics, values, instances, indoms, strings, ierr, inerr, merr, verr, serr = readComponents(data, tocs, h.Version)
+	metrics, values, instances, indoms, strings, ierr, inerr, merr, verr, serr = readComponents(data, tocs, h.Version, order)
 
 	switch {
 	case ierr != nil:
@@ -321,6 +442,12 @@ func Dump(data []byte) (
 		return nil, nil, nil, nil, nil, nil, nil, serr
 	}
 
+	for _, v := range values {
+		if m, ok := metrics[v.Metric]; ok {
+			v.Val = normalizeVal(v.Val, m.Typ(), order)
+		}
+	}
+
 	return
 }
 
@@ -2,7 +2,10 @@ package mmvdump
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"testing"
 )
 
@@ -96,3 +99,148 @@ got
 		}
 	}
 }
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// bigEndian converts little endian MMV data to the data a big endian host would have written
+func bigEndian(t *testing.T, data []byte) []byte {
+	h, tocs, metrics, values, instances, indoms, _, err := Dump(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	be := append([]byte{}, data...)
+	swap32 := func(off uint64) { reverse(be[off : off+4]) }
+	swap64 := func(off uint64) { reverse(be[off : off+8]) }
+
+	swap32(4)
+	swap64(8)
+	swap64(16)
+	for _, off := range []uint64{24, 28, 32, 36} {
+		swap32(off)
+	}
+
+	for i := range tocs {
+		off := HeaderLength + uint64(i)*TocLength
+		swap32(off)
+		swap32(off + 4)
+		swap64(off + 8)
+	}
+
+	for off := range indoms {
+		swap32(off)
+		swap32(off + 4)
+		swap64(off + 8)
+		swap64(off + 16)
+		swap64(off + 24)
+	}
+
+	for off := range instances {
+		swap64(off)
+		swap32(off + 8)
+		swap32(off + 12)
+		if h.Version == 2 {
+			swap64(off + 16)
+		}
+	}
+
+	for off := range metrics {
+		base := off + NameMax
+		if h.Version == 2 {
+			swap64(off)
+			base = off + 8
+		}
+		for i := uint64(0); i < 6; i++ {
+			swap32(base + 4*i)
+		}
+		swap64(base + 24)
+		swap64(base + 32)
+	}
+
+	for off, v := range values {
+		switch metrics[v.Metric].Typ() {
+		case Int32Type, Uint32Type, FloatType:
+			swap32(off)
+		default:
+			swap64(off)
+		}
+		swap64(off + 8)
+		swap64(off + 16)
+		swap64(off + 24)
+	}
+
+	return be
+}
+
+func TestBigEndianInputs(t *testing.T) {
+	for i := 1; i <= 5; i++ {
+		data, err := ioutil.ReadFile(fmt.Sprintf("testdata/test%v.mmv", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		le, be := data, bigEndian(t, data)
+
+		if o := DetectByteOrder(le); o != binary.LittleEndian {
+			t.Errorf("expected test%v.mmv to be detected as little endian, got %v", i, o)
+		}
+
+		if o := DetectByteOrder(be); o != binary.BigEndian {
+			t.Errorf("expected test%v.mmv converted to big endian to be detected as big endian, got %v", i, o)
+		}
+
+		header, tocs, metrics, values, instances, indoms, strings, err := DumpWithByteOrder(be, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b = new(bytes.Buffer)
+		if err = Write(b, header, tocs, metrics, values, instances, indoms, strings); err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := ioutil.ReadFile(fmt.Sprintf("testdata/output%v.golden", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(expected, b.Bytes()) {
+			t.Errorf("big endian test%v.mmv: expected\n%s\ngot\n%s", i, expected, b.Bytes())
+		}
+	}
+}
+
+func TestDumpCorrupt(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/test1.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		corrupt func([]byte)
+	}{
+		{"negative TOC count", func(d []byte) { binary.LittleEndian.PutUint32(d[24:], 0xffffffff) }},
+		{"too many TOCs", func(d []byte) { binary.LittleEndian.PutUint32(d[24:], 1<<30) }},
+		{"unknown TOC type", func(d []byte) { binary.LittleEndian.PutUint32(d[HeaderLength:], 42) }},
+		{"duplicate TOC", func(d []byte) { copy(d[HeaderLength+TocLength:], d[HeaderLength:HeaderLength+TocLength]) }},
+		{"negative item count", func(d []byte) { binary.LittleEndian.PutUint32(d[HeaderLength+4:], 0xffffffff) }},
+		{"items past the end", func(d []byte) { binary.LittleEndian.PutUint32(d[HeaderLength+4:], 1<<30) }},
+		{"overflowing offset", func(d []byte) { binary.LittleEndian.PutUint64(d[HeaderLength+8:], math.MaxUint64-8) }},
+	} {
+		d := append([]byte{}, data...)
+		c.corrupt(d)
+
+		if _, _, _, _, _, _, _, err := Dump(d); err == nil {
+			t.Errorf("%v: expected an error", c.name)
+		}
+	}
+
+	if _, _, _, _, _, _, _, err := Dump(data[:len(data)-1]); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+}
@@ -26,6 +26,18 @@ type Header struct {
 	Process, Cluster int32
 }
 
+// Values for the flags in a Header
+const (
+	// NoPrefixFlag means metric names are not prefixed by the file name
+	NoPrefixFlag = 1 << iota
+
+	// ProcessFlag means the metrics are only valid while the process that wrote them is running
+	ProcessFlag
+
+	// SentinelFlag means the file contains a sentinel metric
+	SentinelFlag
+)
+
 // TocType is an enumerated type with different types as values
 type TocType int32
 
@@ -372,7 +384,7 @@ type Semantics int32
 
 // Values for Semantics
 const (
-	NoSemantics Semantics = 0
+	NoSemantics Semantics = iota
 	CounterSemantics
 	_
 	InstantSemantics
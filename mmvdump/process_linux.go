@@ -0,0 +1,76 @@
+package mmvdump
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processAlive checks if the process with the passed pid is running, and that it is the
+// process writing the file at path rather than a later one that reused its pid.
+//
+// Writers keep their file mapped while they run, so a process that does not have the file
+// mapped is not its writer. If the mappings of the process cannot be read, like for processes
+// of other users, it is assumed to be the writer.
+func processAlive(pid int, path string) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	mapped, ok := processMaps(pid, path)
+	return mapped || !ok
+}
+
+// processMaps checks if the process with the passed pid has the file at path mapped,
+// reporting false for ok if that cannot be determined
+func processMaps(pid int, path string) (mapped, ok bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return false, false
+	}
+
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/maps")
+	if err != nil {
+		return false, false
+	}
+	defer func() { _ = f.Close() }()
+
+	major := uint64(st.Dev>>8)&0xfff | uint64(st.Dev>>32)&^0xfff
+	minor := uint64(st.Dev)&0xff | uint64(st.Dev>>12)&^0xff
+
+	// every line is "address perms offset major:minor inode path"
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		if ino, err := strconv.ParseUint(fields[4], 10, 64); err != nil || ino != uint64(st.Ino) {
+			continue
+		}
+
+		dev := strings.SplitN(fields[3], ":", 2)
+		if len(dev) != 2 {
+			continue
+		}
+
+		ma, err1 := strconv.ParseUint(dev[0], 16, 64)
+		mi, err2 := strconv.ParseUint(dev[1], 16, 64)
+		if err1 == nil && err2 == nil && ma == major && mi == minor {
+			return true, true
+		}
+	}
+
+	if s.Err() != nil {
+		return false, false
+	}
+
+	return false, true
+}
@@ -0,0 +1,49 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessAlive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmvdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// a file claiming to be written by this process, like one written by an earlier
+	// process with the same pid, or one written before the clock stepped
+	data, err := ioutil.ReadFile("testdata/test1.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[32:], uint32(os.Getpid()))
+
+	loc := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(loc, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if processAlive(os.Getpid(), loc) {
+		t.Error("expected a process that does not have the file mapped not to be its writer")
+	}
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	// the generation of test1.mmv is long before this process started
+	if !processAlive(os.Getpid(), loc) {
+		t.Error("expected a process that has the file mapped to be its writer")
+	}
+
+	if !processAlive(os.Getpid(), filepath.Join(dir, "missing")) {
+		t.Error("expected a process to be assumed to be the writer if that cannot be determined")
+	}
+}
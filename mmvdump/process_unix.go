@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package mmvdump
+
+import "syscall"
+
+// processAlive checks if the process with the passed pid is running
+func processAlive(pid int, path string) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,19 @@
+package mmvdump
+
+import "os"
+
+// processAlive checks if the process with the passed pid is running
+func processAlive(pid int, path string) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// on windows, finding a process opens a handle to it, which fails if it does not exist
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	_ = p.Release()
+	return true
+}
@@ -0,0 +1,244 @@
+package mmvdump
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PrometheusContentType is the content type of the output of WritePrometheus
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusInstanceLabel is the label instance names are exposed under
+const PrometheusInstanceLabel = "instname"
+
+// PrometheusIndomLabel is the label the serial of the instance domain of an instance is exposed under,
+// as MMV files do not keep the names of instance domains
+const PrometheusIndomLabel = "indom"
+
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// PrometheusName converts a PCP metric or instance domain name to a valid Prometheus
+// metric or label name by replacing every invalid character with an underscore
+func PrometheusName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9')
+
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// PrometheusUnit returns the Prometheus base unit suffix for a unit, along with the
+// factor values need to be multiplied with to be represented in the base unit.
+//
+// Units that have no sensible representation in Prometheus base units,
+// like squared units, are returned without a suffix and unscaled.
+func PrometheusUnit(u Unit) (string, float64) {
+	sd, td, cd := u.SpaceDim(), u.TimeDim(), u.CountDim()
+
+	for _, d := range []int8{sd, td, cd} {
+		if d < -1 || d > 1 {
+			return "", 1
+		}
+	}
+
+	if td != 0 && int(u.TimeScale()) >= len(secondsPerTimeScale) {
+		return "", 1
+	}
+
+	var num, den []string
+	factor := 1.0
+
+	if sd != 0 {
+		f := math.Pow(1024, float64(u.SpaceScale()))
+		if sd > 0 {
+			num, factor = append(num, "bytes"), factor*f
+		} else {
+			den, factor = append(den, "byte"), factor/f
+		}
+	}
+
+	if td != 0 {
+		f := secondsPerTimeScale[u.TimeScale()]
+		if td > 0 {
+			num, factor = append(num, "seconds"), factor*f
+		} else {
+			den, factor = append(den, "second"), factor/f
+		}
+	}
+
+	if cd != 0 {
+		// the count scale is a signed power of 10
+		f := math.Pow(10, float64(int8(u.CountScale()<<4)>>4))
+		if cd > 0 {
+			factor *= f
+		} else {
+			factor /= f
+		}
+	}
+
+	unit := strings.Join(num, "_")
+	if len(den) > 0 {
+		if unit != "" {
+			unit += "_"
+		}
+		unit += "per_" + strings.Join(den, "_per_")
+	}
+
+	return unit, factor
+}
+
+// PrometheusValue formats a value multiplied by factor, integer values are written exactly
+// unless they need scaling
+func PrometheusValue(val interface{}, factor float64) string {
+	if factor == 1 {
+		switch v := val.(type) {
+		case int32:
+			return strconv.FormatInt(int64(v), 10)
+		case int64:
+			return strconv.FormatInt(v, 10)
+		case uint32:
+			return strconv.FormatUint(uint64(v), 10)
+		case uint64:
+			return strconv.FormatUint(v, 10)
+		}
+	}
+
+	f, _ := float(val)
+	return PrometheusFloat(f * factor)
+}
+
+// PrometheusFloat formats a float the way the Prometheus text formats write special values
+func PrometheusFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WritePrometheus writes a snapshot of the metrics in the passed readers to w
+// in the Prometheus 0.0.4 text exposition format.
+//
+// Metric names are prefixed by the name of their file like pmcd does, unless the file
+// has the NoPrefixFlag set. Counters map to Prometheus counters and everything else to gauges,
+// instances map to the PrometheusInstanceLabel label along with the serial of their instance domain
+// in the PrometheusIndomLabel label, string metrics map to info metrics
+// and values are scaled to base units (seconds and bytes), with the unit appended to the metric name.
+//
+// When more than one file has a metric with the same name, only the one in the first file is written.
+// Files that cannot be read, like ones removed by their writer, are skipped and the others are
+// still written, returning the error reading the first such file.
+func WritePrometheus(w io.Writer, readers ...*Reader) error {
+	bw := bufio.NewWriter(w)
+	seen := make(map[string]bool)
+
+	var firstErr error
+
+	for _, r := range readers {
+		s, err := r.Snapshot()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "cannot read %v", r.path)
+			}
+			continue
+		}
+
+		prefix := ""
+		if s.Header.Flag&NoPrefixFlag == 0 && r.Name() != "" {
+			prefix = r.Name() + "."
+		}
+
+		for _, m := range r.Metrics() {
+			name := PrometheusName(prefix + m.Name)
+
+			unit, factor := "", 1.0
+			if m.Type != StringType {
+				unit, factor = PrometheusUnit(m.Unit)
+			}
+
+			if m.Semantics == CounterSemantics {
+				name = strings.TrimSuffix(name, "_total")
+			}
+
+			if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+				name += "_" + unit
+			}
+
+			typ := "gauge"
+			switch {
+			case m.Type == StringType:
+				name += "_info"
+			case m.Semantics == CounterSemantics:
+				name, typ = name+"_total", "counter"
+			}
+
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			if m.ShortText != "" {
+				_, _ = bw.WriteString("# HELP " + name + " " + prometheusEscaper.Replace(m.ShortText) + "\n")
+			}
+			_, _ = bw.WriteString("# TYPE " + name + " " + typ + "\n")
+
+			vals := s.Values[m.Name]
+			instances := make([]string, 0, len(vals))
+			for ins := range vals {
+				instances = append(instances, ins)
+			}
+			sort.Strings(instances)
+
+			for _, ins := range instances {
+				var labels []string
+				if ins != "" {
+					labels = append(labels, PrometheusIndomLabel, strconv.Itoa(int(m.Indom)), PrometheusInstanceLabel, ins)
+				}
+
+				value := "1"
+				if m.Type == StringType {
+					labels = append(labels, "value", vals[ins].(string))
+				} else {
+					value = PrometheusValue(vals[ins], factor)
+				}
+
+				_, _ = bw.WriteString(name)
+				if len(labels) > 0 {
+					_ = bw.WriteByte('{')
+					for i := 0; i < len(labels); i += 2 {
+						if i > 0 {
+							_ = bw.WriteByte(',')
+						}
+						_, _ = bw.WriteString(labels[i] + `="` + prometheusLabelEscaper.Replace(labels[i+1]) + `"`)
+					}
+					_ = bw.WriteByte('}')
+				}
+				_, _ = bw.WriteString(" " + value + "\n")
+			}
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return firstErr
+}
@@ -0,0 +1,126 @@
+package mmvdump
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestPrometheusUnit(t *testing.T) {
+	cases := []struct {
+		u      Unit
+		unit   string
+		factor float64
+	}{
+		{0, "", 1},
+		{1 << 28, "bytes", 1},
+		{1<<28 | 2<<16, "bytes", 1024 * 1024},
+		{1<<24 | 1<<12, "seconds", 1e-6},
+		{1<<24 | 3<<12, "seconds", 1},
+		{1<<24 | 5<<12, "seconds", 3600},
+		{1<<20 | 3<<8, "", 1000},
+		{1<<28 | 0xF<<24 | 1<<16 | 3<<12, "bytes_per_second", 1024},
+		{2 << 28, "", 1},
+	}
+
+	for _, c := range cases {
+		unit, factor := PrometheusUnit(c.u)
+		if unit != c.unit || factor != c.factor {
+			t.Errorf("expected %v to map to %q and %v, got %q and %v", c.u, c.unit, c.factor, unit, factor)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{
+		{"test1.mmv", `# HELP simple_counter_total A Simple Metric
+# TYPE simple_counter_total counter
+simple_counter_total 42
+`},
+		{"test2.mmv", `# TYPE language_users_total counter
+language_users_total{indom="3094651",instname="go"} 8388608
+language_users_total{indom="3094651",instname="javascript"} 330
+language_users_total{indom="3094651",instname="php"} 33
+`},
+	}
+
+	for _, c := range cases {
+		r := readTestdata(c.input, t)
+
+		var buf bytes.Buffer
+		if err := WritePrometheus(&buf, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != c.expected {
+			t.Errorf("%v: expected\n%v\ngot\n%v", c.input, c.expected, buf.String())
+		}
+	}
+}
+
+func TestWritePrometheusPrefix(t *testing.T) {
+	loc, cleanup := copyTestdata("test1.mmv", t)
+	defer cleanup()
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	var buf bytes.Buffer
+	if err = WritePrometheus(&buf, r, r); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_mmv_simple_counter_total A Simple Metric
+# TYPE test_mmv_simple_counter_total counter
+test_mmv_simple_counter_total 42
+`
+
+	if buf.String() != expected {
+		t.Errorf("expected metrics prefixed by the file name once, got\n%v", buf.String())
+	}
+}
+
+func TestWritePrometheusSkipsUnreadable(t *testing.T) {
+	loc, cleanup := copyTestdata("test1.mmv", t)
+	defer cleanup()
+
+	gone, cleanupGone := copyTestdata("test1.mmv", t)
+	defer cleanupGone()
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	g, err := Open(gone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = g.Close() }()
+
+	if err = os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	retries := SnapshotRetries
+	SnapshotRetries = 1
+	defer func() { SnapshotRetries = retries }()
+
+	var buf bytes.Buffer
+	if err = WritePrometheus(&buf, g, r); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("expected a not exist error for the removed file, got %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "test_mmv_simple_counter_total 42\n") {
+		t.Errorf("expected the readable file to be written, got\n%v", buf.String())
+	}
+}
@@ -0,0 +1,78 @@
+package mmvdump
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// float converts a value read from a MMV file to a float64
+func float(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case int32:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// secondsPerTimeScale holds the length of each time scale in seconds
+var secondsPerTimeScale = []float64{1e-9, 1e-6, 1e-3, 1, 60, 3600}
+
+// IsTimeUnit checks if a unit measures time and nothing else, like the unit of a counter
+// of time spent in some state.
+func (u Unit) IsTimeUnit() bool {
+	return u.TimeDim() == 1 && u.SpaceDim() == 0 && u.CountDim() == 0 && int(u.TimeScale()) < len(secondsPerTimeScale)
+}
+
+// RateUnit returns a description of the unit of the rates computed by Rate
+// for a metric of unit u.
+func RateUnit(u Unit) string {
+	if u.IsTimeUnit() {
+		return "time utilization"
+	}
+	return u.String() + " / sec"
+}
+
+// Rate computes the per second rate of change of a counter from two of its values
+// read elapsed apart.
+//
+// Like pmval, counters of time are converted to seconds first, making their rate a time utilization,
+// the fraction of the interval spent doing whatever the counter measures. All other counters keep
+// their unit, see RateUnit. An error is returned if the counter went backwards, as happens when
+// its writer is restarted.
+func Rate(u Unit, prev, cur interface{}, elapsed time.Duration) (float64, error) {
+	p, ok := float(prev)
+	if !ok {
+		return 0, errors.Errorf("cannot compute the rate of %T values", prev)
+	}
+
+	c, ok := float(cur)
+	if !ok {
+		return 0, errors.Errorf("cannot compute the rate of %T values", cur)
+	}
+
+	if elapsed <= 0 {
+		return 0, errors.New("cannot compute a rate over a non positive interval")
+	}
+
+	if c < p {
+		return 0, errors.New("counter went backwards")
+	}
+
+	delta := c - p
+	if u.IsTimeUnit() {
+		delta *= secondsPerTimeScale[u.TimeScale()]
+	}
+
+	return delta / elapsed.Seconds(), nil
+}
@@ -0,0 +1,50 @@
+package mmvdump
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	for _, c := range []struct {
+		unit      Unit
+		prev, cur interface{}
+		elapsed   time.Duration
+		rate      float64
+		rateUnit  string
+	}{
+		{OneUnit, int64(10), int64(30), 2 * time.Second, 10, "count / sec"},
+		{OneUnit, uint32(0), uint32(5), 500 * time.Millisecond, 10, "count / sec"},
+		{ByteUnit, float64(1), float64(2), time.Second, 1, "B / sec"},
+		{MillisecondUnit, uint64(0), uint64(500), time.Second, 0.5, "time utilization"},
+		{NanosecondUnit, int64(0), int64(2e9), 4 * time.Second, 0.5, "time utilization"},
+	} {
+		rate, err := Rate(c.unit, c.prev, c.cur, c.elapsed)
+		if err != nil {
+			t.Errorf("cannot compute rate from %v to %v, error: %v", c.prev, c.cur, err)
+			continue
+		}
+
+		if rate != c.rate {
+			t.Errorf("expected a rate of %v from %v to %v in %v, got %v", c.rate, c.prev, c.cur, c.elapsed, rate)
+		}
+
+		if u := RateUnit(c.unit); u != c.rateUnit {
+			t.Errorf("expected a rate unit of %v for %v, got %v", c.rateUnit, c.unit, u)
+		}
+	}
+}
+
+func TestRateErrors(t *testing.T) {
+	if _, err := Rate(OneUnit, int64(5), int64(3), time.Second); err == nil {
+		t.Error("expected a counter going backwards to fail")
+	}
+
+	if _, err := Rate(OneUnit, "a", "b", time.Second); err == nil {
+		t.Error("expected the rate of strings to fail")
+	}
+
+	if _, err := Rate(OneUnit, int64(1), int64(2), 0); err == nil {
+		t.Error("expected the rate over a zero interval to fail")
+	}
+}
@@ -0,0 +1,397 @@
+package mmvdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	mmap "github.com/edsrzf/mmap-go"
+	"github.com/pkg/errors"
+)
+
+// MetricDesc describes a metric in an MMV file with all offsets resolved
+type MetricDesc struct {
+	Name      string
+	Item      uint32
+	Type      Type
+	Semantics Semantics
+	Unit      Unit
+	Indom     int32 // the serial of the instance domain of the metric, or NoIndom
+
+	// Instances are the names of the instances the metric has values for,
+	// in file order, or nil for singleton metrics
+	Instances []string
+
+	ShortText, LongText string
+
+	values map[string]uint64 // offsets of values by instance, singletons use the empty string
+}
+
+// InstanceDomainDesc describes an instance domain in an MMV file with all offsets resolved
+type InstanceDomainDesc struct {
+	Serial              uint32
+	Instances           []string
+	ShortText, LongText string
+}
+
+// Reader reads metrics and their values by name from an MMV file.
+//
+// Metadata is resolved once when the Reader is created, while values are read
+// from the underlying data every time they are requested, so for a memory mapped
+// file they always reflect what the writer last wrote. Value and Values do not
+// guard against the writer restarting in the middle of a read, use Snapshot
+// for that.
+type Reader struct {
+	path   string
+	data   []byte
+	mapped mmap.MMap
+	handle *os.File
+
+	order   binary.ByteOrder
+	header  Header
+	metrics map[string]*MetricDesc
+	names   []string
+	indoms  []*InstanceDomainDesc
+
+	reloaded bool // set when metadata is loaded, cleared by Snapshot
+}
+
+// Open memory maps the MMV file at path read-only and creates a Reader for it.
+// The Reader has to be closed to release the mapping.
+func Open(path string) (*Reader, error) {
+	r := &Reader{path: path}
+
+	var err error
+	for i := 0; i < SnapshotRetries; i++ {
+		if i > 0 {
+			time.Sleep(SnapshotRetryInterval)
+		}
+
+		if err = r.remap(); err != ErrFileRewritten {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// NewReader creates a Reader for MMV data that is already in memory.
+func NewReader(data []byte) (*Reader, error) {
+	r := &Reader{data: data}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close releases the memory mapping of a Reader created by Open,
+// it does nothing for a Reader created by NewReader.
+func (r *Reader) Close() error {
+	if r.mapped == nil {
+		return nil
+	}
+
+	return r.unmap()
+}
+
+func (r *Reader) unmap() error {
+	if err := r.mapped.Unmap(); err != nil {
+		return err
+	}
+
+	h := r.handle
+	r.mapped, r.handle, r.data = nil, nil, nil
+
+	return h.Close()
+}
+
+// remap maps the file currently at the path of the Reader, replacing any
+// existing mapping, and loads its metadata
+func (r *Reader) remap() error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// a writer truncates a new file to its full size before writing anything,
+	// so an empty file is one that is still being created
+	if fi.Size() == 0 {
+		_ = f.Close()
+		return ErrFileRewritten
+	}
+
+	if uint64(fi.Size()) < HeaderLength {
+		_ = f.Close()
+		return errors.New("file too small to contain a valid Header")
+	}
+
+	m, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	old := r.mapped
+	data := r.data
+	r.data = m
+
+	if err := r.load(); err != nil {
+		r.data = data
+		_ = m.Unmap()
+		_ = f.Close()
+		return err
+	}
+
+	if old != nil {
+		_ = old.Unmap()
+		_ = r.handle.Close()
+	}
+
+	r.mapped, r.handle = m, f
+	return nil
+}
+
+func (r *Reader) load() error {
+	if inProgress(r.data) {
+		return ErrFileRewritten
+	}
+
+	order := DetectByteOrder(r.data)
+	h, _, metrics, values, instances, indoms, strs, err := DumpWithByteOrder(r.data, order)
+	if err != nil {
+		return err
+	}
+
+	str := func(off uint64) (string, error) {
+		if off == 0 {
+			return "", nil
+		}
+
+		s, ok := strs[off]
+		if !ok {
+			return "", errors.Errorf("no string at offset %v", off)
+		}
+
+		return cstring(s.Payload[:]), nil
+	}
+
+	instanceNames := make(map[uint64]string, len(instances))
+	for off, i := range instances {
+		if h.Version == 1 {
+			instanceNames[off] = cstring(i.(*Instance1).External[:])
+		} else if instanceNames[off], err = str(i.(*Instance2).External); err != nil {
+			return err
+		}
+	}
+
+	indomDescs := make([]*InstanceDomainDesc, 0, len(indoms))
+	for _, indom := range indoms {
+		d := &InstanceDomainDesc{Serial: indom.Serial}
+
+		if d.ShortText, err = str(indom.Shorttext); err != nil {
+			return err
+		}
+
+		if d.LongText, err = str(indom.Longtext); err != nil {
+			return err
+		}
+
+		length := Instance1Length
+		if h.Version == 2 {
+			length = Instance2Length
+		}
+
+		for i, off := uint32(0), indom.Offset; i < indom.Count; i, off = i+1, off+length {
+			name, ok := instanceNames[off]
+			if !ok {
+				return errors.Errorf("no instance at offset %v for indom %v", off, indom.Serial)
+			}
+			d.Instances = append(d.Instances, name)
+		}
+
+		indomDescs = append(indomDescs, d)
+	}
+	sort.Slice(indomDescs, func(i, j int) bool { return indomDescs[i].Serial < indomDescs[j].Serial })
+
+	byOffset := make(map[uint64]*MetricDesc, len(metrics))
+	metricDescs := make(map[string]*MetricDesc, len(metrics))
+	for off, m := range metrics {
+		d := &MetricDesc{
+			Item:      m.Item(),
+			Type:      m.Typ(),
+			Semantics: m.Sem(),
+			Unit:      m.Unit(),
+			Indom:     m.Indom(),
+			values:    make(map[string]uint64),
+		}
+
+		if h.Version == 1 {
+			d.Name = cstring(m.(*Metric1).Name[:])
+		} else if d.Name, err = str(m.(*Metric2).Name); err != nil {
+			return err
+		}
+
+		if d.ShortText, err = str(m.ShortText()); err != nil {
+			return err
+		}
+
+		if d.LongText, err = str(m.LongText()); err != nil {
+			return err
+		}
+
+		byOffset[off], metricDescs[d.Name] = d, d
+	}
+
+	offsets := make([]uint64, 0, len(values))
+	for off := range values {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	for _, off := range offsets {
+		v := values[off]
+
+		d, ok := byOffset[v.Metric]
+		if !ok {
+			return errors.Errorf("value at offset %v refers to a missing metric", off)
+		}
+
+		if d.Indom == NoIndom || v.Instance == 0 {
+			d.values[""] = off
+			continue
+		}
+
+		name, ok := instanceNames[v.Instance]
+		if !ok {
+			return errors.Errorf("value at offset %v refers to a missing instance", off)
+		}
+
+		d.Instances = append(d.Instances, name)
+		d.values[name] = off
+	}
+
+	names := make([]string, 0, len(metricDescs))
+	for name := range metricDescs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r.order, r.header, r.metrics, r.names, r.indoms = order, *h, metricDescs, names, indomDescs
+	r.reloaded = true
+
+	return nil
+}
+
+// Name returns the name of the file of a Reader created by Open, which is also
+// the prefix PCP adds to its metric names unless it has the NoPrefixFlag set.
+// It returns an empty string for a Reader created by NewReader.
+func (r *Reader) Name() string {
+	if r.path == "" {
+		return ""
+	}
+	return filepath.Base(r.path)
+}
+
+// Header returns a copy of the header of the file.
+func (r *Reader) Header() Header { return r.header }
+
+// Metrics returns descriptions of all metrics in the file, sorted by name.
+func (r *Reader) Metrics() []*MetricDesc {
+	ans := make([]*MetricDesc, len(r.names))
+	for i, name := range r.names {
+		ans[i] = r.metrics[name]
+	}
+	return ans
+}
+
+// Metric returns the description of the metric with the passed name.
+func (r *Reader) Metric(name string) (*MetricDesc, bool) {
+	m, ok := r.metrics[name]
+	return m, ok
+}
+
+// InstanceDomains returns descriptions of all instance domains in the file, sorted by serial.
+func (r *Reader) InstanceDomains() []*InstanceDomainDesc { return r.indoms }
+
+// Value reads the current value of a metric for the passed instance,
+// use an empty instance for singleton metrics.
+//
+// The value is an int32, uint32, int64, uint64, float32, float64 or string
+// depending on the type of the metric.
+func (r *Reader) Value(metric, instance string) (interface{}, error) {
+	m, ok := r.metrics[metric]
+	if !ok {
+		return nil, errors.Errorf("no metric named %v", metric)
+	}
+
+	off, ok := m.values[instance]
+	if !ok {
+		return nil, errors.Errorf("metric %v has no value for instance %q", metric, instance)
+	}
+
+	return r.value(m, off)
+}
+
+// Values reads the current values of a metric for all its instances,
+// the value of a singleton metric is stored under the empty string.
+func (r *Reader) Values(metric string) (map[string]interface{}, error) {
+	m, ok := r.metrics[metric]
+	if !ok {
+		return nil, errors.Errorf("no metric named %v", metric)
+	}
+
+	ans := make(map[string]interface{}, len(m.values))
+	for ins, off := range m.values {
+		v, err := r.value(m, off)
+		if err != nil {
+			return nil, err
+		}
+		ans[ins] = v
+	}
+
+	return ans, nil
+}
+
+func (r *Reader) value(m *MetricDesc, off uint64) (interface{}, error) {
+	if r.data == nil {
+		return nil, errors.New("reading from a closed Reader")
+	}
+
+	iv, err := readValue(r.data, off, r.header.Version, r.order)
+	if err != nil {
+		return nil, err
+	}
+	v := iv.(*Value)
+
+	if m.Type != StringType {
+		return FixedVal(normalizeVal(v.Val, m.Type, r.order), m.Type)
+	}
+
+	is, err := readString(r.data, uint64(v.Extra), r.header.Version, r.order)
+	if err != nil {
+		return nil, err
+	}
+
+	return cstring(is.(*String).Payload[:]), nil
+}
+
+// cstring converts a null terminated byte array to a string
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
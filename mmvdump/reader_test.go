@@ -0,0 +1,185 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/test2.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := r.Metric("language.users")
+	if !ok {
+		t.Fatal("expected language.users to be present")
+	}
+
+	if m.Type != Uint64Type || m.Semantics != CounterSemantics || m.Unit != OneUnit {
+		t.Errorf("unexpected metadata for language.users: %v, %v, %v", m.Type, m.Semantics, m.Unit)
+	}
+
+	if len(m.Instances) != 3 {
+		t.Errorf("expected 3 instances, got %v", m.Instances)
+	}
+
+	v, err := r.Value("language.users", "php")
+	if err != nil || v != uint64(33) {
+		t.Errorf("expected language.users[php] to be 33, got %v, error: %v", v, err)
+	}
+
+	vals, err := r.Values("language.users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vals["go"] != uint64(8388608) || vals["javascript"] != uint64(330) {
+		t.Errorf("unexpected values for language.users: %v", vals)
+	}
+
+	indoms := r.InstanceDomains()
+	if len(indoms) != 1 || indoms[0].Serial != 3094651 || len(indoms[0].Instances) != 3 {
+		t.Errorf("unexpected instance domains: %v", indoms)
+	}
+
+	if _, err = r.Value("language.users", "rust"); err == nil {
+		t.Error("expected reading a missing instance to fail")
+	}
+
+	if _, err = r.Value("language.admins", ""); err == nil {
+		t.Error("expected reading a missing metric to fail")
+	}
+}
+
+func TestReaderSingletons(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/test5.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := r.Metrics()
+	if len(ms) != 3 || ms[0].Name != "download_speed" || ms[1].Name != "frequency" || ms[2].Name != "time" {
+		t.Fatalf("expected metrics sorted by name, got %v", ms)
+	}
+
+	if ms[0].ShortText != "Download speed in MiB/sec" || ms[0].Unit.String() != "MiB / sec" {
+		t.Errorf("unexpected metadata for download_speed: %q, %v", ms[0].ShortText, ms[0].Unit)
+	}
+
+	be, err := NewReader(bigEndian(t, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]interface{}{
+		"download_speed": 1.0 / 3,
+		"frequency":      float32(1.0 / 3),
+		"time":           int32(-6),
+	} {
+		if v, err := r.Value(name, ""); err != nil || v != expected {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, expected, v, err)
+		}
+
+		if v, err := be.Value(name, ""); err != nil || v != expected {
+			t.Errorf("expected big endian %v to be %v, got %v, error: %v", name, expected, v, err)
+		}
+	}
+}
+
+func TestReaderStrings(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/test3.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := r.Value("bat.names", ""); err != nil || v != "Robin" {
+		t.Errorf("expected bat.names to be Robin, got %q, error: %v", v, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/test1.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "mmvdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	loc := filepath.Join(dir, "test1.mmv")
+	if err = ioutil.WriteFile(loc, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if v, err := r.Value("simple.counter", ""); err != nil || v != int32(42) {
+		t.Errorf("expected simple.counter to be 42, got %v, error: %v", v, err)
+	}
+
+	if r.Header().Process != 29956 {
+		t.Errorf("expected process 29956, got %v", r.Header().Process)
+	}
+
+	// values are re-read from the mapping, so writes to the file show up without reopening
+
+	f, err := os.OpenFile(loc, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, 43)
+	if _, err = f.WriteAt(b, 192); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	if v, err := r.Value("simple.counter", ""); err != nil || v != int32(43) {
+		t.Errorf("expected simple.counter to be 43 after the write, got %v, error: %v", v, err)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Value("simple.counter", ""); err == nil {
+		t.Error("expected reading from a closed reader to fail")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	if _, err := Open("testdata/missing.mmv"); err == nil {
+		t.Error("expected opening a missing file to fail")
+	}
+
+	if _, err := Open("testdata/output1.golden"); err == nil {
+		t.Error("expected opening a non MMV file to fail")
+	}
+}
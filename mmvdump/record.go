@@ -0,0 +1,448 @@
+package mmvdump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A recording is a sequence of records, each made up of a kind byte, the uvarint length of its payload
+// and the payload. The payload of a metadata record is a JSON encoded recordingMeta, written whenever
+// the recorded file has a new generation, while the payload of a values record is the varint time in
+// nanoseconds since the epoch followed by the values of all metrics and instances in the order of the
+// last metadata record, so recordings can be appended to by new recorders and concatenated.
+const (
+	metaRecord   byte = 'M'
+	valuesRecord byte = 'V'
+)
+
+// RecordingFormat identifies the format of a recording, and is stored in every metadata record
+const RecordingFormat = "mmvdump-recording/1"
+
+// maxRecordLength guards against allocating huge buffers for corrupt recordings
+const maxRecordLength = 64 << 20
+
+type recordingMeta struct {
+	Format     string        `json:"format"`
+	Generation uint64        `json:"generation"`
+	Process    int32         `json:"process"`
+	Cluster    int32         `json:"cluster"`
+	Metrics    []*MetricDesc `json:"metrics"`
+}
+
+// recordedInstances returns the instances of a metric values are recorded for,
+// singleton metrics have a single value under the empty string
+func recordedInstances(m *MetricDesc) []string {
+	if m.Instances == nil {
+		return []string{""}
+	}
+	return m.Instances
+}
+
+// Recorder appends samples of a MMV file to a recording, which can be read back by a RecordingReader.
+type Recorder struct {
+	w          io.Writer
+	buf        bytes.Buffer
+	started    bool
+	generation uint64
+}
+
+// NewRecorder creates a Recorder appending to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Record takes a snapshot of the passed Reader and appends its values at time t,
+// preceded by the metadata of the file for the first record and every time the file
+// is rewritten. Every record is written with a single call to Write.
+func (rec *Recorder) Record(r *Reader, t time.Time) error {
+	s, err := r.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	if !rec.started || s.Reloaded || s.Header.G1 != rec.generation {
+		meta, err := json.Marshal(&recordingMeta{RecordingFormat, s.Header.G1, s.Header.Process, s.Header.Cluster, r.Metrics()})
+		if err != nil {
+			return err
+		}
+
+		if err := rec.write(metaRecord, meta); err != nil {
+			return err
+		}
+
+		rec.started, rec.generation = true, s.Header.G1
+	}
+
+	var payload []byte
+	payload = appendVarint(payload, t.UnixNano())
+
+	for _, m := range r.Metrics() {
+		for _, ins := range recordedInstances(m) {
+			v, ok := s.Values[m.Name][ins]
+			if !ok {
+				return errors.Errorf("no value for metric %v and instance %q", m.Name, ins)
+			}
+
+			if payload, err = appendValue(payload, m.Type, v); err != nil {
+				return errors.Wrapf(err, "cannot record metric %v", m.Name)
+			}
+		}
+	}
+
+	return rec.write(valuesRecord, payload)
+}
+
+func (rec *Recorder) write(kind byte, payload []byte) error {
+	rec.buf.Reset()
+	rec.buf.WriteByte(kind)
+	rec.buf.Write(appendUvarint(nil, uint64(len(payload))))
+	rec.buf.Write(payload)
+
+	_, err := rec.w.Write(rec.buf.Bytes())
+	return err
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+// appendValue encodes a value, integers as varints, floats as their little endian bits
+// and strings prefixed by their length
+func appendValue(b []byte, t Type, v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case int32:
+		return appendVarint(b, int64(val)), nil
+	case int64:
+		return appendVarint(b, val), nil
+	case uint32:
+		return appendUvarint(b, uint64(val)), nil
+	case uint64:
+		return appendUvarint(b, val), nil
+	case float32:
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(val))
+		return append(b, tmp[:]...), nil
+	case float64:
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(val))
+		return append(b, tmp[:]...), nil
+	case string:
+		return append(appendUvarint(b, uint64(len(val))), val...), nil
+	}
+
+	return nil, errors.Errorf("cannot record a value of type %v", t)
+}
+
+// Sample is a single sample read from a recording.
+type Sample struct {
+	Time       time.Time
+	Generation uint64
+	Process    int32
+
+	// Metrics describes the recorded metrics, it is shared by all samples of a generation
+	Metrics []*MetricDesc
+
+	// Values holds the values of all metrics by metric and instance name,
+	// singleton metrics store their value under the empty string
+	Values map[string]map[string]interface{}
+}
+
+// RecordingReader reads samples from a recording written by a Recorder.
+type RecordingReader struct {
+	r    *bufio.Reader
+	meta *recordingMeta
+}
+
+// NewRecordingReader creates a RecordingReader reading from r.
+func NewRecordingReader(r io.Reader) *RecordingReader {
+	return &RecordingReader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next sample, returning io.EOF at the end of the recording and
+// io.ErrUnexpectedEOF if the recording ends in the middle of a record, as it does
+// when a recorder is killed while writing.
+func (rr *RecordingReader) Next() (*Sample, error) {
+	for {
+		kind, err := rr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := binary.ReadUvarint(rr.r)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		if length > maxRecordLength {
+			return nil, errors.Errorf("record of %v bytes is too long", length)
+		}
+
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(rr.r, payload); err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case metaRecord:
+			meta := new(recordingMeta)
+			if err := json.Unmarshal(payload, meta); err != nil {
+				return nil, errors.Wrap(err, "invalid metadata record")
+			}
+
+			if meta.Format != RecordingFormat {
+				return nil, errors.Errorf("unknown recording format %q", meta.Format)
+			}
+
+			rr.meta = meta
+		case valuesRecord:
+			if rr.meta == nil {
+				return nil, errors.New("values record without metadata")
+			}
+			return rr.decode(payload)
+		default:
+			return nil, errors.Errorf("unknown record kind %q", kind)
+		}
+	}
+}
+
+func (rr *RecordingReader) decode(payload []byte) (*Sample, error) {
+	d := &valueDecoder{data: payload}
+
+	s := &Sample{
+		Time:       time.Unix(0, d.varint()),
+		Generation: rr.meta.Generation,
+		Process:    rr.meta.Process,
+		Metrics:    rr.meta.Metrics,
+		Values:     make(map[string]map[string]interface{}, len(rr.meta.Metrics)),
+	}
+
+	for _, m := range rr.meta.Metrics {
+		vals := make(map[string]interface{})
+		for _, ins := range recordedInstances(m) {
+			vals[ins] = d.value(m.Type)
+		}
+		s.Values[m.Name] = vals
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if len(d.data) > 0 {
+		return nil, errors.Errorf("%v unexpected bytes at the end of a values record", len(d.data))
+	}
+
+	return s, nil
+}
+
+// valueDecoder decodes values encoded by appendValue, remembering the first error
+type valueDecoder struct {
+	data []byte
+	err  error
+}
+
+var errShortRecord = errors.New("values record is too short")
+
+func (d *valueDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.data, d.err = nil, errShortRecord
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *valueDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.data, d.err = nil, errShortRecord
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *valueDecoder) bytes(n uint64) []byte {
+	if uint64(len(d.data)) < n {
+		d.data, d.err = nil, errShortRecord
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *valueDecoder) value(t Type) interface{} {
+	if d.err != nil {
+		return nil
+	}
+
+	switch t {
+	case Int32Type:
+		return int32(d.varint())
+	case Int64Type:
+		return d.varint()
+	case Uint32Type:
+		return uint32(d.uvarint())
+	case Uint64Type:
+		return d.uvarint()
+	case FloatType:
+		return math.Float32frombits(binary.LittleEndian.Uint32(d.bytes(4)))
+	case DoubleType:
+		return math.Float64frombits(binary.LittleEndian.Uint64(d.bytes(8)))
+	case StringType:
+		n := d.uvarint()
+		if n > uint64(len(d.data)) {
+			d.data, d.err = nil, errShortRecord
+			return ""
+		}
+		return string(d.bytes(n))
+	}
+
+	d.data, d.err = nil, errors.Errorf("cannot decode a value of type %v", t)
+	return nil
+}
+
+// RecordingCSVHeader holds the column names written by WriteRecordingCSV.
+var RecordingCSVHeader = []string{"time", "generation", "metric", "instance", "value"}
+
+// WriteRecordingCSV writes every sample in a recording to w as CSV, starting with
+// RecordingCSVHeader followed by a row for every value, with times in RFC 3339 format.
+func WriteRecordingCSV(w io.Writer, rr *RecordingReader) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(RecordingCSVHeader); err != nil {
+		return err
+	}
+
+	for {
+		s, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			cw.Flush()
+			return err
+		}
+
+		t := s.Time.UTC().Format(time.RFC3339Nano)
+		g := strconv.FormatUint(s.Generation, 10)
+
+		for _, m := range s.Metrics {
+			for _, ins := range recordedInstances(m) {
+				v := s.Values[m.Name][ins]
+				switch f := v.(type) {
+				case float32:
+					v = jsonFloat(f, float64(f))
+				case float64:
+					v = jsonFloat(f, f)
+				}
+
+				if err := cw.Write([]string{t, g, m.Name, ins, csvValue(v)}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type pointJSON struct {
+	Time       time.Time   `json:"time"`
+	Generation uint64      `json:"generation"`
+	Value      interface{} `json:"value"`
+}
+
+type seriesJSON struct {
+	Metric    string      `json:"metric"`
+	Instance  *string     `json:"instance,omitempty"`
+	Type      string      `json:"type"`
+	Semantics string      `json:"semantics"`
+	Units     string      `json:"units"`
+	Points    []pointJSON `json:"points"`
+}
+
+// WriteRecordingJSON writes a recording to w as a JSON document holding a list of time series,
+// one for every metric and instance in the order they first appear in the recording.
+// Series keep the type, semantics and units of the first sample they appear in.
+// Floating point values that JSON cannot represent are written as the strings "NaN", "+Inf" and "-Inf".
+func WriteRecordingJSON(w io.Writer, rr *RecordingReader) error {
+	type key struct{ metric, instance string }
+
+	var (
+		series []*seriesJSON
+		byKey  = make(map[key]*seriesJSON)
+	)
+
+	for {
+		s, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		for _, m := range s.Metrics {
+			for _, ins := range recordedInstances(m) {
+				sj, ok := byKey[key{m.Name, ins}]
+				if !ok {
+					sj = &seriesJSON{
+						Metric:    m.Name,
+						Type:      m.Type.String(),
+						Semantics: m.Semantics.String(),
+						Units:     m.Unit.String(),
+						Points:    []pointJSON{},
+					}
+
+					if m.Instances != nil {
+						name := ins
+						sj.Instance = &name
+					}
+
+					byKey[key{m.Name, ins}] = sj
+					series = append(series, sj)
+				}
+
+				v := s.Values[m.Name][ins]
+				switch f := v.(type) {
+				case float32:
+					v = jsonFloat(f, float64(f))
+				case float64:
+					v = jsonFloat(f, f)
+				}
+
+				sj.Points = append(sj.Points, pointJSON{s.Time.UTC(), s.Generation, v})
+			}
+		}
+	}
+
+	if series == nil {
+		series = []*seriesJSON{}
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(struct {
+		Series []*seriesJSON `json:"series"`
+	}{series})
+}
@@ -0,0 +1,156 @@
+package mmvdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecording(t *testing.T) {
+	loc, cleanup := copyTestdata("test2.mmv", t)
+	defer cleanup()
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+
+	start := time.Unix(1500000000, 0)
+	for i := 0; i < 2; i++ {
+		if err = rec.Record(r, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the writer restarting with different metrics, which replaces the file
+	if err = os.Remove(loc); err != nil {
+		t.Fatal(err)
+	}
+	writeTestdata("test5.mmv", loc, t)
+	if err = rec.Record(r, start.Add(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := NewRecordingReader(bytes.NewReader(buf.Bytes()))
+
+	var samples []*Sample
+	for {
+		s, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, s)
+	}
+
+	if len(samples) != 3 {
+		t.Fatalf("expected 3 samples, got %v", len(samples))
+	}
+
+	if !samples[1].Time.Equal(start.Add(time.Second)) {
+		t.Errorf("expected the second sample at %v, got %v", start.Add(time.Second), samples[1].Time)
+	}
+
+	if v := samples[0].Values["language.users"]["go"]; v != uint64(8388608) {
+		t.Errorf("expected language.users[go] to be 8388608, got %v", v)
+	}
+
+	if samples[0].Generation != 1469335238 || samples[2].Generation != 1501135556 {
+		t.Errorf("unexpected generations %v and %v", samples[0].Generation, samples[2].Generation)
+	}
+
+	if v := samples[2].Values["download_speed"][""]; v != 1.0/3 {
+		t.Errorf("expected download_speed to be 1/3, got %v", v)
+	}
+
+	if v := samples[2].Values["time"][""]; v != int32(-6) {
+		t.Errorf("expected time to be -6, got %v", v)
+	}
+
+	if _, ok := samples[2].Values["language.users"]; ok {
+		t.Errorf("expected metrics of the old generation to be gone after a restart")
+	}
+
+	// a recording cut short in the middle of the last record
+	rr = NewRecordingReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	for i := 0; i < 2; i++ {
+		if _, err = rr.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = rr.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated recording, got %v", err)
+	}
+}
+
+func TestWriteRecording(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+
+	r := readTestdata("test2.mmv", t)
+	for i := 0; i < 2; i++ {
+		if err := rec.Record(r, time.Unix(1500000000+int64(i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteRecordingCSV(&out, NewRecordingReader(bytes.NewReader(buf.Bytes()))); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `time,generation,metric,instance,value
+2017-07-14T02:40:00Z,1469335238,language.users,go,8388608
+2017-07-14T02:40:00Z,1469335238,language.users,javascript,330
+2017-07-14T02:40:00Z,1469335238,language.users,php,33
+2017-07-14T02:40:01Z,1469335238,language.users,go,8388608
+2017-07-14T02:40:01Z,1469335238,language.users,javascript,330
+2017-07-14T02:40:01Z,1469335238,language.users,php,33
+`
+
+	if out.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, out.String())
+	}
+
+	out.Reset()
+	if err := WriteRecordingJSON(&out, NewRecordingReader(bytes.NewReader(buf.Bytes()))); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Series []struct {
+			Metric   string
+			Instance *string
+			Points   []struct {
+				Time  time.Time
+				Value float64
+			}
+		}
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Series) != 3 {
+		t.Fatalf("expected 3 series, got %v", len(doc.Series))
+	}
+
+	s := doc.Series[0]
+	if s.Metric != "language.users" || s.Instance == nil || len(s.Points) != 2 {
+		t.Fatalf("unexpected series %+v", s)
+	}
+
+	if *s.Instance != "go" || s.Points[0].Value != 8388608 || !s.Points[1].Time.Equal(time.Unix(1500000001, 0)) {
+		t.Errorf("unexpected points %+v for instance %v", s.Points, *s.Instance)
+	}
+}
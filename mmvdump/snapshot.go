@@ -0,0 +1,159 @@
+package mmvdump
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFileRewritten is returned when a consistent view of an MMV file could not be
+// read because its writer kept rewriting it, or was in the middle of writing it,
+// for all retries.
+var ErrFileRewritten = errors.New("mmv file is being rewritten")
+
+var (
+	// SnapshotRetries is the number of times a Reader tries to read a consistent
+	// view of a file before giving up with ErrFileRewritten.
+	SnapshotRetries = 10
+
+	// SnapshotRetryInterval is the time a Reader waits between retries.
+	SnapshotRetryInterval = 10 * time.Millisecond
+)
+
+// Snapshot holds the values of all metrics in an MMV file, read within a single generation.
+type Snapshot struct {
+	Header Header
+
+	// Reloaded is true if the metadata of the Reader was loaded since the previous snapshot,
+	// either because this is the first one or because the writer restarted or replaced the file,
+	// in which case the set of metrics and their values may have changed in ways that make
+	// comparing with older snapshots meaningless
+	Reloaded bool
+
+	// Values holds the values of all metrics by metric and instance name,
+	// singleton metrics store their value under the empty string
+	Values map[string]map[string]interface{}
+}
+
+// generations reads both generation numbers from the header, writers set the first
+// one when they start writing a file and the second one when they are done
+func generations(data []byte) (g1, g2 uint64, ok bool) {
+	if uint64(len(data)) < HeaderLength {
+		return 0, 0, false
+	}
+
+	order := DetectByteOrder(data)
+	return order.Uint64(data[8:]), order.Uint64(data[16:]), true
+}
+
+// inProgress checks if a writer has started but not finished writing the data,
+// writers write the magic before anything else and the second generation number last
+func inProgress(data []byte) bool {
+	if uint64(len(data)) < HeaderLength {
+		return false
+	}
+
+	if data[0] == 0 {
+		return true
+	}
+
+	g1, g2, _ := generations(data)
+	return string(data[:3]) == "MMV" && g1 != g2
+}
+
+// Snapshot reads the values of all metrics in the file.
+//
+// The generation numbers in the header are checked before and after reading, and reading
+// is retried if the writer was in the middle of writing the file or started a new generation.
+// If the Reader was created by Open, the file at the path is also checked for having been replaced
+// by a new one, as writers do when they restart, in which case the new file is mapped and the
+// metadata of the Reader is reloaded. If no consistent snapshot could be read after
+// SnapshotRetries tries, ErrFileRewritten is returned.
+func (r *Reader) Snapshot() (*Snapshot, error) {
+	var err error
+
+	for i := 0; i < SnapshotRetries; i++ {
+		if i > 0 {
+			time.Sleep(SnapshotRetryInterval)
+		}
+
+		var s *Snapshot
+		if s, err = r.snapshot(); err == nil {
+			return s, nil
+		}
+
+		if err != ErrFileRewritten && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return nil, ErrFileRewritten
+}
+
+func (r *Reader) snapshot() (*Snapshot, error) {
+	if r.data == nil {
+		return nil, errors.New("reading from a closed Reader")
+	}
+
+	if r.path != "" {
+		if err := r.checkReplaced(); err != nil {
+			return nil, err
+		}
+	}
+
+	g1, g2, _ := generations(r.data)
+	if g1 != g2 {
+		return nil, ErrFileRewritten
+	}
+
+	if g1 != r.header.G1 {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Snapshot{Header: r.header, Reloaded: r.reloaded, Values: make(map[string]map[string]interface{}, len(r.metrics))}
+	for name, m := range r.metrics {
+		vals := make(map[string]interface{}, len(m.values))
+		for ins, off := range m.values {
+			v, err := r.value(m, off)
+			if err != nil {
+				return nil, err
+			}
+			vals[ins] = v
+		}
+		s.Values[name] = vals
+	}
+
+	if a, b, _ := generations(r.data); a != g1 || b != g2 {
+		return nil, ErrFileRewritten
+	}
+
+	r.reloaded = false
+	return s, nil
+}
+
+// checkReplaced remaps the file at the path of the Reader if it is not
+// the one that is currently mapped
+func (r *Reader) checkReplaced() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+
+	cur, err := r.handle.Stat()
+	if err != nil {
+		return err
+	}
+
+	if os.SameFile(fi, cur) {
+		return nil
+	}
+
+	return r.remap()
+}
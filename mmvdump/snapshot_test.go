@@ -0,0 +1,188 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyTestdata copies a file from testdata into a temporary directory,
+// returning its new location and a function removing the directory
+func copyTestdata(name string, t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "mmvdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := filepath.Join(dir, "test.mmv")
+	writeTestdata(name, loc, t)
+
+	return loc, func() { _ = os.RemoveAll(dir) }
+}
+
+func writeTestdata(name, loc string, t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(loc, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/test2.mmv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Header.G1 != 1469335238 {
+		t.Errorf("expected generation 1469335238, got %v", s.Header.G1)
+	}
+
+	if v := s.Values["language.users"]["javascript"]; v != uint64(330) {
+		t.Errorf("expected language.users[javascript] to be 330, got %v", v)
+	}
+}
+
+func TestSnapshotInProgress(t *testing.T) {
+	defer func(n int) { SnapshotRetries = n }(SnapshotRetries)
+	SnapshotRetries = 2
+
+	loc, cleanup := copyTestdata("test1.mmv", t)
+	defer cleanup()
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(loc, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// a writer that has started a new generation but not finished it
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, 0)
+	if _, err = f.WriteAt(b, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Snapshot(); err != ErrFileRewritten {
+		t.Errorf("expected ErrFileRewritten while the file is being written, got %v", err)
+	}
+
+	if _, err = Open(loc); err != ErrFileRewritten {
+		t.Errorf("expected opening a file that is being written to return ErrFileRewritten, got %v", err)
+	}
+
+	// the writer finishing its generation makes the file readable again
+	binary.LittleEndian.PutUint64(b, 1468770537)
+	if _, err = f.WriteAt(b, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt(b, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Header.G1 != 1468770537 || s.Values["simple.counter"][""] != int32(42) {
+		t.Errorf("unexpected snapshot after the new generation: %v", s)
+	}
+}
+
+func TestSnapshotReplaced(t *testing.T) {
+	loc, cleanup := copyTestdata("test1.mmv", t)
+	defer cleanup()
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Reloaded {
+		t.Error("expected the first snapshot to be marked as reloaded")
+	}
+
+	if s, err = r.Snapshot(); err != nil || s.Reloaded {
+		t.Errorf("expected a snapshot of an unchanged file to not be marked as reloaded, error: %v", err)
+	}
+
+	// writers remove the old file and create a new one when restarting
+	if err = os.Remove(loc); err != nil {
+		t.Fatal(err)
+	}
+	writeTestdata("test5.mmv", loc, t)
+
+	if s, err = r.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Reloaded {
+		t.Error("expected a snapshot of a replaced file to be marked as reloaded")
+	}
+
+	if _, ok := s.Values["simple.counter"]; ok {
+		t.Error("expected metrics of the replaced file to be gone")
+	}
+
+	if v := s.Values["time"][""]; v != int32(-6) {
+		t.Errorf("expected time to be -6, got %v", v)
+	}
+
+	if _, ok := r.Metric("download_speed"); !ok {
+		t.Error("expected the metadata of the reader to be reloaded")
+	}
+
+	if err = os.Remove(loc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Snapshot(); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error for a removed file, got %v", err)
+	}
+}
+
+func TestSnapshotClosed(t *testing.T) {
+	loc, cleanup := copyTestdata("test1.mmv", t)
+	defer cleanup()
+
+	r, err := Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Snapshot(); err == nil {
+		t.Error("expected a snapshot of a closed reader to fail")
+	}
+}
@@ -0,0 +1,371 @@
+package mmvdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Problem describes a single issue found while validating a MMV file
+type Problem struct {
+	Offset  uint64 // the offset of the item with the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("offset %v: %v", p.Offset, p.Message)
+}
+
+// section holds the location of a TOC section
+type section struct {
+	typ    TocType
+	offset uint64
+	count  uint64
+	length uint64 // of a single item
+}
+
+func (s section) end() uint64 { return s.offset + s.count*s.length }
+
+// contains checks if off is the start of an item in the section
+func (s section) contains(off uint64) bool {
+	return s.count > 0 && off >= s.offset && off < s.end() && (off-s.offset)%s.length == 0
+}
+
+type validator struct {
+	data     []byte
+	order    binary.ByteOrder
+	version  int32
+	sections map[TocType]section
+	problems []Problem
+
+	indomSerials map[uint64]uint32 // indom serials by offset
+	serials      map[uint32]bool
+	instanceOf   map[uint64]uint64 // indom offsets by instance offset
+}
+
+func (v *validator) problem(off uint64, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{off, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) u32(off uint64) uint32 { return v.order.Uint32(v.data[off:]) }
+func (v *validator) u64(off uint64) uint64 { return v.order.Uint64(v.data[off:]) }
+func (v *validator) i32(off uint64) int32  { return int32(v.u32(off)) }
+
+// items returns the offsets of all items in a section of the passed type
+func (v *validator) items(t TocType) []uint64 {
+	s, ok := v.sections[t]
+	if !ok {
+		return nil
+	}
+
+	ans := make([]uint64, s.count)
+	for i := range ans {
+		ans[i] = s.offset + uint64(i)*s.length
+	}
+	return ans
+}
+
+// checkString checks an optional reference to a string, at off
+func (v *validator) checkString(off, ref uint64, what string) {
+	if ref == 0 {
+		return
+	}
+
+	if s, ok := v.sections[TocStrings]; !ok || !s.contains(ref) {
+		v.problem(off, "%v refers to offset %v, which is not a string", what, ref)
+	}
+}
+
+// checkName checks that a fixed length name is NUL terminated and not empty
+func (v *validator) checkName(off uint64, what string) {
+	name := v.data[off : off+NameMax]
+
+	i := bytes.IndexByte(name, 0)
+	switch {
+	case i == -1:
+		v.problem(off, "%v is not NUL terminated", what)
+	case i == 0:
+		v.problem(off, "%v is empty", what)
+	}
+}
+
+// Validate checks a MMV file for structural problems, returning every problem found.
+// A file without problems returns an empty slice.
+//
+// Unlike Dump, which stops at the first problem, Validate keeps going as long as it
+// can make sense of the rest of the file, checking the header, that TOC sections are in bounds
+// and do not overlap, that every offset refers to an item of the right kind, that
+// item ids and instance domain serials are unique, that types, semantics and units are valid
+// and that all strings are NUL terminated.
+func Validate(data []byte) []Problem {
+	v := &validator{
+		data:         data,
+		order:        DetectByteOrder(data),
+		sections:     make(map[TocType]section),
+		indomSerials: make(map[uint64]uint32),
+		serials:      make(map[uint32]bool),
+		instanceOf:   make(map[uint64]uint64),
+	}
+
+	if !v.validateHeader() {
+		return v.problems
+	}
+
+	v.validateStrings()
+	v.validateIndoms()
+	v.validateInstances()
+	metrics := v.validateMetrics()
+	v.validateValues(metrics)
+
+	sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].Offset < v.problems[j].Offset })
+	return v.problems
+}
+
+// validateHeader checks the header and the TOCs, returning false if nothing else can be checked
+func (v *validator) validateHeader() bool {
+	if uint64(len(v.data)) < HeaderLength {
+		v.problem(0, "file is %v bytes, too small to contain a header", len(v.data))
+		return false
+	}
+
+	if m := string(v.data[:3]); m != "MMV" {
+		v.problem(0, "bad magic %q", m)
+		return false
+	}
+
+	v.version = v.i32(4)
+	if v.version != 1 && v.version != 2 {
+		v.problem(4, "unknown version %v", v.version)
+		return false
+	}
+
+	if g1, g2 := v.u64(8), v.u64(16); g1 != g2 {
+		v.problem(8, "mismatched generations %v and %v, the file is being written", g1, g2)
+	}
+
+	tocs := v.i32(24)
+	if tocs < 0 {
+		v.problem(24, "negative TOC count %v", tocs)
+		return false
+	}
+
+	if end := HeaderLength + uint64(tocs)*TocLength; end > uint64(len(v.data)) {
+		v.problem(24, "%v TOCs end at offset %v, beyond the end of the file at %v", tocs, end, len(v.data))
+		return false
+	}
+
+	lengths := map[TocType]uint64{
+		TocIndoms:    InstanceDomainLength,
+		TocInstances: Instance1Length,
+		TocMetrics:   Metric1Length,
+		TocValues:    ValueLength,
+		TocStrings:   StringLength,
+	}
+
+	if v.version == 2 {
+		lengths[TocInstances], lengths[TocMetrics] = Instance2Length, Metric2Length
+	}
+
+	var ss []section
+	for i := uint64(0); i < uint64(tocs); i++ {
+		off := HeaderLength + i*TocLength
+		t, count, offset := TocType(v.i32(off)), v.i32(off+4), v.u64(off+8)
+
+		length, ok := lengths[t]
+		if !ok {
+			v.problem(off, "unknown TOC type %v", int32(t))
+			continue
+		}
+
+		if _, dup := v.sections[t]; dup {
+			v.problem(off, "duplicate TOC for %v", t)
+			continue
+		}
+
+		if count < 0 {
+			v.problem(off, "negative count %v for %v", count, t)
+			continue
+		}
+
+		s := section{t, offset, uint64(count), length}
+
+		if offset < HeaderLength+uint64(tocs)*TocLength {
+			v.problem(off, "%v start at offset %v, inside the header and TOCs", t, offset)
+			continue
+		}
+
+		if s.end() > uint64(len(v.data)) || s.end() < offset {
+			v.problem(off, "%v end at offset %v, beyond the end of the file at %v", t, s.end(), len(v.data))
+			continue
+		}
+
+		ss = append(ss, s)
+		v.sections[t] = s
+	}
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].offset < ss[j].offset })
+	for i := 1; i < len(ss); i++ {
+		if ss[i-1].count > 0 && ss[i].count > 0 && ss[i-1].end() > ss[i].offset {
+			v.problem(ss[i].offset, "%v overlap with %v", ss[i].typ, ss[i-1].typ)
+		}
+	}
+
+	return true
+}
+
+func (v *validator) validateStrings() {
+	for _, off := range v.items(TocStrings) {
+		if bytes.IndexByte(v.data[off:off+StringLength], 0) == -1 {
+			v.problem(off, "string is not NUL terminated")
+		}
+	}
+}
+
+func (v *validator) validateIndoms() {
+	instances := v.sections[TocInstances]
+
+	for _, off := range v.items(TocIndoms) {
+		serial, count, ioff := v.u32(off), v.u32(off+4), v.u64(off+8)
+
+		if v.serials[serial] {
+			v.problem(off, "duplicate instance domain serial %v", serial)
+		}
+		v.serials[serial] = true
+		v.indomSerials[off] = serial
+
+		if count > 0 {
+			last := ioff + uint64(count-1)*instances.length
+			if !instances.contains(ioff) || !instances.contains(last) {
+				v.problem(off, "instance domain %v has %v instances from offset %v, which are not all instances", serial, count, ioff)
+			}
+		}
+
+		v.checkString(off, v.u64(off+16), "shorttext")
+		v.checkString(off, v.u64(off+24), "longtext")
+	}
+}
+
+func (v *validator) validateInstances() {
+	for _, off := range v.items(TocInstances) {
+		indom := v.u64(off)
+
+		if _, ok := v.indomSerials[indom]; !ok {
+			v.problem(off, "instance refers to offset %v, which is not an instance domain", indom)
+		} else {
+			v.instanceOf[off] = indom
+		}
+
+		if v.version == 1 {
+			v.checkName(off+16, "instance name")
+		} else if ext := v.u64(off + 16); ext == 0 {
+			v.problem(off, "instance has no name")
+		} else {
+			v.checkString(off, ext, "instance name")
+		}
+	}
+}
+
+// validMetricUnit checks that a unit has valid scales and no padding set
+func validMetricUnit(u Unit) bool {
+	return u&0xFF == 0 &&
+		(u.SpaceDim() == 0 || u.SpaceScale() <= 6) &&
+		(u.TimeDim() == 0 || u.TimeScale() <= 5)
+}
+
+type validatedMetric struct {
+	typ   Type
+	indom int32
+}
+
+func (v *validator) validateMetrics() map[uint64]validatedMetric {
+	metrics := make(map[uint64]validatedMetric)
+	items := make(map[uint32]uint64)
+	names := make(map[string]uint64)
+
+	for _, off := range v.items(TocMetrics) {
+		base := off + 8
+		if v.version == 1 {
+			base = off + NameMax
+			v.checkName(off, "metric name")
+
+			name := cstring(v.data[off : off+NameMax])
+			if prev, dup := names[name]; dup && name != "" {
+				v.problem(off, "duplicate metric name %v, also at offset %v", name, prev)
+			}
+			names[name] = off
+		} else if ref := v.u64(off); ref == 0 {
+			v.problem(off, "metric has no name")
+		} else {
+			v.checkString(off, ref, "metric name")
+
+			if v.sections[TocStrings].contains(ref) {
+				name := cstring(v.data[ref : ref+StringLength])
+				if prev, dup := names[name]; dup {
+					v.problem(off, "duplicate metric name %v, also at offset %v", name, prev)
+				}
+				names[name] = off
+			}
+		}
+
+		item, typ, sem, unit, indom := v.u32(base), Type(v.i32(base+4)), Semantics(v.i32(base+8)), Unit(v.u32(base+12)), v.i32(base+16)
+
+		if prev, dup := items[item]; dup {
+			v.problem(off, "duplicate item id %v, also used by the metric at offset %v", item, prev)
+		}
+		items[item] = off
+
+		if typ < Int32Type || typ > StringType {
+			v.problem(off, "invalid type %v", int32(typ))
+		}
+
+		if sem != CounterSemantics && sem != InstantSemantics && sem != DiscreteSemantics {
+			v.problem(off, "invalid semantics %v", int32(sem))
+		}
+
+		if !validMetricUnit(unit) {
+			v.problem(off, "invalid units 0x%x", uint32(unit))
+		}
+
+		if indom != NoIndom && indom != 0 && !v.serials[uint32(indom)] {
+			v.problem(off, "metric refers to instance domain %v, which does not exist", indom)
+		}
+
+		v.checkString(off, v.u64(base+24), "shorttext")
+		v.checkString(off, v.u64(base+32), "longtext")
+
+		metrics[off] = validatedMetric{typ, indom}
+	}
+
+	return metrics
+}
+
+func (v *validator) validateValues(metrics map[uint64]validatedMetric) {
+	for _, off := range v.items(TocValues) {
+		extra, moff, ioff := v.u64(off+8), v.u64(off+16), v.u64(off+24)
+
+		m, ok := metrics[moff]
+		if !ok {
+			v.problem(off, "value refers to offset %v, which is not a metric", moff)
+			continue
+		}
+
+		if m.indom == NoIndom || m.indom == 0 {
+			if ioff != 0 {
+				v.problem(off, "value of a metric without an instance domain refers to instance %v", ioff)
+			}
+		} else if indom, ok := v.instanceOf[ioff]; !ok {
+			v.problem(off, "value refers to offset %v, which is not an instance", ioff)
+		} else if serial := v.indomSerials[indom]; serial != uint32(m.indom) {
+			v.problem(off, "value refers to an instance of instance domain %v, but its metric uses %v", serial, m.indom)
+		}
+
+		if m.typ == StringType {
+			if extra == 0 {
+				v.problem(off, "string value has no string")
+			} else {
+				v.checkString(off, extra, "string value")
+			}
+		}
+	}
+}
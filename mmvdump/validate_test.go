@@ -0,0 +1,105 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestValidateTestdata(t *testing.T) {
+	for _, name := range []string{"test1.mmv", "test2.mmv", "test3.mmv", "test4.mmv", "test5.mmv"} {
+		data, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ps := Validate(data); len(ps) != 0 {
+			t.Errorf("expected %v to be valid, got %v", name, ps)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		file     string
+		corrupt  func([]byte) []byte
+		problems []string
+	}{
+		{
+			"short file", "test1.mmv",
+			func(b []byte) []byte { return b[:20] },
+			[]string{"offset 0: file is 20 bytes, too small to contain a header"},
+		},
+		{
+			"mismatched generations", "test1.mmv",
+			func(b []byte) []byte { binary.LittleEndian.PutUint64(b[16:], 0); return b },
+			[]string{"offset 8: mismatched generations 1468770536 and 0"},
+		},
+		{
+			"section out of bounds", "test1.mmv",
+			func(b []byte) []byte { binary.LittleEndian.PutUint32(b[76:], 100); return b },
+			[]string{"offset 72: TocStrings end at offset 25824", "shorttext refers to offset 224, which is not a string", "longtext refers to offset 480"},
+		},
+		{
+			"overlapping sections", "test1.mmv",
+			func(b []byte) []byte { binary.LittleEndian.PutUint64(b[64:], 100); return b },
+			[]string{"TocValues overlap with TocMetrics", "offset 100: value refers to offset"},
+		},
+		{
+			"invalid metric", "test1.mmv",
+			func(b []byte) []byte {
+				binary.LittleEndian.PutUint32(b[88+64+4:], 9)
+				binary.LittleEndian.PutUint32(b[88+64+8:], 2)
+				binary.LittleEndian.PutUint32(b[88+64+12:], 1<<28|9<<16)
+				binary.LittleEndian.PutUint32(b[88+64+16:], 42)
+				return b
+			},
+			[]string{"offset 88: invalid type 9", "offset 88: invalid semantics 2", "offset 88: invalid units 0x10090000", "instance domain 42, which does not exist"},
+		},
+		{
+			"unterminated string", "test1.mmv",
+			func(b []byte) []byte {
+				for i := 224; i < 480; i++ {
+					b[i] = 'a'
+				}
+				return b
+			},
+			[]string{"offset 224: string is not NUL terminated"},
+		},
+		{
+			"dangling references", "test2.mmv",
+			func(b []byte) []byte {
+				binary.LittleEndian.PutUint64(b[216:], 8)
+				binary.LittleEndian.PutUint64(b[512+24:], 8)
+				return b
+			},
+			[]string{"offset 216: instance refers to offset 8, which is not an instance domain", "offset 512: value refers to offset 8, which is not an instance"},
+		},
+		{
+			"duplicate item ids", "test5.mmv",
+			func(b []byte) []byte { binary.LittleEndian.PutUint32(b[192+64:], 150); return b },
+			[]string{"offset 192: duplicate item id 150, also used by the metric at offset 88"},
+		},
+	} {
+		data, err := ioutil.ReadFile("testdata/" + c.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ps := Validate(c.corrupt(data))
+
+		var all []string
+		for _, p := range ps {
+			all = append(all, p.String())
+		}
+		report := strings.Join(all, "\n")
+
+		for _, p := range c.problems {
+			if !strings.Contains(report, p) {
+				t.Errorf("%v: expected a problem containing %q, got\n%v", c.name, p, report)
+			}
+		}
+	}
+}
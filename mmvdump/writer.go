@@ -7,11 +7,21 @@ import (
 	"github.com/pkg/errors"
 )
 
-func instanceName(m Instance, header *Header, strings map[uint64]*String) string {
-	if header.Version == 1 {
-		return string(m.(*Instance1).External[:])
+// stringAt returns the payload of the string at offset, which is an error
+// if the offset does not point to a string
+func stringAt(strings map[uint64]*String, offset uint64) (string, error) {
+	s, ok := strings[offset]
+	if !ok {
+		return "", errors.Errorf("invalid string address %v", offset)
+	}
+	return string(s.Payload[:]), nil
+}
+
+func instanceName(m Instance, header *Header, strings map[uint64]*String) (string, error) {
+	if i, ok := m.(*Instance1); ok {
+		return string(i.External[:]), nil
 	}
-	return string(strings[m.(*Instance2).External].Payload[:])
+	return stringAt(strings, m.(*Instance2).External)
 }
 
 func writeInstance(
@@ -23,9 +33,17 @@ func writeInstance(
 	strings map[uint64]*String,
 ) error {
 	i := instances[offset]
-	indom := indoms[i.Indom()]
-	Name := instanceName(i, header, strings)
-	_, err := fmt.Fprintf(w, "\t[%v/%v] instance = [%v/%v]\n", indom.Serial, offset, i.Internal(), Name)
+	indom, ok := indoms[i.Indom()]
+	if !ok {
+		return errors.Errorf("invalid instance domain address %v", i.Indom())
+	}
+
+	Name, err := instanceName(i, header, strings)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "\t[%v/%v] instance = [%v/%v]\n", indom.Serial, offset, i.Internal(), Name)
 	return err
 }
 
@@ -46,7 +64,12 @@ func writeInstanceDomain(
 			return err
 		}
 	} else {
-		if _, err := fmt.Fprintf(w, "\t\tshorttext=%v\n", string(strings[indom.Shorttext].Payload[:])); err != nil {
+		text, err := stringAt(strings, indom.Shorttext)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "\t\tshorttext=%v\n", text); err != nil {
 			return err
 		}
 	}
@@ -56,7 +79,12 @@ func writeInstanceDomain(
 			return err
 		}
 	} else {
-		if _, err := fmt.Fprintf(w, "\t\tlongtext=%v\n", string(strings[indom.Longtext].Payload[:])); err != nil {
+		text, err := stringAt(strings, indom.Longtext)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "\t\tlongtext=%v\n", text); err != nil {
 			return err
 		}
 	}
@@ -64,11 +92,11 @@ func writeInstanceDomain(
 	return nil
 }
 
-func metricName(m Metric, header *Header, strings map[uint64]*String) string {
-	if header.Version == 1 {
-		return string(m.(*Metric1).Name[:])
+func metricName(m Metric, header *Header, strings map[uint64]*String) (string, error) {
+	if m1, ok := m.(*Metric1); ok {
+		return string(m1.Name[:]), nil
 	}
-	return string(strings[m.(*Metric2).Name].Payload[:])
+	return stringAt(strings, m.(*Metric2).Name)
 }
 
 func writeMetric(
@@ -79,13 +107,16 @@ func writeMetric(
 	strings map[uint64]*String,
 ) error {
 	m := metrics[offset]
-	Name := metricName(m, header, strings)
+	Name, err := metricName(m, header, strings)
+	if err != nil {
+		return err
+	}
 
-	if _, err := fmt.Fprintf(w, "\t[%v/%v] %v\n", m.Item(), offset, Name); err != nil {
+	if _, err = fmt.Fprintf(w, "\t[%v/%v] %v\n", m.Item(), offset, Name); err != nil {
 		return err
 	}
 
-	_, err := fmt.Fprintf(w, "\t\ttype=%v (0x%x), sem=%v (0x%x), pad=0x%x\n", m.Typ(), int(m.Typ()), m.Sem(), int(m.Sem()), m.Padding())
+	_, err = fmt.Fprintf(w, "\t\ttype=%v (0x%x), sem=%v (0x%x), pad=0x%x\n", m.Typ(), int(m.Typ()), m.Sem(), int(m.Sem()), m.Padding())
 	if err != nil {
 		return err
 	}
@@ -109,7 +140,12 @@ func writeMetric(
 			return err
 		}
 	} else {
-		if _, err := fmt.Fprintf(w, "\t\tshorttext=%v\n", string(strings[m.ShortText()].Payload[:])); err != nil {
+		text, err := stringAt(strings, m.ShortText())
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "\t\tshorttext=%v\n", text); err != nil {
 			return err
 		}
 	}
@@ -119,7 +155,12 @@ func writeMetric(
 			return err
 		}
 	} else {
-		if _, err := fmt.Fprintf(w, "\t\tlongtext=%v\n", string(strings[m.LongText()].Payload[:])); err != nil {
+		text, err := stringAt(strings, m.LongText())
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "\t\tlongtext=%v\n", text); err != nil {
 			return err
 		}
 	}
@@ -137,16 +178,21 @@ func writeValue(
 	strings map[uint64]*String,
 ) error {
 	v := values[offset]
-	m := metrics[v.Metric]
+	m, ok := metrics[v.Metric]
+	if !ok {
+		return errors.Errorf("invalid metric address %v", v.Metric)
+	}
 
-	if _, err := fmt.Fprintf(w, "\t[%v/%v] %v", m.Item(), offset, metricName(m, header, strings)); err != nil {
+	name, err := metricName(m, header, strings)
+	if err != nil {
 		return err
 	}
 
-	var (
-		a   interface{}
-		err error
-	)
+	if _, err = fmt.Fprintf(w, "\t[%v/%v] %v", m.Item(), offset, name); err != nil {
+		return err
+	}
+
+	var a interface{}
 
 	if m.Typ() != StringType {
 		a, err = FixedVal(v.Val, m.Typ())
@@ -154,16 +200,24 @@ func writeValue(
 			return err
 		}
 	} else {
-		v, ok := strings[uint64(v.Extra)]
-		if !ok {
-			return errors.Errorf("invalid string address")
+		a, err = stringAt(strings, uint64(v.Extra))
+		if err != nil {
+			return err
 		}
-		a = string(v.Payload[:])
 	}
 
 	if m.Indom() != NoIndom && m.Indom() != 0 {
-		i := instances[v.Instance]
-		if _, err := fmt.Fprintf(w, "[%d or \"%s\"]", i.Internal(), instanceName(i, header, strings)); err != nil {
+		i, ok := instances[v.Instance]
+		if !ok {
+			return errors.Errorf("invalid instance address %v", v.Instance)
+		}
+
+		name, err := instanceName(i, header, strings)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "[%d or \"%s\"]", i.Internal(), name); err != nil {
 			return err
 		}
 	}
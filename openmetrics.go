@@ -0,0 +1,332 @@
+package speed
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+// Content types for the supported text exposition formats
+const (
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	PrometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// the quantiles reported for a PCPHistogram when it is exposed as a summary
+var openMetricsQuantiles = []float64{0.5, 0.9, 0.99}
+
+// openMetricsSample is a single line in an exposition
+type openMetricsSample struct {
+	suffix string
+	labels []string // alternating label names and values
+	value  string
+}
+
+// openMetricsFamily is a set of samples under a single name, type and unit
+type openMetricsFamily struct {
+	name, typ, unit, help string
+	samples               []openMetricsSample
+}
+
+// openMetricsUnit returns the Prometheus base unit suffix for a PCP unit,
+// along with the factor values need to be multiplied with, see mmvdump.PrometheusUnit
+func openMetricsUnit(u MetricUnit) (string, float64) {
+	if u == nil {
+		return "", 1
+	}
+
+	return mmvdump.PrometheusUnit(mmvdump.Unit(u.PMAPI()))
+}
+
+// metricValues returns a consistent copy of the values of a metric keyed by
+// instance name, singleton metrics have their value under the empty string
+func metricValues(m PCPMetric) map[string]interface{} {
+	single := func(mutex interface {
+		RLock()
+		RUnlock()
+	}, sm *pcpSingletonMetric) map[string]interface{} {
+		mutex.RLock()
+		defer mutex.RUnlock()
+		return map[string]interface{}{"": sm.val}
+	}
+
+	multiple := func(mutex interface {
+		RLock()
+		RUnlock()
+	}, im *pcpInstanceMetric) map[string]interface{} {
+		mutex.RLock()
+		defer mutex.RUnlock()
+
+		vals := make(map[string]interface{}, len(im.vals))
+		for k, v := range im.vals {
+			vals[k] = v.val
+		}
+		return vals
+	}
+
+	switch metric := m.(type) {
+	case *PCPSingletonMetric:
+		return single(&metric.mutex, metric.pcpSingletonMetric)
+	case *PCPCounter:
+		return single(&metric.mutex, metric.pcpSingletonMetric)
+	case *PCPGauge:
+		return single(&metric.mutex, metric.pcpSingletonMetric)
+	case *PCPTimer:
+		metric.mutex.Lock()
+		defer metric.mutex.Unlock()
+		return map[string]interface{}{"": metric.val}
+	case *PCPInstanceMetric:
+		return multiple(&metric.mutex, metric.pcpInstanceMetric)
+	case *PCPCounterVector:
+		return multiple(&metric.mutex, metric.pcpInstanceMetric)
+	case *PCPGaugeVector:
+		return multiple(&metric.mutex, metric.pcpInstanceMetric)
+	case *PCPHistogram:
+		return multiple(&metric.mutex, metric.pcpInstanceMetric)
+	}
+
+	return nil
+}
+
+func sortedKeys(vals map[string]interface{}) []string {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// histogramFamily exposes a PCPHistogram as a summary, whose sum is approximated by
+// the mean multiplied by the count, as the histogram does not keep the sum of its values
+func histogramFamily(f *openMetricsFamily, h *PCPHistogram, factor float64) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	f.typ = "summary"
+
+	for _, q := range openMetricsQuantiles {
+		f.samples = append(f.samples, openMetricsSample{
+			labels: []string{"quantile", mmvdump.PrometheusFloat(q)},
+			value:  mmvdump.PrometheusFloat(float64(h.h.ValueAtQuantile(q*100)) * factor),
+		})
+	}
+
+	count := h.h.TotalCount()
+	f.samples = append(f.samples,
+		openMetricsSample{suffix: "_sum", value: mmvdump.PrometheusFloat(h.h.Mean() * float64(count) * factor)},
+		openMetricsSample{suffix: "_count", value: strconv.FormatInt(count, 10)},
+	)
+}
+
+// newOpenMetricsFamily maps a single PCPMetric registered under the passed name to a metric family
+func newOpenMetricsFamily(name string, m PCPMetric) *openMetricsFamily {
+	unit, factor := "", 1.0
+	if m.Type() != StringType {
+		unit, factor = openMetricsUnit(m.Unit())
+	}
+
+	name = mmvdump.PrometheusName(name)
+
+	if m.Semantics() == CounterSemantics {
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+
+	f := &openMetricsFamily{name: name, unit: unit, help: m.ShortDescription()}
+
+	if h, ok := m.(*PCPHistogram); ok {
+		histogramFamily(f, h, factor)
+		return f
+	}
+
+	switch {
+	case m.Type() == StringType:
+		f.typ = "info"
+	case m.Semantics() == CounterSemantics:
+		f.typ = "counter"
+	default:
+		f.typ = "gauge"
+	}
+
+	label := ""
+	if m.Indom() != nil {
+		label = mmvdump.PrometheusName(m.Indom().Name())
+	}
+
+	vals := metricValues(m)
+	for _, ins := range sortedKeys(vals) {
+		var s openMetricsSample
+
+		if ins != "" {
+			s.labels = []string{label, ins}
+		}
+
+		switch f.typ {
+		case "info":
+			s.suffix, s.value = "_info", "1"
+			s.labels = append(s.labels, "value", vals[ins].(string))
+		case "counter":
+			s.suffix, s.value = "_total", mmvdump.PrometheusValue(vals[ins], factor)
+		default:
+			s.value = mmvdump.PrometheusValue(vals[ins], factor)
+		}
+
+		f.samples = append(f.samples, s)
+	}
+
+	return f
+}
+
+// openMetricsFamilies generates families for all metrics in a registry,
+// sorted by name. Metrics whose names map to the same family name, like
+// a.b and a_b, are skipped after the first of them in registry order.
+func openMetricsFamilies(r *PCPRegistry) []*openMetricsFamily {
+	metrics := r.pcpMetrics()
+	families := make([]*openMetricsFamily, 0, len(metrics))
+	for _, m := range metrics {
+		families = append(families, newOpenMetricsFamily(r.metricName(m), m))
+	}
+
+	sort.SliceStable(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	ans := families[:0]
+	for _, f := range families {
+		if len(ans) == 0 || f.name != ans[len(ans)-1].name {
+			ans = append(ans, f)
+		}
+	}
+	return ans
+}
+
+var (
+	openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	prometheusEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func writeOpenMetricsSample(w *bufio.Writer, name string, s openMetricsSample) {
+	_, _ = w.WriteString(name)
+	_, _ = w.WriteString(s.suffix)
+
+	if len(s.labels) > 0 {
+		_ = w.WriteByte('{')
+		for i := 0; i < len(s.labels); i += 2 {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = w.WriteString(s.labels[i])
+			_, _ = w.WriteString(`="`)
+			_, _ = w.WriteString(openMetricsEscaper.Replace(s.labels[i+1]))
+			_ = w.WriteByte('"')
+		}
+		_ = w.WriteByte('}')
+	}
+
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(s.value)
+	_ = w.WriteByte('\n')
+}
+
+// writeExposition writes the passed families in either the OpenMetrics
+// or the Prometheus 0.0.4 text format
+func writeExposition(out io.Writer, families []*openMetricsFamily, openmetrics bool) error {
+	w := bufio.NewWriter(out)
+
+	for _, f := range families {
+		if openmetrics {
+			_, _ = w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+			if f.unit != "" {
+				_, _ = w.WriteString("# UNIT " + f.name + " " + f.unit + "\n")
+			}
+			if f.help != "" {
+				_, _ = w.WriteString("# HELP " + f.name + " " + openMetricsEscaper.Replace(f.help) + "\n")
+			}
+		} else {
+			// the 0.0.4 format describes the sample name rather than the family name
+			// and does not know about info metrics
+			name, typ := f.name, f.typ
+			switch typ {
+			case "counter":
+				name += "_total"
+			case "info":
+				name, typ = name+"_info", "gauge"
+			}
+
+			if f.help != "" {
+				_, _ = w.WriteString("# HELP " + name + " " + prometheusEscaper.Replace(f.help) + "\n")
+			}
+			_, _ = w.WriteString("# TYPE " + name + " " + typ + "\n")
+		}
+
+		for _, s := range f.samples {
+			writeOpenMetricsSample(w, f.name, s)
+		}
+	}
+
+	if openmetrics {
+		_, _ = w.WriteString("# EOF\n")
+	}
+
+	return w.Flush()
+}
+
+// WriteOpenMetrics writes the current state of all metrics in the registry to w
+// in the OpenMetrics text exposition format.
+//
+// Counters, gauges and histograms map to Prometheus counters, gauges and summaries,
+// instance domains map to a label named after the instance domain,
+// string metrics map to info metrics and values are scaled to base units
+// (seconds and bytes), with the unit appended to the metric name.
+//
+// Histograms do not keep the sum of their values, so the sum of their summaries
+// is approximated by their mean multiplied by their count. Metric names that map
+// to the same Prometheus name, like a.b and a_b, would make an invalid exposition,
+// so only the first of them in name order is written.
+func WriteOpenMetrics(w io.Writer, r *PCPRegistry) error {
+	return writeExposition(w, openMetricsFamilies(r), true)
+}
+
+// WritePrometheus writes the current state of all metrics in the registry to w
+// in the Prometheus 0.0.4 text exposition format, using the same mapping as
+// WriteOpenMetrics.
+func WritePrometheus(w io.Writer, r *PCPRegistry) error {
+	return writeExposition(w, openMetricsFamilies(r), false)
+}
+
+// openMetricsHandler implements http.Handler for a PCPRegistry
+type openMetricsHandler struct {
+	r *PCPRegistry
+}
+
+// NewOpenMetricsHandler returns a http.Handler that serves the current state of
+// all metrics in the passed registry.
+//
+// The OpenMetrics format is served to scrapers that accept it,
+// everyone else gets the Prometheus 0.0.4 text format.
+func NewOpenMetricsHandler(r *PCPRegistry) http.Handler {
+	return &openMetricsHandler{r}
+}
+
+func (h *openMetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openmetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+
+	if openmetrics {
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", PrometheusContentType)
+	}
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	_ = writeExposition(w, openMetricsFamilies(h.r), openmetrics)
+}
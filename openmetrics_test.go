@@ -0,0 +1,217 @@
+package speed
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+func TestOpenMetricsName(t *testing.T) {
+	cases := []struct{ name, expected string }{
+		{"http.requests", "http_requests"},
+		{"a_b.c-d", "a_b_c_d"},
+		{"1st.metric", "_st_metric"},
+		{"Acme Products", "Acme_Products"},
+	}
+
+	for _, c := range cases {
+		if n := mmvdump.PrometheusName(c.name); n != c.expected {
+			t.Errorf("expected %v to be converted to %v, got %v", c.name, c.expected, n)
+		}
+	}
+}
+
+func TestOpenMetricsUnit(t *testing.T) {
+	cases := []struct {
+		unit   MetricUnit
+		suffix string
+		factor float64
+	}{
+		{OneUnit, "", 1},
+		{ByteUnit, "bytes", 1},
+		{KilobyteUnit, "bytes", 1024},
+		{NanosecondUnit, "seconds", 1e-9},
+		{MillisecondUnit, "seconds", 1e-3},
+		{HourUnit, "seconds", 3600},
+		{MegabyteUnit.Time(SecondUnit, -1), "bytes_per_second", 1024 * 1024},
+		{OneUnit.Time(MillisecondUnit, -1), "per_second", 1e3},
+		{ByteUnit.Time(SecondUnit, 2), "", 1},
+	}
+
+	for _, c := range cases {
+		suffix, factor := openMetricsUnit(c.unit)
+		if suffix != c.suffix {
+			t.Errorf("expected suffix for %v to be %q, got %q", c.unit, c.suffix, suffix)
+		}
+
+		if factor != c.factor {
+			t.Errorf("expected factor for %v to be %v, got %v", c.unit, c.factor, factor)
+		}
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	r := NewPCPRegistry()
+
+	c, err := NewPCPCounter(5, "http.requests", "Number of Requests")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	g, err := NewPCPGaugeVector(map[string]float64{"a": 1.5, "b": 2}, "queue.depth")
+	if err != nil {
+		t.Fatalf("cannot create gauge vector, error: %v", err)
+	}
+
+	s, err := NewPCPSingletonMetric(1500, "request.latency", Int64Type, InstantSemantics, MillisecondUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	v, err := NewPCPSingletonMetric("4.0.0", "build.version", StringType, DiscreteSemantics, OneUnit, "with \"quotes\"")
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	for _, m := range []Metric{c, g, s, v} {
+		if err = r.AddMetric(m); err != nil {
+			t.Fatalf("cannot add metric, error: %v", err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err = WriteOpenMetrics(&b, r); err != nil {
+		t.Fatalf("cannot write metrics, error: %v", err)
+	}
+
+	expected := `# TYPE build_version info
+# HELP build_version with \"quotes\"
+build_version_info{value="4.0.0"} 1
+# TYPE http_requests counter
+# HELP http_requests Number of Requests
+http_requests_total 5
+# TYPE queue_depth gauge
+queue_depth{queue_depth_indom="a"} 1.5
+queue_depth{queue_depth_indom="b"} 2
+# TYPE request_latency_seconds gauge
+# UNIT request_latency_seconds seconds
+request_latency_seconds 1.5
+# EOF
+`
+
+	if b.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, b.String())
+	}
+
+	b.Reset()
+	if err = WritePrometheus(&b, r); err != nil {
+		t.Fatalf("cannot write metrics, error: %v", err)
+	}
+
+	for _, line := range []string{
+		"# TYPE http_requests_total counter\n",
+		"# HELP http_requests_total Number of Requests\n",
+		"# TYPE build_version_info gauge\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected prometheus output to contain %q, got\n%v", line, b.String())
+		}
+	}
+
+	if strings.Contains(b.String(), "# EOF") {
+		t.Error("expected prometheus output to not contain an EOF marker")
+	}
+}
+
+func TestOpenMetricsClashingNames(t *testing.T) {
+	r := NewPCPRegistry()
+
+	for i, name := range []string{"queue_depth", "queue.depth"} {
+		m, err := NewPCPGauge(float64(i), name)
+		if err != nil {
+			t.Fatalf("cannot create gauge, error: %v", err)
+		}
+
+		if err = r.AddMetric(m); err != nil {
+			t.Fatalf("cannot add metric, error: %v", err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := WriteOpenMetrics(&b, r); err != nil {
+		t.Fatalf("cannot write metrics, error: %v", err)
+	}
+
+	expected := "# TYPE queue_depth gauge\nqueue_depth 1\n# EOF\n"
+	if b.String() != expected {
+		t.Errorf("expected only the first of the clashing metrics\n%v\ngot\n%v", expected, b.String())
+	}
+}
+
+func TestOpenMetricsHistogram(t *testing.T) {
+	h, err := NewPCPHistogram("rpc.duration", 0, 1000, 3, MillisecondUnit)
+	if err != nil {
+		t.Fatalf("cannot create histogram, error: %v", err)
+	}
+
+	for i := int64(1); i <= 100; i++ {
+		h.MustRecord(i)
+	}
+
+	f := newOpenMetricsFamily(h.Name(), h)
+
+	if f.typ != "summary" {
+		t.Errorf("expected histogram to be exposed as a summary, got %v", f.typ)
+	}
+
+	if f.name != "rpc_duration_seconds" {
+		t.Errorf("expected name to be rpc_duration_seconds, got %v", f.name)
+	}
+
+	expected := map[string]string{"0.5": "0.05", "0.9": "0.09", "0.99": "0.099", "_sum": "5.05", "_count": "100"}
+	for _, s := range f.samples {
+		key := s.suffix
+		if key == "" {
+			key = s.labels[1]
+		}
+
+		if s.value != expected[key] {
+			t.Errorf("expected %v to be %v, got %v", key, expected[key], s.value)
+		}
+	}
+}
+
+func TestOpenMetricsHandler(t *testing.T) {
+	r := NewPCPRegistry()
+	if _, err := r.AddMetricByString("a.b", 1, Int32Type, InstantSemantics, OneUnit); err != nil {
+		t.Fatalf("cannot add metric, error: %v", err)
+	}
+
+	handler := NewOpenMetricsHandler(r)
+
+	for _, c := range []struct {
+		accept, contentType string
+	}{
+		{"application/openmetrics-text; version=1.0.0", OpenMetricsContentType},
+		{"text/plain", PrometheusContentType},
+		{"", PrometheusContentType},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", c.accept)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if ct := rec.Header().Get("Content-Type"); ct != c.contentType {
+			t.Errorf("expected content type %v for %q, got %v", c.contentType, c.accept, ct)
+		}
+
+		if !strings.Contains(rec.Body.String(), "a_b 1\n") {
+			t.Errorf("expected body to contain the metric, got\n%v", rec.Body.String())
+		}
+	}
+}
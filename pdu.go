@@ -0,0 +1,232 @@
+package speed
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// PDU types used between pmcd and daemon PMDAs
+//
+// see: https://github.com/performancecopilot/pcp/blob/main/src/include/pcp/libpcp.h
+const (
+	pduError        int32 = 0x7000
+	pduResult       int32 = 0x7001
+	pduProfile      int32 = 0x7002
+	pduFetch        int32 = 0x7003
+	pduDescReq      int32 = 0x7004
+	pduDesc         int32 = 0x7005
+	pduInstanceReq  int32 = 0x7006
+	pduInstance     int32 = 0x7007
+	pduTextReq      int32 = 0x7008
+	pduText         int32 = 0x7009
+	pduCreds        int32 = 0x700c
+	pduPMNSIDs      int32 = 0x700d
+	pduPMNSNames    int32 = 0x700e
+	pduPMNSChild    int32 = 0x700f
+	pduPMNSTraverse int32 = 0x7010
+	pduAttr         int32 = 0x7011
+)
+
+// PCP error codes sent back in error PDUs
+//
+// see: https://github.com/performancecopilot/pcp/blob/main/src/include/pcp/pmapi.h
+const (
+	pmErrText  int32 = -12345 - 4
+	pmErrName  int32 = -12345 - 12
+	pmErrPMID  int32 = -12345 - 13
+	pmErrIndom int32 = -12345 - 14
+	pmErrInst  int32 = -12345 - 15
+	pmErrIPC   int32 = -12345 - 21
+	pmErrNYI   int32 = -12345 - 8999
+)
+
+const (
+	pduHeaderLength = 12
+	pduMaxLength    = 1 << 20
+
+	pduVersion  = 2 // the PDU protocol version
+	credVersion = 1 // the credential type carrying the PDU protocol version
+
+	pmInNull    int32  = -1         // the instance of singleton values
+	pmIndomNull uint32 = 0xffffffff // the instance domain of singleton metrics
+
+	pmValInsitu = 0 // values stored in the value list
+	pmValDptr   = 1 // values stored in value blocks after the value lists
+
+	pmTextOneline = 1
+	pmTextHelp    = 2
+	pmTextPMID    = 4
+	pmTextIndom   = 8
+
+	pmnsLeafStatus    = 0
+	pmnsNonleafStatus = 1
+)
+
+// pduBuilder encodes the body of a PDU in 32 bit words in network byte order
+type pduBuilder struct {
+	typ int32
+	buf []byte
+}
+
+func newPDUBuilder(typ int32) *pduBuilder {
+	return &pduBuilder{typ: typ, buf: make([]byte, pduHeaderLength, 64)}
+}
+
+func (b *pduBuilder) uint32(v uint32) *pduBuilder {
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b.buf[len(b.buf)-4:], v)
+	return b
+}
+
+func (b *pduBuilder) int32(v int32) *pduBuilder { return b.uint32(uint32(v)) }
+
+// bytes appends raw bytes, padded with zeros to a whole number of words
+func (b *pduBuilder) bytes(p []byte) *pduBuilder {
+	b.buf = append(b.buf, p...)
+	for len(b.buf)%4 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	return b
+}
+
+// words returns the length of the PDU built so far in words, which is how value blocks are addressed
+func (b *pduBuilder) words() int32 { return int32(len(b.buf) / 4) }
+
+func (b *pduBuilder) write(w io.Writer, from int32) error {
+	binary.BigEndian.PutUint32(b.buf, uint32(len(b.buf)))
+	binary.BigEndian.PutUint32(b.buf[4:], uint32(b.typ))
+	binary.BigEndian.PutUint32(b.buf[8:], uint32(from))
+	_, err := w.Write(b.buf)
+	return err
+}
+
+// readPDU reads a single PDU, returning its type, sender and body
+func readPDU(r io.Reader) (typ, from int32, body []byte, err error) {
+	var h [pduHeaderLength]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(h[:])
+	if length < pduHeaderLength || length > pduMaxLength {
+		return 0, 0, nil, errors.Errorf("invalid PDU length %v", length)
+	}
+
+	body = make([]byte, length-pduHeaderLength)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, errors.Wrap(err, "incomplete PDU")
+	}
+
+	return int32(binary.BigEndian.Uint32(h[4:])), int32(binary.BigEndian.Uint32(h[8:])), body, nil
+}
+
+// pduDecoder decodes the body of a PDU, the first read past the end of the body
+// sets err and every read after that returns zero values
+type pduDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *pduDecoder) uint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+
+	if len(d.b) < 4 {
+		d.err = errors.New("truncated PDU")
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *pduDecoder) int32() int32 { return int32(d.uint32()) }
+
+// bytes reads n bytes followed by the padding to a whole number of words
+func (d *pduDecoder) bytes(n int32) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	padded := (int64(n) + 3) &^ 3
+	if n < 0 || int64(len(d.b)) < padded {
+		d.err = errors.New("truncated PDU")
+		return nil
+	}
+
+	p := d.b[:n]
+	d.b = d.b[padded:]
+	return p
+}
+
+// names decodes a PMNS name list, along with the statuses of the names if the list has them
+func (d *pduDecoder) names() ([]string, []int32) {
+	d.int32() // nstrbytes
+	numstatus, numnames := d.int32(), d.int32()
+
+	if numnames < 0 || int64(numnames)*4 > int64(len(d.b)) {
+		d.err = errors.New("truncated PDU")
+		return nil, nil
+	}
+
+	names := make([]string, 0, numnames)
+	var status []int32
+	for i := int32(0); i < numnames && d.err == nil; i++ {
+		if numstatus > 0 {
+			status = append(status, d.int32())
+		}
+		names = append(names, string(d.bytes(d.int32())))
+	}
+
+	return names, status
+}
+
+// names appends a PMNS name list, with statuses if status is not nil
+func (b *pduBuilder) names(names []string, status []int32) *pduBuilder {
+	strbytes := 0
+	for _, n := range names {
+		strbytes += len(n) + 1
+	}
+
+	b.int32(int32(strbytes)).int32(int32(len(status))).int32(int32(len(names)))
+	for i, n := range names {
+		if status != nil {
+			b.int32(status[i])
+		}
+		b.int32(int32(len(n))).bytes([]byte(n))
+	}
+
+	return b
+}
+
+// valueBlock encodes a value that does not fit in a value list as a pmValueBlock,
+// a word holding the type and length of the block followed by the value
+func valueBlock(t MetricType, val interface{}) []byte {
+	var data []byte
+
+	switch v := val.(type) {
+	case int64:
+		data = make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(v))
+	case uint64:
+		data = make([]byte, 8)
+		binary.BigEndian.PutUint64(data, v)
+	case float64:
+		data = make([]byte, 8)
+		binary.BigEndian.PutUint64(data, math.Float64bits(v))
+	case float32:
+		data = make([]byte, 4)
+		binary.BigEndian.PutUint32(data, math.Float32bits(v))
+	case string:
+		data = append([]byte(v), 0)
+	}
+
+	block := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(block, uint32(t)<<24|uint32(4+len(data)))
+	return append(block, data...)
+}
@@ -0,0 +1,271 @@
+package speed
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PMIERule is a pmie rule on a metric that fires when its value crosses a threshold.
+// pmie converts counters to rates, so rules on counters check the rate per second.
+type PMIERule struct {
+	Instance  string  // the instance to check, like "max" for histograms, or every instance if empty
+	Below     bool    // fire when the value drops below the threshold rather than when it rises above it
+	Threshold float64 // the threshold
+	Action    string  // the pmie action, by default a message is logged to syslog
+}
+
+// PCPConfigGenerator generates pmlogger configuration and pmie rules for the metrics in a registry,
+// named the way pmdammv exposes the MMV file written by a PCPClient, so "requests" in a client
+// named "app" is "mmv.app.requests", or "mmv.requests" if the client has the NoPrefixFlag set.
+//
+// All metrics are logged at the same interval unless another one is set for them, and rules are
+// only generated for metrics they are set for. For counters and histograms, rules checking their
+// rate and maximum are written commented out with a placeholder threshold, as templates to start from.
+type PCPConfigGenerator struct {
+	r      *PCPRegistry
+	prefix string
+
+	interval  time.Duration
+	intervals map[string]time.Duration
+	rules     map[string][]PMIERule
+}
+
+// DefaultLoggingInterval is the interval metrics are logged at by default
+const DefaultLoggingInterval = time.Minute
+
+// NewPCPConfigGenerator creates a generator for the metrics in the passed registry,
+// as written by a client with the passed name and flag
+func NewPCPConfigGenerator(name string, flag MMVFlag, registry *PCPRegistry) (*PCPConfigGenerator, error) {
+	if registry == nil {
+		return nil, errors.New("the registry cannot be nil")
+	}
+
+	prefix, err := mmvPrefix(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPConfigGenerator{
+		r:         registry,
+		prefix:    prefix,
+		interval:  DefaultLoggingInterval,
+		intervals: make(map[string]time.Duration),
+		rules:     make(map[string][]PMIERule),
+	}, nil
+}
+
+// mmvPrefix returns the prefix pmdammv adds to the names of metrics in an MMV file
+// written by a client with the passed name and flag
+func mmvPrefix(name string, flag MMVFlag) (string, error) {
+	if flag&NoPrefixFlag != 0 {
+		return "mmv.", nil
+	}
+
+	if name == "" || strings.ContainsAny(name, ". \t\n") {
+		return "", errors.Errorf("invalid client name %q", name)
+	}
+
+	return "mmv." + name + ".", nil
+}
+
+// PCPName returns the name of a metric in the registry as PCP knows it
+func (g *PCPConfigGenerator) PCPName(metric string) string { return g.prefix + metric }
+
+// SetInterval sets the interval metrics are logged at, and pmie checks rules at
+func (g *PCPConfigGenerator) SetInterval(d time.Duration) error {
+	if d < time.Millisecond {
+		return errors.Errorf("interval %v is shorter than a millisecond", d)
+	}
+
+	g.interval = d
+	return nil
+}
+
+// SetMetricInterval sets the interval a metric is logged at
+func (g *PCPConfigGenerator) SetMetricInterval(metric string, d time.Duration) error {
+	if _, err := g.metric(metric); err != nil {
+		return err
+	}
+
+	if d < time.Millisecond {
+		return errors.Errorf("interval %v is shorter than a millisecond", d)
+	}
+
+	g.intervals[metric] = d
+	return nil
+}
+
+// SetRules sets the pmie rules for a metric, replacing any set before
+func (g *PCPConfigGenerator) SetRules(metric string, rules ...PMIERule) error {
+	m, err := g.metric(metric)
+	if err != nil {
+		return err
+	}
+
+	if m.Type() == StringType {
+		return errors.Errorf("metric %v has string values, which cannot be checked against a threshold", metric)
+	}
+
+	for _, rule := range rules {
+		if rule.Instance == "" {
+			continue
+		}
+
+		if m.Indom() == nil || !m.Indom().HasInstance(rule.Instance) {
+			return errors.Errorf("metric %v has no instance %v", metric, rule.Instance)
+		}
+	}
+
+	g.rules[metric] = rules
+	return nil
+}
+
+func (g *PCPConfigGenerator) metric(name string) (PCPMetric, error) {
+	m, ok := g.r.pcpMetric(name)
+	if !ok {
+		return nil, errors.Errorf("metric %v is not registered", name)
+	}
+	return m, nil
+}
+
+// WritePMLogger writes a pmlogger configuration logging all metrics, with a log
+// statement for every interval
+func (g *PCPConfigGenerator) WritePMLogger(w io.Writer) error {
+	byInterval := make(map[time.Duration][]string)
+	for _, m := range g.r.pcpMetrics() {
+		d, ok := g.intervals[g.r.metricName(m)]
+		if !ok {
+			d = g.interval
+		}
+		byInterval[d] = append(byInterval[d], g.PCPName(g.r.metricName(m)))
+	}
+
+	intervals := make([]time.Duration, 0, len(byInterval))
+	for d := range byInterval {
+		intervals = append(intervals, d)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("# pmlogger configuration generated by speed\n")
+
+	for _, d := range intervals {
+		_, _ = bw.WriteString("\nlog mandatory on every " + pmloggerInterval(d) + " {\n")
+		for _, name := range byInterval[d] {
+			_, _ = bw.WriteString("\t" + name + "\n")
+		}
+		_, _ = bw.WriteString("}\n")
+	}
+
+	return bw.Flush()
+}
+
+// pmloggerInterval formats an interval the way pmlogger configuration takes it
+func pmloggerInterval(d time.Duration) string {
+	if d%time.Second != 0 {
+		return strconv.FormatInt(int64(d/time.Millisecond), 10) + " msec"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + " sec"
+}
+
+// WritePMIE writes pmie rules for the metrics they are set for, and commented out
+// templates for the rates of counters and the maximums of histograms without rules
+func (g *PCPConfigGenerator) WritePMIE(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("// pmie rules generated by speed\n\n")
+	_, _ = bw.WriteString("delta = " + pmloggerInterval(g.interval) + ";\n")
+
+	for _, m := range g.r.pcpMetrics() {
+		rules, ok := g.rules[g.r.metricName(m)]
+		if ok {
+			if len(rules) > 0 {
+				_, _ = bw.WriteString("\n")
+			}
+
+			for i, rule := range rules {
+				_, _ = bw.WriteString(g.pmieRule(m, i, rule, strconv.FormatFloat(rule.Threshold, 'g', -1, 64)) + "\n")
+			}
+			continue
+		}
+
+		var template *PMIERule
+		switch {
+		case m.Type() == StringType:
+		case isHistogram(m):
+			template = &PMIERule{Instance: "max"}
+		case m.Semantics() == CounterSemantics:
+			template = &PMIERule{}
+		}
+
+		if template != nil {
+			_, _ = bw.WriteString("\n// set a threshold for " + g.PCPName(g.r.metricName(m)) + " to enable\n")
+			_, _ = bw.WriteString("// " + g.pmieRule(m, 0, *template, "THRESHOLD") + "\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+func isHistogram(m PCPMetric) bool {
+	_, ok := m.(*PCPHistogram)
+	return ok
+}
+
+// pmieRule formats a rule, named after the metric, the instance and the direction it checks,
+// with the index of the rule added to the name of all rules but the first
+func (g *PCPConfigGenerator) pmieRule(m PCPMetric, i int, rule PMIERule, threshold string) string {
+	name := g.PCPName(g.r.metricName(m))
+
+	op, direction := ">", "above"
+	if rule.Below {
+		op, direction = "<", "below"
+	}
+
+	ruleName := pmieIdentifier(g.r.metricName(m))
+	if rule.Instance != "" {
+		ruleName += "_" + pmieIdentifier(rule.Instance)
+	}
+	ruleName += "_" + direction
+	if i > 0 {
+		ruleName += "_" + strconv.Itoa(i)
+	}
+
+	var predicate string
+	switch {
+	case rule.Instance != "":
+		predicate = name + " #'" + rule.Instance + "' " + op + " " + threshold
+	case m.Indom() != nil:
+		predicate = "some_inst ( " + name + " " + op + " " + threshold + " )"
+	default:
+		predicate = name + " " + op + " " + threshold
+	}
+
+	action := rule.Action
+	if action == "" {
+		what := name
+		if m.Indom() != nil && rule.Instance == "" {
+			what += " %i"
+		} else if rule.Instance != "" {
+			what += " " + rule.Instance
+		}
+		action = `syslog "` + what + " " + direction + " " + threshold + `: %v"`
+	}
+
+	return ruleName + " = " + predicate + " -> " + action + ";"
+}
+
+// pmieIdentifier replaces everything but letters, digits and underscores in a name
+func pmieIdentifier(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,132 @@
+package speed
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPCPConfigGenerator(t *testing.T) {
+	r := NewPCPRegistry()
+
+	requests, err := NewPCPCounter(0, "http.requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queues, err := NewPCPGaugeVector(map[string]float64{"high": 0, "low": 0}, "queue.length")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latency, err := NewPCPHistogram("http.latency", 0, 1000, 3, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := NewPCPSingletonMetric("1.0", "version", StringType, DiscreteSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Metric{requests, queues, latency, version} {
+		if err = r.AddMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = NewPCPConfigGenerator("", 0, r); err == nil {
+		t.Error("expected an error for an empty client name")
+	}
+
+	g, err := NewPCPConfigGenerator("", NoPrefixFlag, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := g.PCPName("version"); n != "mmv.version" {
+		t.Errorf("expected mmv.version without a prefix, got %v", n)
+	}
+
+	g, err = NewPCPConfigGenerator("app", ProcessFlag, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.SetInterval(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.SetMetricInterval("version", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.SetMetricInterval("queue.length", 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.SetRules("queue.length", PMIERule{Threshold: 100}, PMIERule{Instance: "low", Below: true, Threshold: 0.5, Action: `shell "page"`}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.SetRules("http.latency"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{
+		g.SetMetricInterval("missing", time.Second),
+		g.SetMetricInterval("version", time.Microsecond),
+		g.SetInterval(0),
+		g.SetRules("missing"),
+		g.SetRules("version", PMIERule{Threshold: 1}),
+		g.SetRules("queue.length", PMIERule{Instance: "medium"}),
+		g.SetRules("http.requests", PMIERule{Instance: "max"}),
+	} {
+		if err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	var b bytes.Buffer
+	if err = g.WritePMLogger(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# pmlogger configuration generated by speed
+
+log mandatory on every 500 msec {
+	mmv.app.queue.length
+}
+
+log mandatory on every 10 sec {
+	mmv.app.http.latency
+	mmv.app.http.requests
+}
+
+log mandatory on every 3600 sec {
+	mmv.app.version
+}
+`
+	if b.String() != expected {
+		t.Errorf("expected pmlogger configuration\n%v\ngot\n%v", expected, b.String())
+	}
+
+	b.Reset()
+	if err = g.WritePMIE(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `// pmie rules generated by speed
+
+delta = 10 sec;
+
+// set a threshold for mmv.app.http.requests to enable
+// http_requests_above = mmv.app.http.requests > THRESHOLD -> syslog "mmv.app.http.requests above THRESHOLD: %v";
+
+queue_length_above = some_inst ( mmv.app.queue.length > 100 ) -> syslog "mmv.app.queue.length %i above 100: %v";
+queue_length_low_below_1 = mmv.app.queue.length #'low' < 0.5 -> shell "page";
+`
+	if b.String() != expected {
+		t.Errorf("expected pmie rules\n%v\ngot\n%v", expected, b.String())
+	}
+}
@@ -0,0 +1,539 @@
+package speed
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PCPDomainBitLength is the bit length of a PMDA domain number
+const PCPDomainBitLength = 9
+
+// PMDA serves the metrics in a PCPRegistry to pmcd directly as a performance metrics domain agent,
+// speaking the PDU protocol pmcd uses with daemon PMDAs, instead of going through an MMV file
+// and pmdammv.
+//
+// The PMDA has its own domain number and serves its metrics under its own name as a dynamic namespace,
+// so metrics registered as "requests" are available as "name.requests" rather than "mmv.name.requests".
+// The root namespace of pmcd has to mount the namespace of the PMDA with an entry like
+//
+//	name	domain:*:*
+//
+// which is written by WritePMNS. A PMDA serving over stdin and stdout is installed in pmcd.conf with
+//
+//	name	domain	pipe	binary	/path/to/app
+//
+// and one listening on a Unix socket with
+//
+//	name	domain	socket	unix	/path/to/socket
+//
+// Values are read from the registry on every fetch, so metrics can be updated the same way as with
+// a PCPClient. Fetches return all instances of a metric, instance profiles are ignored.
+type PMDA struct {
+	name    string
+	domain  uint32
+	cluster uint32
+	r       *PCPRegistry
+}
+
+// NewPMDA creates a PMDA serving the metrics in the passed registry under the passed name and domain number
+func NewPMDA(name string, domain int, registry *PCPRegistry) (*PMDA, error) {
+	if name == "" || strings.ContainsAny(name, ". \t\n") {
+		return nil, errors.Errorf("invalid PMDA name %q", name)
+	}
+
+	if domain <= 0 || domain >= 1<<PCPDomainBitLength {
+		return nil, errors.Errorf("domain %v is not between 1 and %v", domain, 1<<PCPDomainBitLength-1)
+	}
+
+	if registry == nil {
+		return nil, errors.New("the registry cannot be nil")
+	}
+
+	return &PMDA{
+		name:    name,
+		domain:  uint32(domain),
+		cluster: hash(name, PCPClusterIDBitLength),
+		r:       registry,
+	}, nil
+}
+
+// Registry returns the registry served by the PMDA
+func (p *PMDA) Registry() Registry { return p.r }
+
+// pmid returns the PMID of a metric, made of the domain, cluster and item
+func (p *PMDA) pmid(m PCPMetric) uint32 {
+	return p.domain<<(PCPClusterIDBitLength+PCPMetricItemBitLength) | p.cluster<<PCPMetricItemBitLength | p.r.metricID(m)
+}
+
+// indom returns the PCP instance domain identifier of an instance domain
+func (p *PMDA) indom(indom *PCPInstanceDomain) uint32 {
+	if indom == nil {
+		return pmIndomNull
+	}
+	return p.domain<<PCPInstanceDomainBitLength | p.r.indomID(indom)
+}
+
+// instanceID returns the PCP instance identifier of an instance, which cannot be negative
+func instanceID(i *pcpInstance) int32 { return int32(i.id & math.MaxInt32) }
+
+// metricByPMID looks up a metric in the registry by its PMID
+func (p *PMDA) metricByPMID(pmid uint32) (PCPMetric, bool) {
+	for _, m := range p.r.pcpMetrics() {
+		if p.pmid(m) == pmid {
+			return m, true
+		}
+	}
+
+	return nil, false
+}
+
+// indomByID looks up an instance domain by its PCP identifier, including the
+// instance domains of metrics that were not registered separately, like histograms
+func (p *PMDA) indomByID(id uint32) (*PCPInstanceDomain, bool) {
+	for _, m := range p.r.pcpMetrics() {
+		if indom := m.Indom(); indom != nil && p.indom(indom) == id {
+			return indom, true
+		}
+	}
+
+	for _, indom := range p.r.pcpInstanceDomains() {
+		if p.indom(indom) == id {
+			return indom, true
+		}
+	}
+
+	return nil, false
+}
+
+// names returns all metrics by their names in the namespace of the PMDA
+func (p *PMDA) names() map[string]PCPMetric {
+	metrics := p.r.pcpMetrics()
+
+	ans := make(map[string]PCPMetric, len(metrics))
+	for _, m := range metrics {
+		ans[p.name+"."+p.r.metricName(m)] = m
+	}
+	return ans
+}
+
+// sortedInstances returns the instances of an instance domain sorted by their identifiers
+func sortedInstances(indom *PCPInstanceDomain) []*pcpInstance {
+	ans := make([]*pcpInstance, 0, len(indom.instances))
+	for _, i := range indom.instances {
+		ans = append(ans, i)
+	}
+	sort.Slice(ans, func(i, j int) bool { return instanceID(ans[i]) < instanceID(ans[j]) })
+	return ans
+}
+
+// WritePMNS writes the entry mounting the namespace of the PMDA in the root namespace of pmcd
+func (p *PMDA) WritePMNS(w io.Writer) error {
+	_, err := io.WriteString(w, p.name+"\t"+strconv.FormatUint(uint64(p.domain), 10)+":*:*\n")
+	return err
+}
+
+// ServeStdio serves pmcd over stdin and stdout, for PMDAs started by pmcd as pipe agents
+func (p *PMDA) ServeStdio() error {
+	return p.Serve(os.Stdin, os.Stdout)
+}
+
+// ListenAndServe listens on a Unix socket at path and serves every connection pmcd makes to it,
+// for PMDAs installed as socket agents. An existing socket at path is replaced.
+func (p *PMDA) ListenAndServe(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = l.Close() }()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			_ = p.Serve(conn, conn)
+			_ = conn.Close()
+		}()
+	}
+}
+
+// Serve sends the version handshake to pmcd and then answers requests read from in
+// on out until in is closed, which returns nil, or a PDU cannot be read or written.
+func (p *PMDA) Serve(in io.Reader, out io.Writer) error {
+	creds := newPDUBuilder(pduCreds).int32(1).uint32(credVersion<<24 | pduVersion<<16)
+	if err := creds.write(out, int32(os.Getpid())); err != nil {
+		return errors.Wrap(err, "could not send the version handshake")
+	}
+
+	for {
+		typ, _, body, err := readPDU(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply := p.handle(typ, &pduDecoder{b: body})
+		if reply == nil {
+			continue
+		}
+
+		if err = reply.write(out, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers a single request, returning nil for requests that have no reply
+func (p *PMDA) handle(typ int32, d *pduDecoder) *pduBuilder {
+	var (
+		reply *pduBuilder
+		code  int32
+	)
+
+	switch typ {
+	case pduProfile, pduAttr, pduCreds, pduError:
+		return nil
+	case pduDescReq:
+		reply, code = p.desc(d)
+	case pduInstanceReq:
+		reply, code = p.instance(d)
+	case pduFetch:
+		reply, code = p.fetch(d)
+	case pduTextReq:
+		reply, code = p.text(d)
+	case pduPMNSNames:
+		reply, code = p.pmnsIDs(d)
+	case pduPMNSIDs:
+		reply, code = p.pmnsNames(d)
+	case pduPMNSChild:
+		reply, code = p.pmnsChildren(d)
+	case pduPMNSTraverse:
+		reply, code = p.pmnsTraverse(d)
+	default:
+		code = pmErrNYI
+	}
+
+	if d.err != nil {
+		code = pmErrIPC
+	}
+
+	if code != 0 {
+		return newPDUBuilder(pduError).int32(code)
+	}
+
+	return reply
+}
+
+func (p *PMDA) desc(d *pduDecoder) (*pduBuilder, int32) {
+	pmid := d.uint32()
+
+	m, ok := p.metricByPMID(pmid)
+	if !ok {
+		return nil, pmErrPMID
+	}
+
+	var unit uint32
+	if m.Unit() != nil {
+		unit = m.Unit().PMAPI()
+	}
+
+	return newPDUBuilder(pduDesc).
+		uint32(pmid).
+		int32(int32(m.Type())).
+		uint32(p.indom(m.Indom())).
+		int32(int32(m.Semantics())).
+		uint32(unit), 0
+}
+
+func (p *PMDA) instance(d *pduDecoder) (*pduBuilder, int32) {
+	id := d.uint32()
+	d.int32() // seconds
+	d.int32() // microseconds
+	inst := d.int32()
+	name := string(d.bytes(d.int32()))
+
+	indom, ok := p.indomByID(id)
+	if !ok {
+		return nil, pmErrIndom
+	}
+
+	var instances []*pcpInstance
+	for _, i := range sortedInstances(indom) {
+		if (inst == pmInNull && (name == "" || name == i.name)) || inst == instanceID(i) {
+			instances = append(instances, i)
+		}
+	}
+
+	if len(instances) == 0 && (inst != pmInNull || name != "") {
+		return nil, pmErrInst
+	}
+
+	reply := newPDUBuilder(pduInstance).uint32(id).int32(int32(len(instances)))
+	for _, i := range instances {
+		reply.int32(instanceID(i)).int32(int32(len(i.name))).bytes([]byte(i.name))
+	}
+
+	return reply, 0
+}
+
+// fetchValue is a single value in a result
+type fetchValue struct {
+	inst  int32
+	val   interface{}
+	block []byte
+}
+
+func (p *PMDA) fetch(d *pduDecoder) (*pduBuilder, int32) {
+	d.int32() // context
+	d.int32() // seconds
+	d.int32() // microseconds
+	n := d.int32()
+
+	if n < 0 || int64(n)*4 > int64(len(d.b)) {
+		return nil, pmErrIPC
+	}
+
+	pmids := make([]uint32, n)
+	for i := range pmids {
+		pmids[i] = d.uint32()
+	}
+
+	now := time.Now()
+	reply := newPDUBuilder(pduResult).int32(int32(now.Unix())).int32(int32(now.Nanosecond() / 1000)).int32(n)
+
+	// value lists come first, followed by the value blocks they point to,
+	// so block offsets are patched in once the lists are written
+	type patch struct {
+		at    int
+		block []byte
+	}
+	var patches []patch
+
+	for _, pmid := range pmids {
+		m, ok := p.metricByPMID(pmid)
+		if !ok {
+			reply.uint32(pmid).int32(pmErrPMID)
+			continue
+		}
+
+		vals := p.values(m)
+
+		reply.uint32(pmid).int32(int32(len(vals)))
+		if len(vals) == 0 {
+			continue
+		}
+
+		switch m.Type() {
+		case Int32Type, Uint32Type:
+			reply.int32(pmValInsitu)
+			for _, v := range vals {
+				reply.int32(v.inst)
+				if u, ok := v.val.(uint32); ok {
+					reply.uint32(u)
+				} else {
+					reply.int32(v.val.(int32))
+				}
+			}
+		default:
+			reply.int32(pmValDptr)
+			for _, v := range vals {
+				reply.int32(v.inst)
+				patches = append(patches, patch{len(reply.buf), valueBlock(m.Type(), v.val)})
+				reply.int32(0)
+			}
+		}
+	}
+
+	for _, pt := range patches {
+		off := reply.words()
+		reply.bytes(pt.block)
+		binary.BigEndian.PutUint32(reply.buf[pt.at:], uint32(off))
+	}
+
+	return reply, 0
+}
+
+// values returns the current values of a metric by instance identifier
+func (p *PMDA) values(m PCPMetric) []fetchValue {
+	vals := metricValues(m)
+
+	indom := m.Indom()
+	if indom == nil {
+		if v, ok := vals[""]; ok {
+			return []fetchValue{{inst: pmInNull, val: v}}
+		}
+		return nil
+	}
+
+	ans := make([]fetchValue, 0, len(vals))
+	for _, i := range sortedInstances(indom) {
+		if v, ok := vals[i.name]; ok {
+			ans = append(ans, fetchValue{inst: instanceID(i), val: v})
+		}
+	}
+	return ans
+}
+
+func (p *PMDA) text(d *pduDecoder) (*pduBuilder, int32) {
+	ident, typ := d.uint32(), d.int32()
+
+	var text string
+
+	switch {
+	case typ&pmTextPMID != 0:
+		m, ok := p.metricByPMID(ident)
+		if !ok {
+			return nil, pmErrPMID
+		}
+
+		if typ&pmTextOneline != 0 {
+			text = m.ShortDescription()
+		} else {
+			text = m.LongDescription()
+		}
+	case typ&pmTextIndom != 0:
+		indom, ok := p.indomByID(ident)
+		if !ok {
+			return nil, pmErrIndom
+		}
+
+		if typ&pmTextOneline != 0 {
+			text = indom.shortDescription
+		} else {
+			text = indom.longDescription
+		}
+	}
+
+	if text == "" {
+		return nil, pmErrText
+	}
+
+	return newPDUBuilder(pduText).uint32(ident).int32(int32(len(text))).bytes([]byte(text)), 0
+}
+
+// pmnsIDs looks up the PMIDs of the names in a request
+func (p *PMDA) pmnsIDs(d *pduDecoder) (*pduBuilder, int32) {
+	names, _ := d.names()
+	all := p.names()
+
+	reply := newPDUBuilder(pduPMNSIDs).int32(0).int32(int32(len(names)))
+	for _, n := range names {
+		m, ok := all[n]
+		if !ok {
+			return nil, pmErrName
+		}
+		reply.uint32(p.pmid(m))
+	}
+
+	return reply, 0
+}
+
+// pmnsNames looks up the names of the PMIDs in a request
+func (p *PMDA) pmnsNames(d *pduDecoder) (*pduBuilder, int32) {
+	d.int32() // status
+	n := d.int32()
+
+	if n < 0 || int64(n)*4 > int64(len(d.b)) {
+		return nil, pmErrIPC
+	}
+
+	all := p.names()
+
+	var names []string
+	for i := int32(0); i < n; i++ {
+		pmid := d.uint32()
+
+		found := false
+		for name, m := range all {
+			if p.pmid(m) == pmid {
+				names, found = append(names, name), true
+			}
+		}
+
+		if !found {
+			return nil, pmErrPMID
+		}
+	}
+
+	sort.Strings(names)
+	return newPDUBuilder(pduPMNSNames).names(names, nil), 0
+}
+
+// pmnsPath reads the name in a PMNS child or traverse request
+func pmnsPath(d *pduDecoder) string {
+	d.int32() // subtype
+	return string(d.bytes(d.int32()))
+}
+
+// pmnsChildren returns the next component of every name under the name in a request,
+// along with whether the component is a leaf
+func (p *PMDA) pmnsChildren(d *pduDecoder) (*pduBuilder, int32) {
+	prefix := pmnsPath(d) + "."
+
+	status := make(map[string]int32)
+	for name := range p.names() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		child := strings.TrimPrefix(name, prefix)
+		if i := strings.IndexByte(child, '.'); i >= 0 {
+			status[child[:i]] = pmnsNonleafStatus
+		} else if _, ok := status[child]; !ok {
+			status[child] = pmnsLeafStatus
+		}
+	}
+
+	if len(status) == 0 {
+		return nil, pmErrName
+	}
+
+	children := make([]string, 0, len(status))
+	for c := range status {
+		children = append(children, c)
+	}
+	sort.Strings(children)
+
+	statuses := make([]int32, len(children))
+	for i, c := range children {
+		statuses[i] = status[c]
+	}
+
+	return newPDUBuilder(pduPMNSNames).names(children, statuses), 0
+}
+
+// pmnsTraverse returns the names of all metrics under the name in a request
+func (p *PMDA) pmnsTraverse(d *pduDecoder) (*pduBuilder, int32) {
+	path := pmnsPath(d)
+
+	var names []string
+	for name := range p.names() {
+		if name == path || strings.HasPrefix(name, path+".") {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, pmErrName
+	}
+
+	sort.Strings(names)
+	return newPDUBuilder(pduPMNSNames).names(names, nil), 0
+}
@@ -0,0 +1,445 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakePMCD talks to a PMDA the way pmcd does
+type fakePMCD struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newFakePMCD(t *testing.T, p *PMDA) *fakePMCD {
+	client, server := net.Pipe()
+
+	go func() {
+		_ = p.Serve(server, server)
+		_ = server.Close()
+	}()
+
+	f := &fakePMCD{t, client}
+
+	typ, _, body := f.read()
+	if typ != pduCreds {
+		t.Fatalf("expected the version handshake first, got a PDU of type 0x%x", typ)
+	}
+
+	d := &pduDecoder{b: body}
+	if n, cred := d.int32(), d.uint32(); n != 1 || cred != 0x01020000 {
+		t.Fatalf("expected a single version credential for PDU version 2, got %v credentials, 0x%x", n, cred)
+	}
+
+	return f
+}
+
+func (f *fakePMCD) read() (int32, int32, []byte) {
+	f.t.Helper()
+
+	if err := f.conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		f.t.Fatal(err)
+	}
+
+	typ, from, body, err := readPDU(f.conn)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	return typ, from, body
+}
+
+// request sends a request and returns the type of the reply along with a decoder for its body
+func (f *fakePMCD) request(b *pduBuilder) (int32, *pduDecoder) {
+	f.t.Helper()
+
+	if err := b.write(f.conn, 42); err != nil {
+		f.t.Fatal(err)
+	}
+
+	typ, _, body := f.read()
+	return typ, &pduDecoder{b: body}
+}
+
+// expectError sends a request and checks that it is answered with an error
+func (f *fakePMCD) expectError(b *pduBuilder, code int32) {
+	f.t.Helper()
+
+	typ, d := f.request(b)
+	if typ != pduError {
+		f.t.Fatalf("expected an error, got a PDU of type 0x%x", typ)
+	}
+
+	if c := d.int32(); c != code {
+		f.t.Errorf("expected error %v, got %v", code, c)
+	}
+}
+
+func (f *fakePMCD) names(b *pduBuilder) ([]string, []int32) {
+	f.t.Helper()
+
+	typ, d := f.request(b)
+	if typ != pduPMNSNames {
+		f.t.Fatalf("expected a name list, got a PDU of type 0x%x", typ)
+	}
+
+	names, status := d.names()
+	if d.err != nil {
+		f.t.Fatal(d.err)
+	}
+
+	return names, status
+}
+
+// fetch fetches a single metric, returning its values by instance
+func (f *fakePMCD) fetch(pmid uint32) map[int32]interface{} {
+	f.t.Helper()
+
+	typ, d := f.request(newPDUBuilder(pduFetch).int32(0).int32(0).int32(0).int32(1).uint32(pmid))
+	if typ != pduResult {
+		f.t.Fatalf("expected a result, got a PDU of type 0x%x", typ)
+	}
+
+	pdu := append(make([]byte, pduHeaderLength), d.b...)
+
+	d.int32()
+	d.int32()
+	if n := d.int32(); n != 1 {
+		f.t.Fatalf("expected a result for a single metric, got %v", n)
+	}
+
+	if id := d.uint32(); id != pmid {
+		f.t.Errorf("expected a result for 0x%x, got 0x%x", pmid, id)
+	}
+
+	numval := d.int32()
+	if numval < 0 {
+		f.t.Fatalf("expected values, got error %v", numval)
+	}
+
+	ans := make(map[int32]interface{})
+	if numval == 0 {
+		return ans
+	}
+
+	valfmt := d.int32()
+	for i := int32(0); i < numval; i++ {
+		inst, val := d.int32(), d.uint32()
+
+		if valfmt == pmValInsitu {
+			ans[inst] = val
+			continue
+		}
+
+		block := pdu[val*4:]
+		header := binary.BigEndian.Uint32(block)
+		data := block[4 : header&0xffffff]
+
+		switch MetricType(header >> 24) {
+		case Int64Type:
+			ans[inst] = int64(binary.BigEndian.Uint64(data))
+		case Uint64Type:
+			ans[inst] = binary.BigEndian.Uint64(data)
+		case DoubleType:
+			ans[inst] = math.Float64frombits(binary.BigEndian.Uint64(data))
+		case FloatType:
+			ans[inst] = math.Float32frombits(binary.BigEndian.Uint32(data))
+		case StringType:
+			ans[inst] = string(bytes.TrimRight(data, "\x00"))
+		}
+	}
+
+	if d.err != nil {
+		f.t.Fatal(d.err)
+	}
+
+	return ans
+}
+
+func TestNewPMDA(t *testing.T) {
+	r := NewPCPRegistry()
+
+	for _, c := range []struct {
+		name   string
+		domain int
+	}{
+		{"", 100}, {"a.b", 100}, {"app", 0}, {"app", 512},
+	} {
+		if _, err := NewPMDA(c.name, c.domain, r); err == nil {
+			t.Errorf("expected an error for name %q and domain %v", c.name, c.domain)
+		}
+	}
+
+	if _, err := NewPMDA("app", 100, nil); err == nil {
+		t.Error("expected an error for a nil registry")
+	}
+
+	p, err := NewPMDA("app", 100, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err = p.WritePMNS(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != "app\t100:*:*\n" {
+		t.Errorf("unexpected PMNS entry %q", b.String())
+	}
+}
+
+func TestPMDA(t *testing.T) {
+	r := NewPCPRegistry()
+
+	requests, err := NewPCPCounter(5, "http.requests", "number of requests", "all requests served")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indom, err := NewPCPInstanceDomain("queues", []string{"high", "low"}, "queues by priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queues, err := NewPCPInstanceMetric(Instances{"high": 3.5, "low": 1.25}, "queue.length", indom, DoubleType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := NewPCPSingletonMetric("1.0", "version", StringType, DiscreteSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workers, err := NewPCPSingletonMetric(int32(-4), "workers", Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Metric{requests, queues, version, workers} {
+		if err = r.AddMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := NewPMDA("app", 100, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFakePMCD(t, p)
+	defer func() { _ = f.conn.Close() }()
+
+	path := func(name string) *pduBuilder {
+		return newPDUBuilder(pduPMNSTraverse).int32(0).int32(int32(len(name))).bytes([]byte(name))
+	}
+
+	names, _ := f.names(path("app"))
+	expected := []string{"app.http.requests", "app.queue.length", "app.version", "app.workers"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the namespace to be %v, got %v", expected, names)
+	}
+
+	names, _ = f.names(path("app.http"))
+	if !reflect.DeepEqual(names, []string{"app.http.requests"}) {
+		t.Errorf("unexpected names under app.http: %v", names)
+	}
+
+	f.expectError(path("app.missing"), pmErrName)
+
+	child := newPDUBuilder(pduPMNSChild).int32(1).int32(3).bytes([]byte("app"))
+	names, status := f.names(child)
+	if !reflect.DeepEqual(names, []string{"http", "queue", "version", "workers"}) || !reflect.DeepEqual(status, []int32{1, 1, 0, 0}) {
+		t.Errorf("unexpected children of app: %v, %v", names, status)
+	}
+
+	typ, d := f.request(newPDUBuilder(pduPMNSNames).names([]string{"app.http.requests", "app.queue.length"}, nil))
+	if typ != pduPMNSIDs {
+		t.Fatalf("expected a PMID list, got a PDU of type 0x%x", typ)
+	}
+
+	sts, n, requestsID, queuesID := d.int32(), d.int32(), d.uint32(), d.uint32()
+	if sts != 0 || n != 2 || requestsID != p.pmid(requests) || queuesID != p.pmid(queues) {
+		t.Errorf("unexpected PMID list %v %v 0x%x 0x%x", sts, n, requestsID, queuesID)
+	}
+
+	if requestsID>>22 != 100 {
+		t.Errorf("expected the PMID 0x%x to have domain 100", requestsID)
+	}
+
+	f.expectError(newPDUBuilder(pduPMNSNames).names([]string{"app.missing"}, nil), pmErrName)
+
+	names, _ = f.names(newPDUBuilder(pduPMNSIDs).int32(0).int32(1).uint32(queuesID))
+	if !reflect.DeepEqual(names, []string{"app.queue.length"}) {
+		t.Errorf("expected the name of 0x%x to be app.queue.length, got %v", queuesID, names)
+	}
+
+	typ, d = f.request(newPDUBuilder(pduDescReq).uint32(queuesID))
+	if typ != pduDesc {
+		t.Fatalf("expected a desc, got a PDU of type 0x%x", typ)
+	}
+
+	pmid, mt, id, sem, unit := d.uint32(), d.int32(), d.uint32(), d.int32(), d.uint32()
+	if pmid != queuesID || MetricType(mt) != DoubleType || id != p.indom(indom) || MetricSemantics(sem) != InstantSemantics || unit != OneUnit.PMAPI() {
+		t.Errorf("unexpected desc 0x%x %v 0x%x %v 0x%x", pmid, mt, id, sem, unit)
+	}
+
+	typ, d = f.request(newPDUBuilder(pduDescReq).uint32(requestsID))
+	if typ != pduDesc {
+		t.Fatalf("expected a desc, got a PDU of type 0x%x", typ)
+	}
+
+	d.uint32() // pmid
+	if mt, id, sem := d.int32(), d.uint32(), d.int32(); MetricType(mt) != Int64Type || id != pmIndomNull || MetricSemantics(sem) != CounterSemantics {
+		t.Errorf("unexpected desc for http.requests %v 0x%x %v", mt, id, sem)
+	}
+
+	f.expectError(newPDUBuilder(pduDescReq).uint32(requestsID+1), pmErrPMID)
+
+	instances := func(inst int32, name string) map[int32]string {
+		typ, d := f.request(newPDUBuilder(pduInstanceReq).uint32(p.indom(indom)).int32(0).int32(0).int32(inst).int32(int32(len(name))).bytes([]byte(name)))
+		if typ != pduInstance {
+			t.Fatalf("expected instances, got a PDU of type 0x%x", typ)
+		}
+
+		if id := d.uint32(); id != p.indom(indom) {
+			t.Errorf("expected instances of 0x%x, got 0x%x", p.indom(indom), id)
+		}
+
+		ans := make(map[int32]string)
+		for i, n := int32(0), d.int32(); i < n; i++ {
+			inst := d.int32()
+			ans[inst] = string(d.bytes(d.int32()))
+		}
+
+		if d.err != nil {
+			t.Fatal(d.err)
+		}
+
+		return ans
+	}
+
+	high, low := instanceID(indom.instances["high"]), instanceID(indom.instances["low"])
+
+	if all := instances(pmInNull, ""); !reflect.DeepEqual(all, map[int32]string{high: "high", low: "low"}) {
+		t.Errorf("unexpected instances %v", all)
+	}
+
+	if byName := instances(pmInNull, "low"); !reflect.DeepEqual(byName, map[int32]string{low: "low"}) {
+		t.Errorf("unexpected instances looked up by name %v", byName)
+	}
+
+	if byID := instances(high, ""); !reflect.DeepEqual(byID, map[int32]string{high: "high"}) {
+		t.Errorf("unexpected instances looked up by id %v", byID)
+	}
+
+	f.expectError(newPDUBuilder(pduInstanceReq).uint32(p.indom(indom)).int32(0).int32(0).int32(pmInNull).int32(4).bytes([]byte("none")), pmErrInst)
+	f.expectError(newPDUBuilder(pduInstanceReq).uint32(p.indom(indom)+1).int32(0).int32(0).int32(pmInNull).int32(0), pmErrIndom)
+
+	// a profile has no reply, the next request has to be answered in order
+	if err = newPDUBuilder(pduProfile).int32(0).int32(0).int32(0).int32(0).write(f.conn, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	requests.Up()
+	queues.MustSetInstance(7.5, "low")
+
+	for _, c := range []struct {
+		m        PCPMetric
+		expected map[int32]interface{}
+	}{
+		{requests, map[int32]interface{}{pmInNull: int64(6)}},
+		{queues, map[int32]interface{}{high: 3.5, low: 7.5}},
+		{version, map[int32]interface{}{pmInNull: "1.0"}},
+		{workers, map[int32]interface{}{pmInNull: uint32(0xfffffffc)}},
+	} {
+		if vals := f.fetch(p.pmid(c.m)); !reflect.DeepEqual(vals, c.expected) {
+			t.Errorf("expected %v to be %v, got %v", c.m.Name(), c.expected, vals)
+		}
+	}
+
+	typ, d = f.request(newPDUBuilder(pduFetch).int32(0).int32(0).int32(0).int32(1).uint32(requestsID + 1))
+	if typ != pduResult {
+		t.Fatalf("expected a result, got a PDU of type 0x%x", typ)
+	}
+
+	d.int32() // seconds
+	d.int32() // microseconds
+	if n, id, numval := d.int32(), d.uint32(), d.int32(); n != 1 || id != requestsID+1 || numval != pmErrPMID {
+		t.Errorf("expected the result for an unknown PMID to hold an error, got %v 0x%x %v", n, id, numval)
+	}
+
+	text := func(ident uint32, typ int32) string {
+		rtyp, d := f.request(newPDUBuilder(pduTextReq).uint32(ident).int32(typ))
+		if rtyp != pduText {
+			t.Fatalf("expected text, got a PDU of type 0x%x", rtyp)
+		}
+
+		if d.uint32() != ident {
+			t.Error("expected the text to be for the requested identifier")
+		}
+
+		return string(d.bytes(d.int32()))
+	}
+
+	if s := text(requestsID, pmTextPMID|pmTextOneline); s != "number of requests" {
+		t.Errorf("unexpected one line help text %q", s)
+	}
+
+	if s := text(requestsID, pmTextPMID|pmTextHelp); s != "all requests served" {
+		t.Errorf("unexpected help text %q", s)
+	}
+
+	if s := text(p.indom(indom), pmTextIndom|pmTextOneline); s != "queues by priority" {
+		t.Errorf("unexpected instance domain help text %q", s)
+	}
+
+	f.expectError(newPDUBuilder(pduTextReq).uint32(queuesID).int32(pmTextPMID|pmTextOneline), pmErrText)
+	f.expectError(newPDUBuilder(pduTextReq).uint32(queuesID), pmErrIPC)
+	f.expectError(newPDUBuilder(0x7fff), pmErrNYI)
+}
+
+func TestPMDAListenAndServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed-pmda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	p, err := NewPMDA("app", 100, NewPCPRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(dir, "app.socket")
+	go func() { _ = p.ListenAndServe(sock) }()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	f := &fakePMCD{t, conn}
+	if typ, _, _ := f.read(); typ != pduCreds {
+		t.Fatalf("expected the version handshake first, got a PDU of type 0x%x", typ)
+	}
+
+	f.expectError(newPDUBuilder(pduDescReq).uint32(1), pmErrPMID)
+}
@@ -0,0 +1,407 @@
+// Package prombridge mirrors metrics collected through prometheus/client_golang
+// into PCP using speed.
+//
+// A Bridge gathers a prometheus.Gatherer, like a prometheus.Registry, and publishes
+// every metric family it gets through a speed PCPClient, so libraries that only know
+// how to expose prometheus.Collectors can be read by PCP without any code changes.
+//
+// The mapping is as follows:
+//
+// - counters become DoubleType metrics with CounterSemantics
+//
+// - gauges and untyped metrics become DoubleType metrics with InstantSemantics
+//
+// - label sets become instances of an instance domain named `<metric>.indom`,
+// with instance names of the form `label1=value1,label2=value2`
+//
+// - histograms become `<metric>.bucket`, a counter with one instance per label set and
+// upper bound, along with `<metric>.sum` and `<metric>.count`
+//
+// - summaries become `<metric>.quantile`, an instant metric with one instance per
+// label set and quantile, along with `<metric>.sum` and `<metric>.count`
+//
+// - `<metric>.sum` and `<metric>.count` of unlabelled histograms and summaries are singletons
+//
+// Gauge histograms are not mirrored, as the version of the prometheus data model the bridge
+// is built against has no such type, and metric families of any other type are skipped.
+//
+// Since instance domains cannot change while a client is active, the bridge restarts its
+// client with a new layout whenever a metric family or label value appears or goes away.
+//
+// A Bridge implements speed.Collector, so it can be run periodically by a speed.Sampler:
+//
+// ```go
+// b, err := prombridge.New("app", prometheus.DefaultGatherer)
+// ...
+// s, err := speed.NewSampler(time.Second, b)
+// ...
+// s.MustStart()
+// ```
+package prombridge
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/performancecopilot/speed/v4"
+)
+
+// series is a single PCP metric mirrored from a prometheus metric family
+type series struct {
+	name      string
+	help      string
+	sem       speed.MetricSemantics
+	unit      speed.MetricUnit
+	singleton bool
+	vals      map[string]float64 // values by instance, singletons use the empty string
+}
+
+// instances returns the sorted instance names of the series
+func (s *series) instances() []string {
+	ans := make([]string, 0, len(s.vals))
+	for k := range s.vals {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// Bridge mirrors the metric families gathered from a prometheus.Gatherer
+// into a PCPClient.
+type Bridge struct {
+	mutex sync.Mutex
+
+	name string
+	g    prometheus.Gatherer
+
+	client  *speed.PCPClient
+	layout  string
+	metrics map[string]speed.Metric
+	skipped error // the first error from series that could not be mirrored by the current client
+}
+
+// New creates a new Bridge that will publish metrics gathered from g
+// through a PCPClient with the passed name.
+func New(name string, g prometheus.Gatherer) (*Bridge, error) {
+	if g == nil {
+		return nil, errors.New("a gatherer is required to create a bridge")
+	}
+
+	// validate the name before the first collection tries to use it
+	if _, err := speed.NewPCPClient(name); err != nil {
+		return nil, err
+	}
+
+	return &Bridge{name: name, g: g}, nil
+}
+
+// Collect gathers all metric families once and mirrors them into PCP,
+// restarting the underlying client if the set of metrics or instances changed.
+// Series that cannot be mirrored are skipped, and the error for the first of them is
+// returned by every Collect until the client is restarted without it.
+func (b *Bridge) Collect() error {
+	mfs, gerr := b.g.Gather()
+	if gerr != nil && len(mfs) == 0 {
+		return errors.Wrap(gerr, "cannot gather prometheus metrics")
+	}
+
+	ss := convert(mfs)
+	l := layout(ss)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var err error
+	if b.client == nil || l != b.layout {
+		err = b.rebuild(ss, l)
+	} else {
+		err = b.update(ss)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// series that could not be mirrored are missing for as long as the client runs
+	if b.skipped != nil {
+		return b.skipped
+	}
+
+	if gerr != nil {
+		return errors.Wrap(gerr, "partially gathered prometheus metrics")
+	}
+
+	return nil
+}
+
+// Stop stops the underlying client, a later Collect starts a new one.
+func (b *Bridge) Stop() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.client == nil {
+		return errors.New("trying to stop a bridge that has not collected anything")
+	}
+
+	err := b.client.Stop()
+	b.client, b.layout, b.metrics, b.skipped = nil, "", nil, nil
+	return err
+}
+
+func (b *Bridge) rebuild(ss []*series, l string) error {
+	client, err := speed.NewPCPClient(b.name)
+	if err != nil {
+		return err
+	}
+
+	metrics := make(map[string]speed.Metric, len(ss))
+	var first error
+
+	for _, s := range ss {
+		m, err := newMetric(s)
+		if err == nil {
+			err = client.Register(m)
+		}
+
+		if err != nil {
+			if first == nil {
+				first = errors.Wrapf(err, "cannot mirror %v", s.name)
+			}
+			continue
+		}
+
+		metrics[s.name] = m
+	}
+
+	// the old client has to go first, as both write to the same location
+	if b.client != nil {
+		if err := b.client.Stop(); err != nil {
+			return err
+		}
+		b.client = nil
+	}
+
+	if err := client.Start(); err != nil {
+		return err
+	}
+
+	b.client, b.layout, b.metrics, b.skipped = client, l, metrics, first
+	return nil
+}
+
+func newMetric(s *series) (speed.Metric, error) {
+	if s.singleton {
+		return speed.NewPCPSingletonMetric(s.vals[""], s.name, speed.DoubleType, s.sem, s.unit, s.help)
+	}
+
+	instances := s.instances()
+
+	indom, err := speed.NewPCPInstanceDomain(s.name+".indom", instances)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make(speed.Instances, len(s.vals))
+	for k, v := range s.vals {
+		vals[k] = v
+	}
+
+	return speed.NewPCPInstanceMetric(vals, s.name, indom, speed.DoubleType, s.sem, s.unit, s.help)
+}
+
+func (b *Bridge) update(ss []*series) error {
+	for _, s := range ss {
+		switch m := b.metrics[s.name].(type) {
+		case *speed.PCPSingletonMetric:
+			if err := m.Set(s.vals[""]); err != nil {
+				return err
+			}
+		case *speed.PCPInstanceMetric:
+			for ins, v := range s.vals {
+				if err := m.SetInstance(v, ins); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// layout generates a signature of everything that requires a client restart on change
+func layout(ss []*series) string {
+	var b strings.Builder
+	for _, s := range ss {
+		b.WriteString(s.name)
+		b.WriteByte(0)
+		b.WriteString(s.help)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(int(s.sem)))
+		b.WriteByte(0)
+		b.WriteString(strconv.FormatUint(uint64(s.unit.PMAPI()), 10))
+		b.WriteByte(0)
+		b.WriteString(strconv.FormatBool(s.singleton))
+		for _, i := range s.instances() {
+			b.WriteByte(0)
+			b.WriteString(i)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// metricName converts a prometheus metric name to a PCP metric name
+func metricName(name string) string {
+	return strings.Replace(name, ":", "_", -1)
+}
+
+// unit infers a unit from the base unit suffix of a prometheus metric name
+func unit(name string) speed.MetricUnit {
+	name = strings.TrimSuffix(name, "_total")
+
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return speed.SecondUnit
+	case strings.HasSuffix(name, "_bytes"):
+		return speed.ByteUnit
+	}
+
+	return speed.OneUnit
+}
+
+// help truncates a help string to fit in a PCP string, without splitting a character
+func help(h string) string {
+	if len(h) < speed.StringLength {
+		return h
+	}
+
+	n := speed.StringLength - 1
+	for n > 0 && !utf8.RuneStart(h[n]) {
+		n--
+	}
+	return h[:n]
+}
+
+// instanceName generates a PCP instance name for a prometheus label set
+// and optionally, an extra label like a quantile or a bucket bound
+func instanceName(labels []*dto.LabelPair, extra ...string) string {
+	parts := make([]string, 0, len(labels)+1)
+	for _, l := range labels {
+		parts = append(parts, l.GetName()+"="+l.GetValue())
+	}
+
+	if len(extra) == 2 {
+		parts = append(parts, extra[0]+"="+extra[1])
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// unlabelled checks if a metric family contains a single metric without any labels
+func unlabelled(mf *dto.MetricFamily) bool {
+	ms := mf.GetMetric()
+	return len(ms) == 1 && len(ms[0].GetLabel()) == 0
+}
+
+// convert maps gathered metric families to series, sorted by name
+func convert(mfs []*dto.MetricFamily) []*series {
+	var ss []*series
+
+	newSeries := func(name, h string, sem speed.MetricSemantics, u speed.MetricUnit) *series {
+		s := &series{name: name, help: help(h), sem: sem, unit: u, vals: make(map[string]float64)}
+		ss = append(ss, s)
+		return s
+	}
+
+	for _, mf := range mfs {
+		name, u := metricName(mf.GetName()), unit(mf.GetName())
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			sem := speed.InstantSemantics
+			if mf.GetType() == dto.MetricType_COUNTER {
+				sem = speed.CounterSemantics
+			}
+
+			s := newSeries(name, mf.GetHelp(), sem, u)
+
+			s.singleton = unlabelled(mf)
+
+			for _, m := range mf.GetMetric() {
+				var v float64
+				switch mf.GetType() {
+				case dto.MetricType_COUNTER:
+					v = m.GetCounter().GetValue()
+				case dto.MetricType_GAUGE:
+					v = m.GetGauge().GetValue()
+				default:
+					v = m.GetUntyped().GetValue()
+				}
+
+				if s.singleton {
+					s.vals[""] = v
+				} else {
+					s.vals[instanceName(m.GetLabel())] = v
+				}
+			}
+		case dto.MetricType_SUMMARY:
+			q := newSeries(name+".quantile", mf.GetHelp(), speed.InstantSemantics, u)
+			sum := newSeries(name+".sum", mf.GetHelp(), speed.CounterSemantics, u)
+			count := newSeries(name+".count", mf.GetHelp(), speed.CounterSemantics, speed.OneUnit)
+			sum.singleton, count.singleton = unlabelled(mf), unlabelled(mf)
+
+			for _, m := range mf.GetMetric() {
+				ins := instanceName(m.GetLabel())
+				sum.vals[ins] = m.GetSummary().GetSampleSum()
+				count.vals[ins] = float64(m.GetSummary().GetSampleCount())
+
+				for _, qv := range m.GetSummary().GetQuantile() {
+					q.vals[instanceName(m.GetLabel(), "quantile", formatFloat(qv.GetQuantile()))] = qv.GetValue()
+				}
+			}
+		case dto.MetricType_HISTOGRAM:
+			bucket := newSeries(name+".bucket", mf.GetHelp(), speed.CounterSemantics, speed.OneUnit)
+			sum := newSeries(name+".sum", mf.GetHelp(), speed.CounterSemantics, u)
+			count := newSeries(name+".count", mf.GetHelp(), speed.CounterSemantics, speed.OneUnit)
+			sum.singleton, count.singleton = unlabelled(mf), unlabelled(mf)
+
+			for _, m := range mf.GetMetric() {
+				h := m.GetHistogram()
+				ins := instanceName(m.GetLabel())
+				sum.vals[ins] = h.GetSampleSum()
+				count.vals[ins] = float64(h.GetSampleCount())
+
+				for _, bv := range h.GetBucket() {
+					bucket.vals[instanceName(m.GetLabel(), "le", formatFloat(bv.GetUpperBound()))] = float64(bv.GetCumulativeCount())
+				}
+
+				// the +Inf bucket is implicit in the prometheus data model
+				bucket.vals[instanceName(m.GetLabel(), "le", "+Inf")] = float64(h.GetSampleCount())
+			}
+		}
+	}
+
+	// an instance metric needs at least one instance
+	n := 0
+	for _, s := range ss {
+		if s.singleton || len(s.vals) > 0 {
+			ss[n] = s
+			n++
+		}
+	}
+	ss = ss[:n]
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].name < ss[j].name })
+	return ss
+}
@@ -0,0 +1,276 @@
+package prombridge
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/performancecopilot/speed/v4"
+)
+
+func instanceVal(b *Bridge, metric, instance string, t *testing.T) float64 {
+	m, ok := b.metrics[metric].(*speed.PCPInstanceMetric)
+	if !ok {
+		t.Fatalf("expected %v to be mirrored as an instance metric, got %T", metric, b.metrics[metric])
+	}
+
+	v, err := m.ValInstance(instance)
+	if err != nil {
+		t.Fatalf("cannot get value of %v[%v], error: %v", metric, instance, err)
+	}
+
+	return v.(float64)
+}
+
+func TestBridge(t *testing.T) {
+	r := prometheus.NewRegistry()
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of requests",
+	}, []string{"code", "method"})
+
+	temperature := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "temperature",
+		Help: "Current temperature",
+	})
+
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rpc_duration_seconds",
+		Help:    "RPC latency",
+		Buckets: []float64{0.1, 1},
+	})
+
+	r.MustRegister(requests, temperature, latency)
+
+	requests.WithLabelValues("200", "GET").Add(3)
+	temperature.Set(21.5)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+
+	b, err := New("prombridge_test", r)
+	if err != nil {
+		t.Fatalf("cannot create bridge, error: %v", err)
+	}
+
+	if err = b.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+	defer func() { _ = b.Stop() }()
+
+	if v := instanceVal(b, "http_requests_total", "code=200,method=GET", t); v != 3 {
+		t.Errorf("expected http_requests_total to be 3, got %v", v)
+	}
+
+	if m := b.metrics["http_requests_total"]; m.Semantics() != speed.CounterSemantics {
+		t.Errorf("expected counter semantics for a counter, got %v", m.Semantics())
+	}
+
+	g, ok := b.metrics["temperature"].(*speed.PCPSingletonMetric)
+	if !ok {
+		t.Fatalf("expected an unlabelled gauge to be mirrored as a singleton metric, got %T", b.metrics["temperature"])
+	}
+
+	if g.Val() != 21.5 || g.Semantics() != speed.InstantSemantics {
+		t.Errorf("expected temperature to be an instant 21.5, got %v with %v", g.Val(), g.Semantics())
+	}
+
+	for le, expected := range map[string]float64{"le=0.1": 1, "le=1": 2, "le=+Inf": 2} {
+		if v := instanceVal(b, "rpc_duration_seconds.bucket", le, t); v != expected {
+			t.Errorf("expected bucket %v to be %v, got %v", le, expected, v)
+		}
+	}
+
+	if u := b.metrics["rpc_duration_seconds.sum"].Unit(); u != speed.SecondUnit {
+		t.Errorf("expected the histogram sum to be in seconds, got %v", u)
+	}
+
+	// updating a value keeps the client
+
+	client := b.client
+	requests.WithLabelValues("200", "GET").Inc()
+
+	if err = b.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	if b.client != client {
+		t.Error("expected the client to be kept when only values change")
+	}
+
+	if v := instanceVal(b, "http_requests_total", "code=200,method=GET", t); v != 4 {
+		t.Errorf("expected http_requests_total to be 4, got %v", v)
+	}
+
+	// a new label value restarts the client
+
+	requests.WithLabelValues("500", "POST").Inc()
+
+	if err = b.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	if b.client == client {
+		t.Error("expected the client to be restarted when a new label value appears")
+	}
+
+	if v := instanceVal(b, "http_requests_total", "code=500,method=POST", t); v != 1 {
+		t.Errorf("expected the new instance to be 1, got %v", v)
+	}
+
+	if v := instanceVal(b, "http_requests_total", "code=200,method=GET", t); v != 4 {
+		t.Errorf("expected the existing instance to keep its value, got %v", v)
+	}
+}
+
+func TestBridgeSummary(t *testing.T) {
+	r := prometheus.NewRegistry()
+
+	s := prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "response_size_bytes",
+		Help:       "Response sizes",
+		Objectives: map[float64]float64{0.5: 0.05},
+	})
+	r.MustRegister(s)
+
+	for i := 1; i <= 3; i++ {
+		s.Observe(float64(i * 100))
+	}
+
+	b, err := New("prombridge_test", r)
+	if err != nil {
+		t.Fatalf("cannot create bridge, error: %v", err)
+	}
+
+	if err = b.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+	defer func() { _ = b.Stop() }()
+
+	if v := instanceVal(b, "response_size_bytes.quantile", "quantile=0.5", t); v != 200 {
+		t.Errorf("expected the median to be 200, got %v", v)
+	}
+
+	count, ok := b.metrics["response_size_bytes.count"].(*speed.PCPSingletonMetric)
+	if !ok {
+		t.Fatalf("expected the count of an unlabelled summary to be a singleton, got %T", b.metrics["response_size_bytes.count"])
+	}
+
+	if count.Val() != float64(3) {
+		t.Errorf("expected count to be 3, got %v", count.Val())
+	}
+
+	if u := b.metrics["response_size_bytes.sum"].Unit(); u != speed.ByteUnit {
+		t.Errorf("expected the summary sum to be in bytes, got %v", u)
+	}
+}
+
+func TestBridgeHistogram(t *testing.T) {
+	r := prometheus.NewRegistry()
+
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "request_duration_seconds",
+		Help:    "Request durations",
+		Buckets: []float64{1},
+	})
+
+	hv := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "query_duration_seconds",
+		Help:    "Query durations",
+		Buckets: []float64{1},
+	}, []string{"db"})
+
+	r.MustRegister(h, hv)
+
+	h.Observe(0.5)
+	h.Observe(2)
+	hv.WithLabelValues("users").Observe(0.5)
+
+	b, err := New("prombridge_test", r)
+	if err != nil {
+		t.Fatalf("cannot create bridge, error: %v", err)
+	}
+
+	if err = b.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+	defer func() { _ = b.Stop() }()
+
+	count, ok := b.metrics["request_duration_seconds.count"].(*speed.PCPSingletonMetric)
+	if !ok {
+		t.Fatalf("expected the count of an unlabelled histogram to be a singleton, got %T", b.metrics["request_duration_seconds.count"])
+	}
+
+	if count.Val() != float64(2) {
+		t.Errorf("expected count to be 2, got %v", count.Val())
+	}
+
+	sum, ok := b.metrics["request_duration_seconds.sum"].(*speed.PCPSingletonMetric)
+	if !ok {
+		t.Fatalf("expected the sum of an unlabelled histogram to be a singleton, got %T", b.metrics["request_duration_seconds.sum"])
+	}
+
+	if sum.Val() != 2.5 {
+		t.Errorf("expected sum to be 2.5, got %v", sum.Val())
+	}
+
+	if v := instanceVal(b, "query_duration_seconds.count", "db=users", t); v != 1 {
+		t.Errorf("expected the count of a labelled histogram to be an instance metric with 1, got %v", v)
+	}
+}
+
+func TestBridgeSkipped(t *testing.T) {
+	r := prometheus.NewRegistry()
+
+	temperature := prometheus.NewGauge(prometheus.GaugeOpts{Name: "temperature"})
+	long := prometheus.NewGauge(prometheus.GaugeOpts{Name: strings.Repeat("a", speed.StringLength+1)})
+	r.MustRegister(temperature, long)
+
+	b, err := New("prombridge_skipped_test", r)
+	if err != nil {
+		t.Fatalf("cannot create bridge, error: %v", err)
+	}
+
+	if err = b.Collect(); err == nil || !strings.Contains(err.Error(), "cannot mirror") {
+		t.Fatalf("expected an error for a series that cannot be mirrored, got %v", err)
+	}
+	defer func() { _ = b.Stop() }()
+
+	temperature.Set(3)
+	client := b.client
+
+	if err = b.Collect(); err == nil {
+		t.Error("expected the skipped series to be reported on every collection")
+	}
+
+	if b.client != client {
+		t.Error("expected the client to be kept when only values change")
+	}
+
+	if m, ok := b.metrics["temperature"].(*speed.PCPSingletonMetric); !ok || m.Val() != float64(3) {
+		t.Errorf("expected the other series to be mirrored, got %v", b.metrics["temperature"])
+	}
+}
+
+func TestHelp(t *testing.T) {
+	h := help(strings.Repeat("a", speed.StringLength-2) + "é")
+	if len(h) != speed.StringLength-2 || !utf8.ValidString(h) {
+		t.Errorf("expected help to be truncated before a character that does not fit, got %v bytes", len(h))
+	}
+
+	if h := help("short"); h != "short" {
+		t.Errorf("expected a short help to be kept, got %v", h)
+	}
+}
+
+func TestNewBridge(t *testing.T) {
+	if _, err := New("test", nil); err == nil {
+		t.Error("expected creating a bridge without a gatherer to fail")
+	}
+
+	if _, err := New("a/b", prometheus.NewRegistry()); err == nil {
+		t.Error("expected creating a bridge with an invalid name to fail")
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/performancecopilot/speed/v4/prombridge
+
+go 1.13
+
+require (
+	github.com/performancecopilot/speed/v4 v4.0.0
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
+)
+
+replace github.com/performancecopilot/speed/v4 => ../
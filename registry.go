@@ -3,6 +3,8 @@ package speed
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -44,14 +46,61 @@ type Registry interface {
 	AddMetricByString(name string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) (Metric, error)
 }
 
+// Introspector lists the metrics and instance domains in a registry,
+// so bridges, exporters and admin endpoints do not need to know how it stores them
+type Introspector interface {
+	// returns all metrics in the current registry sorted by the names they are registered under
+	Metrics() []Metric
+
+	// returns the metric of the passed name and whether it is present
+	Metric(name string) (Metric, bool)
+
+	// returns all instance domains in the current registry sorted by the names they are registered under
+	InstanceDomains() []InstanceDomain
+
+	// visits all instance domains and then all metrics in the current registry in name order
+	Visit(Visitor) error
+}
+
+// Visitor visits the instance domains and metrics in a registry along with the names they are
+// registered under, which include the prefix of the view they were added through. Visiting stops
+// at the first error a Visitor returns, which is returned by Introspector.Visit.
+type Visitor interface {
+	VisitInstanceDomain(name string, indom InstanceDomain) error
+	VisitMetric(name string, m Metric) error
+}
+
+// MetricVisitorFunc is an adapter allowing ordinary functions to be used as Visitors of metrics,
+// skipping instance domains.
+type MetricVisitorFunc func(name string, m Metric) error
+
+// VisitInstanceDomain does nothing.
+func (f MetricVisitorFunc) VisitInstanceDomain(string, InstanceDomain) error { return nil }
+
+// VisitMetric calls f.
+func (f MetricVisitorFunc) VisitMetric(name string, m Metric) error { return f(name, m) }
+
+// PrefixingRegistry is a Registry that can return views of itself adding everything under a prefix
+type PrefixingRegistry interface {
+	Registry
+
+	// returns a view of the registry adding everything under the passed prefix
+	WithPrefix(prefix string) (PrefixingRegistry, error)
+}
+
 // PCPRegistry implements a registry for PCP as the client
 type PCPRegistry struct {
 	instanceDomains map[string]*PCPInstanceDomain // a cache for instanceDomains
 	metrics         map[string]PCPMetric          // a cache for metrics
 
+	// the names metrics and instance domains added through prefixed views are registered under,
+	// which differ from their own names
+	names map[interface{}]string
+
 	// locks
 	indomlock   sync.RWMutex
 	metricslock sync.RWMutex
+	nameslock   sync.RWMutex
 
 	// offsets
 	instanceoffset int
@@ -74,6 +123,7 @@ func NewPCPRegistry() *PCPRegistry {
 	return &PCPRegistry{
 		instanceDomains: make(map[string]*PCPInstanceDomain),
 		metrics:         make(map[string]PCPMetric),
+		names:           make(map[interface{}]string),
 	}
 }
 
@@ -131,9 +181,197 @@ func (r *PCPRegistry) HasMetric(name string) bool {
 	return present
 }
 
+// pcpMetrics returns a snapshot of the metrics in the registry sorted by name
+func (r *PCPRegistry) pcpMetrics() []PCPMetric { return r.pcpMetricsUnder("") }
+
+// pcpMetricsUnder returns a snapshot of the metrics registered under the passed prefix sorted by name
+func (r *PCPRegistry) pcpMetricsUnder(prefix string) []PCPMetric {
+	r.metricslock.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ans := make([]PCPMetric, len(names))
+	for i, name := range names {
+		ans[i] = r.metrics[name]
+	}
+	r.metricslock.RUnlock()
+
+	return ans
+}
+
+// pcpInstanceDomains returns a snapshot of the instance domains in the registry sorted by name
+func (r *PCPRegistry) pcpInstanceDomains() []*PCPInstanceDomain { return r.pcpInstanceDomainsUnder("") }
+
+// pcpInstanceDomainsUnder returns a snapshot of the instance domains registered under the passed prefix
+// sorted by name
+func (r *PCPRegistry) pcpInstanceDomainsUnder(prefix string) []*PCPInstanceDomain {
+	r.indomlock.RLock()
+	names := make([]string, 0, len(r.instanceDomains))
+	for name := range r.instanceDomains {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ans := make([]*PCPInstanceDomain, len(names))
+	for i, name := range names {
+		ans[i] = r.instanceDomains[name]
+	}
+	r.indomlock.RUnlock()
+
+	return ans
+}
+
+// registeredName returns the name a metric or instance domain added through a prefixed view
+// is registered under, and false for everything registered under its own name
+func (r *PCPRegistry) registeredName(v interface{}) (string, bool) {
+	r.nameslock.RLock()
+	defer r.nameslock.RUnlock()
+
+	name, ok := r.names[v]
+	return name, ok
+}
+
+// setName records the name a metric or instance domain is registered under
+// if it differs from its own
+func (r *PCPRegistry) setName(v interface{}, own, name string) {
+	if own == name {
+		return
+	}
+
+	r.nameslock.Lock()
+	defer r.nameslock.Unlock()
+
+	r.names[v] = name
+}
+
+// metricName returns the name a metric is registered under
+func (r *PCPRegistry) metricName(m PCPMetric) string {
+	if name, ok := r.registeredName(m); ok {
+		return name
+	}
+	return m.Name()
+}
+
+// metricID returns the item id of a metric, generated from the name it is registered under
+func (r *PCPRegistry) metricID(m PCPMetric) uint32 {
+	if name, ok := r.registeredName(m); ok {
+		return hash(name, PCPMetricItemBitLength)
+	}
+	return m.ID()
+}
+
+// indomID returns the id of an instance domain, generated from the name it is registered under
+func (r *PCPRegistry) indomID(indom *PCPInstanceDomain) uint32 {
+	if name, ok := r.registeredName(indom); ok {
+		return hash(name, PCPInstanceDomainBitLength)
+	}
+	return indom.ID()
+}
+
+// hasMetric checks if the passed metric itself is in the registry, under any name
+func (r *PCPRegistry) hasMetric(m PCPMetric) bool {
+	if _, ok := r.registeredName(m); ok {
+		return true
+	}
+
+	r.metricslock.RLock()
+	defer r.metricslock.RUnlock()
+
+	return r.metrics[m.Name()] == m
+}
+
+// hasInstanceDomain checks if the passed instance domain itself is in the registry, under any name
+func (r *PCPRegistry) hasInstanceDomain(indom *PCPInstanceDomain) bool {
+	if _, ok := r.registeredName(indom); ok {
+		return true
+	}
+
+	r.indomlock.RLock()
+	defer r.indomlock.RUnlock()
+
+	return r.instanceDomains[indom.name] == indom
+}
+
+// pcpMetric returns the metric of the passed name and whether it is present
+func (r *PCPRegistry) pcpMetric(name string) (PCPMetric, bool) {
+	r.metricslock.RLock()
+	defer r.metricslock.RUnlock()
+
+	m, present := r.metrics[name]
+	return m, present
+}
+
+// Metrics returns all metrics in the registry sorted by name
+func (r *PCPRegistry) Metrics() []Metric {
+	ms := r.pcpMetrics()
+	ans := make([]Metric, len(ms))
+	for i, m := range ms {
+		ans[i] = m
+	}
+	return ans
+}
+
+// Metric returns the metric of the passed name and whether it is present
+func (r *PCPRegistry) Metric(name string) (Metric, bool) {
+	m, present := r.pcpMetric(name)
+	if !present {
+		return nil, false
+	}
+	return m, true
+}
+
+// InstanceDomains returns all instance domains in the registry sorted by name
+func (r *PCPRegistry) InstanceDomains() []InstanceDomain {
+	indoms := r.pcpInstanceDomains()
+	ans := make([]InstanceDomain, len(indoms))
+	for i, indom := range indoms {
+		ans[i] = indom
+	}
+	return ans
+}
+
+// Visit visits all instance domains and then all metrics in the registry in name order.
+// They are visited from a snapshot of the registry, so the visitor can use the registry.
+func (r *PCPRegistry) Visit(v Visitor) error {
+	return r.visit(r.pcpInstanceDomains(), r.pcpMetrics(), v)
+}
+
+func (r *PCPRegistry) visit(indoms []*PCPInstanceDomain, metrics []PCPMetric, v Visitor) error {
+	for _, indom := range indoms {
+		name := indom.name
+		if n, ok := r.registeredName(indom); ok {
+			name = n
+		}
+
+		if err := v.VisitInstanceDomain(name, indom); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range metrics {
+		if err := v.VisitMetric(r.metricName(m), m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // AddInstanceDomain will add a new instance domain to the current registry
 func (r *PCPRegistry) AddInstanceDomain(indom InstanceDomain) error {
-	if r.HasInstanceDomain(indom.Name()) {
+	return r.addInstanceDomain(indom.(*PCPInstanceDomain), indom.Name())
+}
+
+// addInstanceDomain adds an instance domain to the registry under the passed name
+func (r *PCPRegistry) addInstanceDomain(indom *PCPInstanceDomain, name string) error {
+	if r.HasInstanceDomain(name) || r.hasInstanceDomain(indom) {
 		return errors.New("InstanceDomain is already defined for the current registry")
 	}
 
@@ -144,7 +382,8 @@ func (r *PCPRegistry) AddInstanceDomain(indom InstanceDomain) error {
 		return errors.New("Cannot add an indom when a mapping is active")
 	}
 
-	r.instanceDomains[indom.Name()] = indom.(*PCPInstanceDomain)
+	r.instanceDomains[name] = indom
+	r.setName(indom, indom.name, name)
 	r.instanceCount += indom.InstanceCount()
 
 	if !r.version2 {
@@ -155,21 +394,22 @@ func (r *PCPRegistry) AddInstanceDomain(indom InstanceDomain) error {
 		}
 	}
 
-	if indom.(*PCPInstanceDomain).shortDescription != "" {
+	if indom.shortDescription != "" {
 		r.stringcount++
 	}
 
-	if indom.(*PCPInstanceDomain).longDescription != "" {
+	if indom.longDescription != "" {
 		r.stringcount++
 	}
 
 	return nil
 }
 
-func (r *PCPRegistry) addMetric(m PCPMetric) {
-	r.metrics[m.Name()] = m
+func (r *PCPRegistry) addMetric(m PCPMetric, name string) {
+	r.metrics[name] = m
+	r.setName(m, m.Name(), name)
 
-	if len(m.Name()) > MaxV1NameLength && !r.version2 {
+	if len(name) > MaxV1NameLength && !r.version2 {
 		r.version2 = true
 	}
 
@@ -194,19 +434,30 @@ func (r *PCPRegistry) addMetric(m PCPMetric) {
 
 // AddMetric will add a new metric to the current registry
 func (r *PCPRegistry) AddMetric(m Metric) error {
+	pcpm := m.(PCPMetric)
+
+	indomName := ""
+	if pcpm.Indom() != nil {
+		indomName = pcpm.Indom().Name()
+	}
+
+	return r.addMetricAs(pcpm, m.Name(), indomName)
+}
+
+// addMetricAs adds a metric to the registry under the passed name, along with its instance domain
+// under indomName unless it is already in the registry
+func (r *PCPRegistry) addMetricAs(m PCPMetric, name, indomName string) error {
 	if r.mapped {
 		return errors.New("cannot add a metric when a mapping is active")
 	}
 
-	if r.HasMetric(m.Name()) {
+	if r.HasMetric(name) || r.hasMetric(m) {
 		return errors.New("metric is already defined for the current registry")
 	}
 
-	pcpm := m.(PCPMetric)
-
 	// if it is an indom metric
-	if pcpm.Indom() != nil && !r.HasInstanceDomain(pcpm.Indom().Name()) {
-		err := r.AddInstanceDomain(pcpm.Indom())
+	if indom := m.Indom(); indom != nil && !r.hasInstanceDomain(indom) && !r.HasInstanceDomain(indomName) {
+		err := r.addInstanceDomain(indom, indomName)
 		if err != nil {
 			return err
 		}
@@ -215,7 +466,7 @@ func (r *PCPRegistry) AddMetric(m Metric) error {
 	r.metricslock.Lock()
 	defer r.metricslock.Unlock()
 
-	r.addMetric(pcpm)
+	r.addMetric(m, name)
 	return nil
 }
 
@@ -331,3 +582,199 @@ func (r *PCPRegistry) AddMetricByString(str string, val interface{}, t MetricTyp
 
 	return r.addInstanceMetricByString(metric, val, indom, instances, t, s, u)
 }
+
+var prefixreg = regexp.MustCompile(fmt.Sprintf("\\A%v(\\.%v)*\\z", id, id))
+
+// WithPrefix returns a view of the registry that adds metrics and instance domains under the passed prefix,
+// so components can register their metrics independently without their names clashing.
+//
+// A metric added through the view as "size" with the prefix "db.pool" is registered as "db.pool.size",
+// and so is its instance domain, unless it is already in the registry or it is the instance domain
+// shared by all histograms. The registry keeps the prefixed names, so the metrics and instance domains
+// added through the view keep their own names and ids, and everything written from the registry uses
+// the prefixed ones. Names passed to the view and counts returned by it are relative to the prefix.
+func (r *PCPRegistry) WithPrefix(prefix string) (PrefixingRegistry, error) {
+	if !prefixreg.MatchString(prefix) {
+		return nil, errors.Errorf("invalid prefix %q", prefix)
+	}
+
+	return &prefixedRegistry{r, prefix + "."}, nil
+}
+
+// prefixedRegistry is a view of a PCPRegistry prefixing the names of everything added through it
+type prefixedRegistry struct {
+	r      *PCPRegistry
+	prefix string // the prefix followed by a dot
+}
+
+// name returns the name of a metric under the prefix, which has to fit in the metric names
+// of the MMV version the registry writes
+func (p *prefixedRegistry) name(name string) (string, error) {
+	max := MaxV1NameLength
+	if p.r.version2 {
+		max = StringLength
+	}
+
+	if len(p.prefix)+len(name) > max {
+		return "", errors.Errorf("metric name %v%v is longer than %v bytes", p.prefix, name, max)
+	}
+
+	return p.prefix + name, nil
+}
+
+func (p *prefixedRegistry) HasInstanceDomain(name string) bool {
+	return p.r.HasInstanceDomain(p.prefix + name)
+}
+
+func (p *prefixedRegistry) HasMetric(name string) bool { return p.r.HasMetric(p.prefix + name) }
+
+// metrics returns the metrics in the registry under the prefix sorted by name
+func (p *prefixedRegistry) metrics() []PCPMetric { return p.r.pcpMetricsUnder(p.prefix) }
+
+// indoms returns the instance domains in the registry under the prefix sorted by name
+func (p *prefixedRegistry) indoms() []*PCPInstanceDomain {
+	return p.r.pcpInstanceDomainsUnder(p.prefix)
+}
+
+// Metrics returns the metrics under the prefix
+func (p *prefixedRegistry) Metrics() []Metric {
+	ms := p.metrics()
+	ans := make([]Metric, len(ms))
+	for i, m := range ms {
+		ans[i] = m
+	}
+	return ans
+}
+
+func (p *prefixedRegistry) Metric(name string) (Metric, bool) { return p.r.Metric(p.prefix + name) }
+
+// InstanceDomains returns the instance domains under the prefix
+func (p *prefixedRegistry) InstanceDomains() []InstanceDomain {
+	indoms := p.indoms()
+	ans := make([]InstanceDomain, len(indoms))
+	for i, indom := range indoms {
+		ans[i] = indom
+	}
+	return ans
+}
+
+func (p *prefixedRegistry) Visit(v Visitor) error {
+	return p.r.visit(p.indoms(), p.metrics(), v)
+}
+func (p *prefixedRegistry) MetricCount() int { return len(p.metrics()) }
+
+func (p *prefixedRegistry) ValuesCount() int {
+	n := 0
+	for _, m := range p.metrics() {
+		if m.Indom() != nil {
+			n += m.Indom().InstanceCount()
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *prefixedRegistry) InstanceDomainCount() int { return len(p.indoms()) }
+
+func (p *prefixedRegistry) InstanceCount() int {
+	n := 0
+	for _, indom := range p.indoms() {
+		n += indom.InstanceCount()
+	}
+	return n
+}
+
+// StringCount counts strings the same way the registry does, for everything under the prefix
+func (p *prefixedRegistry) StringCount() int {
+	n := 0
+
+	for _, m := range p.metrics() {
+		if m.Type() == StringType {
+			if m.Indom() != nil {
+				n += m.Indom().InstanceCount()
+			} else {
+				n++
+			}
+		}
+
+		for _, d := range []string{m.ShortDescription(), m.LongDescription()} {
+			if d != "" {
+				n++
+			}
+		}
+	}
+
+	for _, indom := range p.indoms() {
+		for _, d := range []string{indom.shortDescription, indom.longDescription} {
+			if d != "" {
+				n++
+			}
+		}
+	}
+
+	if p.r.version2 {
+		n += p.MetricCount() + p.InstanceCount()
+	}
+
+	return n
+}
+
+// AddInstanceDomain adds the instance domain to the registry under the prefix
+func (p *prefixedRegistry) AddInstanceDomain(indom InstanceDomain) error {
+	pcpindom, ok := indom.(*PCPInstanceDomain)
+	if !ok {
+		return errors.Errorf("cannot add an instance domain of type %T", indom)
+	}
+
+	if pcpindom == histogramIndom {
+		return p.r.AddInstanceDomain(pcpindom)
+	}
+
+	return p.r.addInstanceDomain(pcpindom, p.prefix+pcpindom.name)
+}
+
+func (p *prefixedRegistry) AddInstanceDomainByName(name string, instances []string) (InstanceDomain, error) {
+	return p.r.AddInstanceDomainByName(p.prefix+name, instances)
+}
+
+// AddMetric adds the metric to the registry under the prefix, along with its instance domain
+// unless it is already in the registry
+func (p *prefixedRegistry) AddMetric(m Metric) error {
+	pcpm, ok := m.(PCPMetric)
+	if !ok {
+		return errors.Errorf("cannot add a metric of type %T", m)
+	}
+
+	name, err := p.name(m.Name())
+	if err != nil {
+		return err
+	}
+
+	indomName := ""
+	if indom := pcpm.Indom(); indom == histogramIndom {
+		indomName = indom.name
+	} else if indom != nil {
+		indomName = p.prefix + indom.name
+	}
+
+	return p.r.addMetricAs(pcpm, name, indomName)
+}
+
+// AddMetricByString adds a metric under the prefix, along with its instance domain if it has one
+func (p *prefixedRegistry) AddMetricByString(str string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) (Metric, error) {
+	metric, _, _, err := parseString(str)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = p.name(metric); err != nil {
+		return nil, err
+	}
+
+	return p.r.AddMetricByString(p.prefix+str, val, t, s, u)
+}
+
+func (p *prefixedRegistry) WithPrefix(prefix string) (PrefixingRegistry, error) {
+	return p.r.WithPrefix(p.prefix + prefix)
+}
@@ -1,6 +1,12 @@
 package speed
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed/v4/mmvdump"
+	"github.com/pkg/errors"
+)
 
 func TestIdentifierRegex(t *testing.T) {
 	cases := []struct {
@@ -152,3 +158,350 @@ func TestMMV2MetricRegistration(t *testing.T) {
 		t.Errorf("expected the metric name to be registered in the strings section")
 	}
 }
+
+func TestWithPrefix(t *testing.T) {
+	r := NewPCPRegistry()
+
+	for _, prefix := range []string{"", ".db", "db.", "db..pool", "db pool"} {
+		if _, err := r.WithPrefix(prefix); err == nil {
+			t.Errorf("expected prefix %q to be invalid", prefix)
+		}
+	}
+
+	pool, err := r.WithPrefix("db.pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := r.WithPrefix("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same names in two components do not clash
+	for _, reg := range []PrefixingRegistry{pool, cache} {
+		size, err := NewPCPGauge(0, "size", "number of entries")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = reg.AddMetric(size); err != nil {
+			t.Fatal(err)
+		}
+
+		waits, err := NewPCPCounterVector(map[string]int64{"a": 0, "b": 0}, "waits")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = reg.AddMetric(waits); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size, err := NewPCPGauge(0, "size")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddMetric(size); err == nil {
+		t.Error("expected adding size twice under the same prefix to fail")
+	}
+
+	if size.Name() != "size" {
+		t.Errorf("expected a metric that failed to be added to keep its name, got %v", size.Name())
+	}
+
+	for _, name := range []string{"db.pool.size", "db.pool.waits", "cache.size", "cache.waits"} {
+		if !r.HasMetric(name) {
+			t.Errorf("expected the registry to have %v", name)
+		}
+	}
+
+	for _, name := range []string{"db.pool.waits.indom", "cache.waits.indom"} {
+		if !r.HasInstanceDomain(name) {
+			t.Errorf("expected the registry to have the instance domain %v", name)
+		}
+	}
+
+	if !pool.HasMetric("size") || pool.HasMetric("db.pool.size") || !pool.HasInstanceDomain("waits.indom") {
+		t.Error("expected names passed to the view to be relative to its prefix")
+	}
+
+	if pool.MetricCount() != 2 || pool.InstanceDomainCount() != 1 || pool.InstanceCount() != 2 || pool.ValuesCount() != 3 || pool.StringCount() != 1 {
+		t.Errorf("unexpected counts %v metrics, %v indoms, %v instances, %v values and %v strings",
+			pool.MetricCount(), pool.InstanceDomainCount(), pool.InstanceCount(), pool.ValuesCount(), pool.StringCount())
+	}
+
+	if r.MetricCount() != 4 || r.ValuesCount() != 6 {
+		t.Errorf("expected 4 metrics with 6 values in the registry, got %v and %v", r.MetricCount(), r.ValuesCount())
+	}
+
+	conns, err := pool.WithPrefix("conns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = conns.AddMetricByString("open[a,b].count", Instances{"a": 1, "b": 2}, Int32Type, InstantSemantics, OneUnit); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.HasMetric("db.pool.conns.open.count") || !r.HasInstanceDomain("db.pool.conns.open") {
+		t.Error("expected nested prefixes to add up")
+	}
+
+	indom, err := NewPCPInstanceDomain("shards", []string{"0", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.AddInstanceDomain(indom); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.HasInstanceDomain("cache.shards") || r.indomID(indom) != hash("cache.shards", PCPInstanceDomainBitLength) {
+		t.Error("expected the instance domain to be registered under the prefix")
+	}
+
+	if indom.Name() != "shards" || indom.ID() != hash("shards", PCPInstanceDomainBitLength) {
+		t.Errorf("expected the instance domain to keep its name and id, got %v", indom.Name())
+	}
+
+	if err = pool.AddInstanceDomain(indom); err == nil {
+		t.Error("expected adding an instance domain that is already in the registry to fail")
+	}
+
+	hits, err := NewPCPInstanceMetric(Instances{"0": 0, "1": 0}, "hits", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.AddMetric(hits); err != nil {
+		t.Fatal(err)
+	}
+
+	if hits.Name() != "hits" || hits.ID() != hash("hits", PCPMetricItemBitLength) {
+		t.Errorf("expected the metric to keep its name and id, got %v", hits.Name())
+	}
+
+	if r.metricName(hits) != "cache.hits" || r.metricID(hits) != hash("cache.hits", PCPMetricItemBitLength) || r.InstanceDomainCount() != 4 {
+		t.Errorf("expected the metric to be registered under the prefix with its registered instance domain, got %v", r.metricName(hits))
+	}
+
+	if err = pool.AddMetric(hits); err == nil {
+		t.Error("expected adding a metric that is already in the registry to fail")
+	}
+
+	h, err := NewPCPHistogram("latency", 0, 100, 3, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddMetric(h); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Indom().Name() != "histogram" {
+		t.Errorf("expected the shared histogram instance domain to keep its name, got %v", h.Indom().Name())
+	}
+
+	long := strings.Repeat("a", MaxV1NameLength-len("db.pool.")+1)
+
+	m, err := NewPCPSingletonMetric(int32(0), long, Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddMetric(m); err == nil {
+		t.Error("expected a metric name too long for MMV version 1 with the prefix to fail")
+	}
+
+	if _, err = pool.AddMetricByString(long, int32(0), Int32Type, InstantSemantics, OneUnit); err == nil {
+		t.Error("expected a metric name too long for MMV version 1 with the prefix to fail")
+	}
+
+	if r.version2 {
+		t.Error("expected the registry to keep writing MMV version 1")
+	}
+
+	// an instance domain registered with the registry first keeps its name for all views
+	shared, err := r.AddInstanceDomainByName("nodes", []string{"n1", "n2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reg := range []PrefixingRegistry{pool, cache} {
+		up, err := NewPCPInstanceMetric(Instances{"n1": 1, "n2": 1}, "up", shared.(*PCPInstanceDomain), Int32Type, InstantSemantics, OneUnit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = reg.AddMetric(up); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if shared.Name() != "nodes" || !r.HasMetric("db.pool.up") || !r.HasMetric("cache.up") {
+		t.Errorf("expected the shared instance domain to keep its name, got %v", shared.Name())
+	}
+}
+
+func TestWithPrefixClient(t *testing.T) {
+	c, err := NewInMemoryPCPClient("app", NewPCPRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := c.Registry().(PrefixingRegistry).WithPrefix("db.pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := NewPCPGauge(3, "size")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddMetric(size); err != nil {
+		t.Fatal(err)
+	}
+
+	waits, err := NewPCPCounterVector(map[string]int64{"a": 1, "b": 2}, "waits")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddMetric(waits); err != nil {
+		t.Fatal(err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	r, err := mmvdump.NewReader(c.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := r.Value("db.pool.size", ""); err != nil || v != 3.0 {
+		t.Errorf("expected db.pool.size to be 3, got %v, %v", v, err)
+	}
+
+	if v, err := r.Value("db.pool.waits", "b"); err != nil || v != int64(2) {
+		t.Errorf("expected db.pool.waits[b] to be 2, got %v, %v", v, err)
+	}
+
+	if size.Name() != "size" {
+		t.Errorf("expected the metric to keep its name, got %v", size.Name())
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	r := NewPCPRegistry()
+
+	if len(r.Metrics()) != 0 || len(r.InstanceDomains()) != 0 {
+		t.Error("expected an empty registry to have no metrics and instance domains")
+	}
+
+	for _, name := range []string{"b", "c", "a"} {
+		m, err := NewPCPCounter(0, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = r.AddMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool, err := r.WithPrefix("pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waits, err := NewPCPCounterVector(map[string]int64{"x": 0, "y": 0}, "waits")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddMetric(waits); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, m := range r.Metrics() {
+		names = append(names, m.Name())
+	}
+
+	if strings.Join(names, ",") != "a,b,c,waits" {
+		t.Errorf("expected the metrics sorted by the names they are registered under, got %v", names)
+	}
+
+	if m, ok := r.Metric("pool.waits"); !ok || m != Metric(waits) {
+		t.Error("expected to look up pool.waits")
+	}
+
+	if _, ok := r.Metric("d"); ok {
+		t.Error("expected not to look up an unregistered metric")
+	}
+
+	view := pool.(Introspector)
+
+	if m, ok := view.Metric("waits"); !ok || m != Metric(waits) {
+		t.Error("expected names passed to the view to be relative to its prefix")
+	}
+
+	if ms := view.Metrics(); len(ms) != 1 || ms[0] != Metric(waits) {
+		t.Errorf("expected only the metrics under the prefix in the view, got %v", ms)
+	}
+
+	indoms := r.InstanceDomains()
+	if len(indoms) != 1 || indoms[0] != InstanceDomain(waits.Indom()) || len(view.InstanceDomains()) != 1 {
+		t.Errorf("unexpected instance domains %v", indoms)
+	}
+
+	var visited []string
+	err = r.Visit(visitor{
+		indom:  func(name string, i InstanceDomain) error { visited = append(visited, name); return nil },
+		metric: func(name string, m Metric) error { visited = append(visited, name); return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(visited, ",") != "pool.waits.indom,a,b,c,pool.waits" {
+		t.Errorf("expected instance domains visited before metrics, got %v", visited)
+	}
+
+	// a visitor can stop visiting, and add metrics to the registry it visits
+	count := 0
+	stop := errors.New("stop")
+	err = r.Visit(MetricVisitorFunc(func(name string, m Metric) error {
+		count++
+		if count == 2 {
+			return stop
+		}
+
+		g, err := NewPCPGauge(0, name+".copy")
+		if err != nil {
+			return err
+		}
+		return r.AddMetric(g)
+	}))
+
+	if err != stop || count != 2 {
+		t.Errorf("expected visiting to stop at the second metric, got %v after %v", err, count)
+	}
+
+	if !r.HasMetric("a.copy") || r.HasMetric("b.copy") {
+		t.Error("expected a metric added while visiting the first metric")
+	}
+}
+
+type visitor struct {
+	indom  func(string, InstanceDomain) error
+	metric func(string, Metric) error
+}
+
+func (v visitor) VisitInstanceDomain(name string, i InstanceDomain) error { return v.indom(name, i) }
+func (v visitor) VisitMetric(name string, m Metric) error                 { return v.metric(name, m) }
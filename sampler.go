@@ -0,0 +1,150 @@
+package speed
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Collector defines a type that refreshes a set of metrics from some
+// external source every time it is called.
+type Collector interface {
+	Collect() error
+}
+
+// CollectorFunc is an adapter allowing ordinary functions to be used as Collectors.
+type CollectorFunc func() error
+
+// Collect calls f.
+func (f CollectorFunc) Collect() error { return f() }
+
+// Sampler runs a set of Collectors periodically at a fixed interval.
+type Sampler struct {
+	mutex sync.Mutex
+
+	interval     time.Duration
+	collectors   []Collector
+	errorHandler func(error)
+
+	stopc chan struct{}
+	donec chan struct{}
+}
+
+// NewSampler creates a new Sampler that runs the passed collectors every interval
+// once it is started.
+func NewSampler(interval time.Duration, collectors ...Collector) (*Sampler, error) {
+	if interval <= 0 {
+		return nil, errors.New("sampling interval has to be positive")
+	}
+
+	return &Sampler{
+		interval:   interval,
+		collectors: collectors,
+	}, nil
+}
+
+// Add adds a collector to the Sampler.
+func (s *Sampler) Add(c Collector) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.collectors = append(s.collectors, c)
+}
+
+// SetErrorHandler sets a function that is called with every error
+// a collector returns while sampling in the background.
+// By default, errors are discarded.
+func (s *Sampler) SetErrorHandler(f func(error)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errorHandler = f
+}
+
+// Sample runs all collectors once. All collectors are run even if some of them fail,
+// and the first error encountered is returned.
+func (s *Sampler) Sample() error {
+	s.mutex.Lock()
+	collectors, handler := s.collectors, s.errorHandler
+	s.mutex.Unlock()
+
+	var first error
+	for _, c := range collectors {
+		if err := c.Collect(); err != nil {
+			if handler != nil {
+				handler(err)
+			}
+
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
+}
+
+// Start starts sampling in a separate goroutine.
+// The collectors are run once immediately and then once every interval.
+func (s *Sampler) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopc != nil {
+		return errors.New("trying to start an already started sampler")
+	}
+
+	s.stopc, s.donec = make(chan struct{}), make(chan struct{})
+	go s.run(s.stopc, s.donec)
+
+	return nil
+}
+
+func (s *Sampler) run(stopc, donec chan struct{}) {
+	defer close(donec)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		_ = s.Sample()
+
+		select {
+		case <-stopc:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MustStart is a Start that panics on failure.
+func (s *Sampler) MustStart() {
+	if err := s.Start(); err != nil {
+		panic(err)
+	}
+}
+
+// Stop stops sampling, waiting for a running sample to finish.
+func (s *Sampler) Stop() error {
+	s.mutex.Lock()
+	stopc, donec := s.stopc, s.donec
+	s.stopc, s.donec = nil, nil
+	s.mutex.Unlock()
+
+	if stopc == nil {
+		return errors.New("trying to stop an already stopped sampler")
+	}
+
+	close(stopc)
+	<-donec
+
+	return nil
+}
+
+// MustStop is a Stop that panics on failure.
+func (s *Sampler) MustStop() {
+	if err := s.Stop(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,88 @@
+package speed
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSamplerConstruction(t *testing.T) {
+	if _, err := NewSampler(0); err == nil {
+		t.Error("expected a zero interval to generate an error")
+	}
+
+	if _, err := NewSampler(-time.Second); err == nil {
+		t.Error("expected a negative interval to generate an error")
+	}
+}
+
+func TestSamplerSample(t *testing.T) {
+	var calls int32
+
+	ok := CollectorFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	failure := errors.New("failure")
+	failing := CollectorFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		return failure
+	})
+
+	s, err := NewSampler(time.Second, failing, ok)
+	if err != nil {
+		t.Fatalf("cannot create sampler, error: %v", err)
+	}
+
+	var handled []error
+	s.SetErrorHandler(func(err error) { handled = append(handled, err) })
+
+	if err = s.Sample(); err != failure {
+		t.Errorf("expected Sample to return the collector error, got %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected all collectors to run despite failures, got %v calls", calls)
+	}
+
+	if len(handled) != 1 || handled[0] != failure {
+		t.Errorf("expected the error handler to receive the collector error, got %v", handled)
+	}
+}
+
+func TestSamplerStartStop(t *testing.T) {
+	c, err := NewPCPCounter(0, "sampler.ticks")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	s, err := NewSampler(time.Millisecond, CollectorFunc(func() error { return c.Inc(1) }))
+	if err != nil {
+		t.Fatalf("cannot create sampler, error: %v", err)
+	}
+
+	s.MustStart()
+
+	if err = s.Start(); err == nil {
+		t.Error("expected starting a started sampler to generate an error")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	s.MustStop()
+
+	v := c.Val()
+	if v < 2 {
+		t.Errorf("expected the collector to run multiple times, ran %v times", v)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if c.Val() != v {
+		t.Error("expected the collector to not run after stopping")
+	}
+
+	if err = s.Stop(); err == nil {
+		t.Error("expected stopping a stopped sampler to generate an error")
+	}
+}
@@ -0,0 +1,93 @@
+//go:build go1.21
+// +build go1.21
+
+package speed
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLevels are the instances a slog counter is expected to have,
+// every record is counted under the nearest standard level at or below its own.
+var SlogLevels = []string{"debug", "info", "warn", "error"}
+
+// SlogHandler is a slog.Handler that counts every record it handles by level into a
+// CounterVector before passing it on to another handler.
+//
+// If the handler is inside a group and the counter has an instance named `<group>:<level>`,
+// the record is counted under that instance, otherwise under `<level>`. Groups are joined by dots,
+// so records logged by `logger.WithGroup("db").WithGroup("pool")` look for `db.pool:error`.
+type SlogHandler struct {
+	inner   slog.Handler
+	counter CounterVector
+	group   string
+}
+
+// NewSlogHandler creates a new SlogHandler counting records into counter and delegating to inner.
+func NewSlogHandler(inner slog.Handler, counter CounterVector) *SlogHandler {
+	return &SlogHandler{inner: inner, counter: counter}
+}
+
+// NewSlogCounterVector creates a PCPCounterVector with instances for all SlogLevels,
+// and if groups are passed, for all levels in each group.
+func NewSlogCounterVector(name string, groups []string, desc ...string) (*PCPCounterVector, error) {
+	vals := make(map[string]int64)
+	for _, l := range SlogLevels {
+		vals[l] = 0
+		for _, g := range groups {
+			vals[g+":"+l] = 0
+		}
+	}
+
+	return NewPCPCounterVector(vals, name, desc...)
+}
+
+// slogLevel maps a slog.Level to one of SlogLevels
+func slogLevel(l slog.Level) string {
+	switch {
+	case l < slog.LevelInfo:
+		return "debug"
+	case l < slog.LevelWarn:
+		return "info"
+	case l < slog.LevelError:
+		return "warn"
+	}
+	return "error"
+}
+
+// Enabled reports whether the inner handler handles records at the given level.
+func (h *SlogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.inner.Enabled(ctx, l)
+}
+
+// Handle counts the record and passes it on to the inner handler.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	l := slogLevel(r.Level)
+	if h.group == "" || h.counter.Inc(1, h.group+":"+l) != nil {
+		// counting must never stop a record from being logged, so failures are ignored
+		_ = h.counter.Inc(1, l)
+	}
+
+	return h.inner.Handle(ctx, r)
+}
+
+// WithAttrs returns a SlogHandler whose inner handler has the passed attributes.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SlogHandler{h.inner.WithAttrs(attrs), h.counter, h.group}
+}
+
+// WithGroup returns a SlogHandler counting records under the passed group,
+// nested inside any current group.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	group := name
+	if h.group != "" {
+		group = h.group + "." + name
+	}
+
+	return &SlogHandler{h.inner.WithGroup(name), h.counter, group}
+}
@@ -0,0 +1,71 @@
+//go:build go1.21
+// +build go1.21
+
+package speed
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	c, err := NewSlogCounterVector("log.records", []string{"db"})
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	inner := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	logger := slog.New(NewSlogHandler(inner, c))
+
+	logger.Info("started")
+	logger.Warn("slow")
+	logger.Log(context.Background(), slog.LevelError+4, "fatal")
+	logger.Debug("details")
+	logger.WithGroup("db").Error("connection lost")
+	logger.WithGroup("http").Error("bad request")
+	logger.WithGroup("db").WithGroup("pool").Error("exhausted")
+
+	expected := map[string]int64{
+		"debug":    1,
+		"info":     1,
+		"warn":     1,
+		"error":    3,
+		"db:error": 1,
+		"db:info":  0,
+	}
+
+	for ins, e := range expected {
+		if v, err := c.Val(ins); err != nil || v != e {
+			t.Errorf("expected %v to be %v, got %v, error: %v", ins, e, v, err)
+		}
+	}
+
+	if n := strings.Count(buf.String(), "\n"); n != 7 {
+		t.Errorf("expected all 7 records to be passed to the inner handler, got %v", n)
+	}
+}
+
+func TestSlogHandlerEnabled(t *testing.T) {
+	c, err := NewSlogCounterVector("log.filtered", nil)
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(NewSlogHandler(slog.NewTextHandler(&buf, nil), c))
+
+	logger.Debug("ignored")
+	logger.Info("logged")
+
+	if v, _ := c.Val("debug"); v != 0 {
+		t.Errorf("expected records disabled by the inner handler to not be counted, got %v", v)
+	}
+
+	if v, _ := c.Val("info"); v != 1 {
+		t.Errorf("expected info to be 1, got %v", v)
+	}
+}
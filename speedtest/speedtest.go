@@ -0,0 +1,327 @@
+// Package speedtest provides an in-memory speed client for asserting
+// instrumentation in unit tests, without a PCP installation or memory mapped files.
+//
+//	c := speedtest.NewClient("myapp")
+//	c.MustRegister(requests)
+//	c.MustStart()
+//	defer c.MustStop()
+//
+//	handle(req)
+//
+//	c.AssertCounter(t, "http.requests", 5)
+package speedtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/performancecopilot/speed/v4"
+	"github.com/performancecopilot/speed/v4/mmvdump"
+)
+
+var update = flag.Bool("speedtest.update", false, "update the golden files compared by AssertGolden")
+
+// Client is a speed.Client that writes MMV data to memory, with assertions on what it wrote.
+//
+// Assertions read the data the client has written rather than the values held by
+// metrics, so they also check that updates reach the MMV data.
+type Client struct {
+	*speed.PCPClient
+}
+
+// NewClient creates a Client with a new registry.
+func NewClient(name string) *Client {
+	return NewClientWithRegistry(name, speed.NewPCPRegistry())
+}
+
+// NewClientWithRegistry creates a Client with the given registry.
+func NewClientWithRegistry(name string, registry *speed.PCPRegistry) *Client {
+	c, err := speed.NewInMemoryPCPClient(name, registry)
+	if err != nil {
+		panic(err)
+	}
+	return &Client{c}
+}
+
+// Reader returns a mmvdump.Reader over a copy of the data currently written by the client.
+func (c *Client) Reader() (*mmvdump.Reader, error) {
+	data := c.Bytes()
+	if data == nil {
+		return nil, errNotStarted
+	}
+	return mmvdump.NewReader(data)
+}
+
+var errNotStarted = errors.New("the client has not been started")
+
+// value reads the value of a metric for an instance, failing the test if it cannot be read
+func (c *Client) value(t testing.TB, metric, instance string) (*mmvdump.MetricDesc, interface{}, bool) {
+	t.Helper()
+
+	r, err := c.Reader()
+	if err != nil {
+		t.Errorf("cannot read the data written for %v: %v", metric, err)
+		return nil, nil, false
+	}
+
+	m, ok := r.Metric(metric)
+	if !ok {
+		t.Errorf("no metric named %v was written", metric)
+		return nil, nil, false
+	}
+
+	v, err := r.Value(metric, instance)
+	if err != nil {
+		t.Errorf("%v", err)
+		return nil, nil, false
+	}
+
+	return m, v, true
+}
+
+// AssertCounter checks that a singleton counter was written with the expected value.
+func (c *Client) AssertCounter(t testing.TB, metric string, expected int64) {
+	t.Helper()
+
+	m, v, ok := c.value(t, metric, "")
+	if !ok {
+		return
+	}
+
+	if m.Semantics != mmvdump.CounterSemantics {
+		t.Errorf("expected %v to be a counter, it has %v", metric, m.Semantics)
+	}
+
+	if !Equal(v, expected) {
+		t.Errorf("expected %v to be %v, got %v", metric, expected, v)
+	}
+}
+
+// AssertGauge checks that a singleton gauge was written with the expected value.
+func (c *Client) AssertGauge(t testing.TB, metric string, expected float64) {
+	t.Helper()
+
+	m, v, ok := c.value(t, metric, "")
+	if !ok {
+		return
+	}
+
+	if m.Semantics != mmvdump.InstantSemantics {
+		t.Errorf("expected %v to be a gauge, it has %v", metric, m.Semantics)
+	}
+
+	if !Equal(v, expected) {
+		t.Errorf("expected %v to be %v, got %v", metric, expected, v)
+	}
+}
+
+// AssertValue checks that a singleton metric was written with the expected value.
+// Numbers are compared by value, so an untyped constant can be passed for any metric type.
+func (c *Client) AssertValue(t testing.TB, metric string, expected interface{}) {
+	t.Helper()
+
+	if _, v, ok := c.value(t, metric, ""); ok && !Equal(v, expected) {
+		t.Errorf("expected %v to be %v, got %v", metric, expected, v)
+	}
+}
+
+// AssertInstance checks that an instance metric was written with the expected value for an instance.
+// Numbers are compared by value, so an untyped constant can be passed for any metric type.
+func (c *Client) AssertInstance(t testing.TB, metric, instance string, expected interface{}) {
+	t.Helper()
+
+	if _, v, ok := c.value(t, metric, instance); ok && !Equal(v, expected) {
+		t.Errorf("expected %v[%v] to be %v, got %v", metric, instance, expected, v)
+	}
+}
+
+// AssertMissing checks that no metric with the passed name was written.
+func (c *Client) AssertMissing(t testing.TB, metric string) {
+	t.Helper()
+
+	r, err := c.Reader()
+	if err != nil {
+		t.Errorf("cannot read the data written for %v: %v", metric, err)
+		return
+	}
+
+	if _, ok := r.Metric(metric); ok {
+		t.Errorf("expected no metric named %v to be written", metric)
+	}
+}
+
+type goldenIndom struct {
+	Serial    uint32   `json:"serial"`
+	Instances []string `json:"instances"`
+	ShortText string   `json:"shorttext,omitempty"`
+	LongText  string   `json:"longtext,omitempty"`
+}
+
+type goldenMetric struct {
+	Name      string                 `json:"name"`
+	Item      uint32                 `json:"item"`
+	Type      string                 `json:"type"`
+	Semantics string                 `json:"semantics"`
+	Units     string                 `json:"units"`
+	Indom     int32                  `json:"indom,omitempty"`
+	ShortText string                 `json:"shorttext,omitempty"`
+	LongText  string                 `json:"longtext,omitempty"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+type golden struct {
+	Version int32           `json:"version"`
+	Cluster int32           `json:"cluster"`
+	Flags   int32           `json:"flags"`
+	Indoms  []*goldenIndom  `json:"indoms"`
+	Metrics []*goldenMetric `json:"metrics"`
+}
+
+// Golden returns a JSON document describing the data written by the client, with metrics
+// sorted by name, instance domains sorted by serial and instances sorted by name.
+// It leaves out everything that changes between runs, like the generation, the process id
+// and the layout of the data, which depends on the order metrics are written in.
+func (c *Client) Golden() ([]byte, error) {
+	r, err := c.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	h := r.Header()
+	g := &golden{Version: h.Version, Cluster: h.Cluster, Flags: h.Flag, Indoms: []*goldenIndom{}, Metrics: []*goldenMetric{}}
+
+	for _, d := range r.InstanceDomains() {
+		instances := append([]string{}, d.Instances...)
+		sort.Strings(instances)
+		g.Indoms = append(g.Indoms, &goldenIndom{d.Serial, instances, d.ShortText, d.LongText})
+	}
+
+	for _, m := range r.Metrics() {
+		values, err := r.Values(m.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		gm := &goldenMetric{
+			Name:      m.Name,
+			Item:      m.Item,
+			Type:      m.Type.String(),
+			Semantics: m.Semantics.String(),
+			Units:     m.Unit.String(),
+			ShortText: m.ShortText,
+			LongText:  m.LongText,
+			Values:    values,
+		}
+
+		if m.Indom != mmvdump.NoIndom {
+			gm.Indom = m.Indom
+		}
+
+		g.Metrics = append(g.Metrics, gm)
+	}
+
+	return json.MarshalIndent(g, "", "\t")
+}
+
+// AssertGolden compares the output of Golden with the golden file at path,
+// which is written instead when the tests are run with -speedtest.update.
+func (c *Client) AssertGolden(t testing.TB, path string) {
+	t.Helper()
+
+	actual, err := c.Golden()
+	if err != nil {
+		t.Errorf("cannot read the data written by the client: %v", err)
+		return
+	}
+	actual = append(actual, '\n')
+
+	if *update {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = ioutil.WriteFile(path, actual, 0644)
+		}
+		if err != nil {
+			t.Errorf("cannot update %v: %v", path, err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("cannot read %v, run the tests with -speedtest.update to create it: %v", path, err)
+		return
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("the data written by the client does not match %v, expected\n%s\ngot\n%s", path, expected, actual)
+	}
+}
+
+// Equal compares a value read from MMV data with an expected value, numbers are compared
+// by value regardless of their types, with float32 values compared at float32 precision.
+func Equal(actual, expected interface{}) bool {
+	a, e := reflect.ValueOf(actual), reflect.ValueOf(expected)
+	if !a.IsValid() || !e.IsValid() {
+		return actual == expected
+	}
+
+	switch {
+	case isInt(a) && isInt(e):
+		return a.Int() == e.Int()
+	case isUint(a) && isUint(e):
+		return a.Uint() == e.Uint()
+	case isInt(a) && isUint(e):
+		return a.Int() >= 0 && uint64(a.Int()) == e.Uint()
+	case isUint(a) && isInt(e):
+		return e.Int() >= 0 && a.Uint() == uint64(e.Int())
+	}
+
+	af, aok := float(a)
+	ef, eok := float(e)
+	if !aok || !eok {
+		return reflect.DeepEqual(actual, expected)
+	}
+
+	if a.Kind() == reflect.Float32 || e.Kind() == reflect.Float32 {
+		return float32(af) == float32(ef)
+	}
+
+	return af == ef || (math.IsNaN(af) && math.IsNaN(ef))
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func float(v reflect.Value) (float64, bool) {
+	switch {
+	case isInt(v):
+		return float64(v.Int()), true
+	case isUint(v):
+		return float64(v.Uint()), true
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,110 @@
+package speedtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/performancecopilot/speed/v4"
+)
+
+// recorder records the failures of assertions instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func newClient(t *testing.T) (*Client, *speed.PCPCounter, *speed.PCPGaugeVector) {
+	c := NewClient("speedtest")
+
+	counter, err := speed.NewPCPCounter(0, "http.requests", "Number of requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MustRegister(counter)
+
+	gauges, err := speed.NewPCPGaugeVector(map[string]float64{"a": 1, "b": 2}, "queue.length")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MustRegister(gauges)
+
+	c.MustRegisterString("build.version", "v1.2.3", speed.StringType, speed.DiscreteSemantics, speed.OneUnit)
+
+	return c, counter, gauges
+}
+
+func TestAssertions(t *testing.T) {
+	c, counter, gauges := newClient(t)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	counter.Inc(5)
+	gauges.MustSet(0.5, "b")
+
+	c.AssertCounter(t, "http.requests", 5)
+	c.AssertInstance(t, "queue.length", "a", 1)
+	c.AssertInstance(t, "queue.length", "b", 0.5)
+	c.AssertValue(t, "build.version", "v1.2.3")
+	c.AssertMissing(t, "http.responses")
+
+	r := &recorder{TB: t}
+	c.AssertCounter(r, "http.requests", 4)
+	c.AssertCounter(r, "http.responses", 5)
+	c.AssertGauge(r, "http.requests", 5)
+	c.AssertInstance(r, "queue.length", "c", 1)
+	c.AssertMissing(r, "http.requests")
+
+	if len(r.errors) != 5 {
+		t.Errorf("expected 5 failed assertions, got %v", r.errors)
+	}
+}
+
+func TestNotStarted(t *testing.T) {
+	c, _, _ := newClient(t)
+
+	r := &recorder{TB: t}
+	c.AssertCounter(r, "http.requests", 0)
+
+	if len(r.errors) != 1 {
+		t.Errorf("expected assertions on a client that is not started to fail, got %v", r.errors)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	c, counter, _ := newClient(t)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	counter.Inc(3)
+	c.AssertGolden(t, "testdata/client.golden")
+}
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		actual, expected interface{}
+		equal            bool
+	}{
+		{int32(5), 5, true},
+		{uint64(5), 5, true},
+		{int64(-1), uint64(1<<64 - 1), false},
+		{float32(0.1), 0.1, true},
+		{float64(0.1), float32(0.1), true},
+		{0.5, 1, false},
+		{"a", "a", true},
+		{"a", 1, false},
+	}
+
+	for _, c := range cases {
+		if Equal(c.actual, c.expected) != c.equal {
+			t.Errorf("expected Equal(%#v, %#v) to be %v", c.actual, c.expected, c.equal)
+		}
+	}
+}
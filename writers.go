@@ -0,0 +1,33 @@
+package speed
+
+import (
+	"time"
+
+	"github.com/performancecopilot/speed/v4/bytewriter"
+)
+
+// WriterFactory creates the writer a PCPClient writes its MMV data to when it starts,
+// given the location of its MMV file and the length of the data.
+//
+// The writer is closed when the client stops. Writers implementing bytewriter.FileWriter
+// remove their file if EraseFileOnStop is set, while other writers are only closed.
+type WriterFactory func(loc string, size int) (bytewriter.WriteCloser, error)
+
+// MemoryMappedWriterFactory writes to a memory mapped file at the location of the client,
+// replacing any existing file. This is what pmcd reads.
+func MemoryMappedWriterFactory(loc string, size int) (bytewriter.WriteCloser, error) {
+	return bytewriter.NewMemoryMappedWriter(loc, size)
+}
+
+// InMemoryWriterFactory writes to a plain byte slice, without creating any file.
+func InMemoryWriterFactory(loc string, size int) (bytewriter.WriteCloser, error) {
+	return bytewriter.NewByteWriter(size), nil
+}
+
+// SyncingWriterFactory returns a WriterFactory that writes to a memory mapped file at
+// the location of the client like MemoryMappedWriterFactory, flushing it to disk every interval.
+func SyncingWriterFactory(interval time.Duration) WriterFactory {
+	return func(loc string, size int) (bytewriter.WriteCloser, error) {
+		return bytewriter.NewSyncingWriter(loc, size, interval)
+	}
+}
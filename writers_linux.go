@@ -0,0 +1,27 @@
+package speed
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/performancecopilot/speed/v4/bytewriter"
+)
+
+// MemfdWriterFactory returns a WriterFactory that writes to an anonymous shared memory region
+// instead of a file, handing every new region to the passed function, for example to pass it
+// on to a sidecar exporting the metrics. The region is named after the client.
+func MemfdWriterFactory(handoff func(*os.File) error) WriterFactory {
+	return func(loc string, size int) (bytewriter.WriteCloser, error) {
+		w, err := bytewriter.NewMemfdWriter(filepath.Base(loc), size)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := handoff(w.File()); err != nil {
+			_ = w.Close()
+			return nil, err
+		}
+
+		return w, nil
+	}
+}
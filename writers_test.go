@@ -0,0 +1,100 @@
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterFactories(t *testing.T) {
+	c, err := NewPCPClient("test_writers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.SetWriterFactory(nil); err == nil {
+		t.Error("expected an error setting a nil factory")
+	}
+
+	m, err := NewPCPCounter(0, "test.writers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MustRegister(m)
+
+	factories := []struct {
+		name    string
+		f       WriterFactory
+		hasFile bool
+	}{
+		{"memory mapped", MemoryMappedWriterFactory, true},
+		{"in memory", InMemoryWriterFactory, false},
+		{"syncing", SyncingWriterFactory(time.Millisecond), true},
+	}
+
+	for _, tc := range factories {
+		_ = os.Remove(c.loc)
+
+		if err = c.SetWriterFactory(tc.f); err != nil {
+			t.Fatal(err)
+		}
+
+		c.MustStart()
+
+		if err = c.SetWriterFactory(tc.f); err == nil {
+			t.Errorf("%v: expected an error setting the factory of an active client", tc.name)
+		}
+
+		m.Inc(1)
+
+		if string(c.Bytes()[:3]) != "MMV" {
+			t.Errorf("%v: expected the client to write MMV data", tc.name)
+		}
+
+		c.MustStop()
+
+		data, err := ioutil.ReadFile(c.loc)
+		if tc.hasFile != (err == nil) {
+			t.Errorf("%v: expected a file to be written to be %v, got %v", tc.name, tc.hasFile, err)
+		}
+
+		if tc.hasFile && err == nil && len(data) != c.Length() {
+			t.Errorf("%v: expected %v bytes, got %v", tc.name, c.Length(), len(data))
+		}
+	}
+
+	_ = os.Remove(c.loc)
+}
+
+func TestEraseFileOnStopKeepsOtherFiles(t *testing.T) {
+	c, err := NewPCPClient("test_writers_erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.loc), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file at the location of the client that the client does not write to
+	if err = ioutil.WriteFile(c.loc, []byte("another process"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(c.loc) }()
+
+	if err = c.SetWriterFactory(InMemoryWriterFactory); err != nil {
+		t.Fatal(err)
+	}
+
+	EraseFileOnStop = true
+	defer func() { EraseFileOnStop = false }()
+
+	c.MustStart()
+	c.MustStop()
+
+	if _, err = os.Stat(c.loc); err != nil {
+		t.Errorf("expected a client not writing to a file to keep the file at its location, got %v", err)
+	}
+}